@@ -0,0 +1,478 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	azureApiVersion = "7.0"
+	// azureFileDiffsApiVersion is the first version with the file diffs API
+	azureFileDiffsApiVersion = "7.1"
+	azurePageSize            = 100
+	// azureContinuationHeader holds the token for the next page of a list that isn't paged with $skip
+	azureContinuationHeader = "x-ms-continuationtoken"
+
+	azureThreadActive  = "active"
+	azureThreadPending = "pending"
+	azureThreadFixed   = "fixed"
+	azureThreadClosed  = "closed"
+)
+
+// azureDevopsPlatform comments on pull requests in Azure Repos from Azure Pipelines, using the SYSTEM_* and
+// BUILD_* variables of a pull request build
+type azureDevopsPlatform struct {
+	token         string
+	collectionUrl string
+	project       string
+	repositoryId  string
+}
+
+func newAzureDevopsPlatform() (*azureDevopsPlatform, error) {
+	token := os.Getenv("INPUT_AZURE_DEVOPS_TOKEN")
+	if token == "" {
+		token = os.Getenv("SYSTEM_ACCESSTOKEN")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("the SYSTEM_ACCESSTOKEN has not been set, it has to be mapped into the environment of the step")
+	}
+
+	collectionUrl := os.Getenv("SYSTEM_COLLECTIONURI")
+	project := os.Getenv("SYSTEM_TEAMPROJECT")
+	repositoryId := os.Getenv("BUILD_REPOSITORY_ID")
+	if collectionUrl == "" || project == "" || repositoryId == "" {
+		return nil, fmt.Errorf("the SYSTEM_COLLECTIONURI, SYSTEM_TEAMPROJECT and BUILD_REPOSITORY_ID have not been set")
+	}
+	logf("Working in repository %s\n", os.Getenv("BUILD_REPOSITORY_NAME"))
+
+	return &azureDevopsPlatform{
+		token:         token,
+		collectionUrl: strings.TrimSuffix(collectionUrl, "/"),
+		project:       project,
+		repositoryId:  repositoryId,
+	}, nil
+}
+
+func (p *azureDevopsPlatform) name() string {
+	return "Azure DevOps"
+}
+
+// pullRequestNumber is the pull request id, which is only set for builds run by a pull request
+func (p *azureDevopsPlatform) pullRequestNumber() (int, error) {
+	id := os.Getenv("INPUT_PR_NUMBER")
+	if id == "" {
+		id = os.Getenv("SYSTEM_PULLREQUEST_PULLREQUESTID")
+	}
+	if id == "" {
+		return 0, fmt.Errorf("the SYSTEM_PULLREQUEST_PULLREQUESTID has not been set")
+	}
+	return strconv.Atoi(id)
+}
+
+func (p *azureDevopsPlatform) connect(prNo int) (prCommenter, error) {
+	return newAzureDevopsCommenter(&restClient{
+		httpClient: restHttpClient,
+		baseURL:    fmt.Sprintf("%s/%s/_apis/git/repositories/%s", p.collectionUrl, url.PathEscape(p.project), p.repositoryId),
+		header:     http.Header{"Authorization": []string{"Bearer " + p.token}},
+		owner:      p.project,
+		repo:       os.Getenv("BUILD_REPOSITORY_NAME"),
+		prNumber:   prNo,
+	})
+}
+
+func (p *azureDevopsPlatform) commentContext(prNo int, sha string) commentContext {
+	repo := os.Getenv("BUILD_REPOSITORY_NAME")
+	return commentContext{
+		Owner:       p.project,
+		Repo:        repo,
+		Repository:  p.project + "/" + repo,
+		PullRequest: prNo,
+		ServerURL:   p.collectionUrl,
+		SHA:         sha,
+	}
+}
+
+func (p *azureDevopsPlatform) readOnly() bool {
+	return false
+}
+
+type azureCommit struct {
+	CommitID string `json:"commitId"`
+}
+
+type azureIteration struct {
+	ID              int         `json:"id"`
+	SourceRefCommit azureCommit `json:"sourceRefCommit"`
+	CommonRefCommit azureCommit `json:"commonRefCommit"`
+}
+
+type azureChange struct {
+	Item struct {
+		Path string `json:"path"`
+	} `json:"item"`
+	OriginalPath string `json:"originalPath"`
+	ChangeType   string `json:"changeType"`
+}
+
+// azureLineChangeType is the change type of a block of lines, which the API writes as either a name or a number
+type azureLineChangeType string
+
+func (t *azureLineChangeType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = azureLineChangeType(strings.ToLower(name))
+		return nil
+	}
+	var number int
+	if err := json.Unmarshal(data, &number); err != nil {
+		return err
+	}
+	names := []string{"none", "add", "delete", "edit"}
+	if number < 0 || number >= len(names) {
+		return fmt.Errorf("unexpected line change type %d", number)
+	}
+	*t = azureLineChangeType(names[number])
+	return nil
+}
+
+type azureLineDiffBlock struct {
+	ChangeType              azureLineChangeType `json:"changeType"`
+	ModifiedLineNumberStart int                 `json:"modifiedLineNumberStart"`
+	ModifiedLinesCount      int                 `json:"modifiedLinesCount"`
+	OriginalLinesCount      int                 `json:"originalLinesCount"`
+}
+
+type azureFilePosition struct {
+	Line   int `json:"line"`
+	Offset int `json:"offset"`
+}
+
+type azureThreadContext struct {
+	FilePath       string             `json:"filePath"`
+	RightFileStart *azureFilePosition `json:"rightFileStart,omitempty"`
+	RightFileEnd   *azureFilePosition `json:"rightFileEnd,omitempty"`
+}
+
+// azureCommentType is the type of a comment, which the API writes as a name but is sent as a number
+type azureCommentType int
+
+const (
+	azureCommentText   azureCommentType = 1
+	azureCommentSystem azureCommentType = 3
+)
+
+func (t *azureCommentType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		var number int
+		if err := json.Unmarshal(data, &number); err != nil {
+			return err
+		}
+		*t = azureCommentType(number)
+		return nil
+	}
+	for number, known := range []string{"unknown", "text", "codeChange", "system"} {
+		if strings.EqualFold(name, known) {
+			*t = azureCommentType(number)
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected comment type %s", name)
+}
+
+type azureComment struct {
+	ID              int64            `json:"id,omitempty"`
+	ParentCommentID int64            `json:"parentCommentId,omitempty"`
+	Content         string           `json:"content"`
+	CommentType     azureCommentType `json:"commentType,omitempty"`
+	IsDeleted       bool             `json:"isDeleted,omitempty"`
+}
+
+type azureThread struct {
+	ID            int64               `json:"id,omitempty"`
+	Status        string              `json:"status,omitempty"`
+	ThreadContext *azureThreadContext `json:"threadContext,omitempty"`
+	Comments      []azureComment      `json:"comments,omitempty"`
+	IsDeleted     bool                `json:"isDeleted,omitempty"`
+}
+
+// azureDevopsCommenter writes pull request threads through the Azure DevOps REST API
+type azureDevopsCommenter struct {
+	reviewState
+	client        *restClient
+	prPath        string
+	summaryThread *azureThread
+}
+
+// newAzureDevopsCommenter loads the changes of the latest iteration of the pull request and the existing threads
+func newAzureDevopsCommenter(client *restClient) (*azureDevopsCommenter, error) {
+	c := &azureDevopsCommenter{
+		client: client,
+		prPath: fmt.Sprintf("/pullRequests/%d", client.prNumber),
+	}
+
+	iterations := struct {
+		Value []azureIteration `json:"value"`
+	}{}
+	if _, err := client.do(http.MethodGet, c.apiPath(c.prPath+"/iterations"), nil, &iterations); err != nil {
+		if _, ok := err.(restStatusError); ok {
+			return nil, newPrDoesNotExistError(client.owner, client.repo, client.prNumber)
+		}
+		return nil, err
+	}
+	if len(iterations.Value) == 0 {
+		return nil, fmt.Errorf("the pull request has no iterations")
+	}
+	iteration := iterations.Value[len(iterations.Value)-1]
+	c.headSHA = iteration.SourceRefCommit.CommitID
+
+	if err := c.loadChanges(iteration); err != nil {
+		return nil, fmt.Errorf("load pull request changes: %w", err)
+	}
+	if err := c.loadThreads(); err != nil {
+		return nil, fmt.Errorf("load pull request threads: %w", err)
+	}
+	return c, nil
+}
+
+// apiPath adds the api version to the path
+func (c *azureDevopsCommenter) apiPath(path string) string {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + "api-version=" + azureApiVersion
+}
+
+// loadChanges lists the files changed in the iteration, then gets the changed lines of each from the file diffs
+// API, as Azure DevOps doesn't return patches
+func (c *azureDevopsCommenter) loadChanges(iteration azureIteration) error {
+	var changes []azureChange
+	skip := 0
+	for {
+		page := struct {
+			ChangeEntries []azureChange `json:"changeEntries"`
+			NextSkip      int           `json:"nextSkip"`
+		}{}
+		path := fmt.Sprintf("%s/iterations/%d/changes?$top=%d&$skip=%d", c.prPath, iteration.ID, azurePageSize, skip)
+		if _, err := c.client.do(http.MethodGet, c.apiPath(path), nil, &page); err != nil {
+			return err
+		}
+		for _, change := range page.ChangeEntries {
+			if !strings.Contains(change.ChangeType, "delete") && change.Item.Path != "" {
+				changes = append(changes, change)
+			}
+		}
+		if page.NextSkip == 0 || len(page.ChangeEntries) == 0 {
+			break
+		}
+		skip = page.NextSkip
+	}
+
+	for i := 0; i < len(changes); i += azurePageSize {
+		end := i + azurePageSize
+		if end > len(changes) {
+			end = len(changes)
+		}
+		var params []map[string]string
+		for _, change := range changes[i:end] {
+			originalPath := change.OriginalPath
+			if originalPath == "" {
+				originalPath = change.Item.Path
+			}
+			params = append(params, map[string]string{"path": change.Item.Path, "originalPath": originalPath})
+		}
+		request := map[string]interface{}{
+			"baseVersionCommit":   iteration.CommonRefCommit.CommitID,
+			"targetVersionCommit": iteration.SourceRefCommit.CommitID,
+			"fileDiffParams":      params,
+		}
+
+		var fileDiffs []struct {
+			Path           string               `json:"path"`
+			LineDiffBlocks []azureLineDiffBlock `json:"lineDiffBlocks"`
+		}
+		path := fmt.Sprintf("/FileDiffs?api-version=%s", azureFileDiffsApiVersion)
+		if _, err := c.client.do(http.MethodPost, path, request, &fileDiffs); err != nil {
+			return err
+		}
+		for _, fileDiff := range fileDiffs {
+			var blocks []diffBlock
+			for _, block := range fileDiff.LineDiffBlocks {
+				if block.ChangeType == "none" {
+					continue
+				}
+				blocks = append(blocks, diffBlock{
+					newStart: block.ModifiedLineNumberStart,
+					oldLines: block.OriginalLinesCount,
+					newLines: block.ModifiedLinesCount,
+				})
+			}
+			c.files = append(c.files, &commitFileInfo{
+				FileName: strings.TrimPrefix(fileDiff.Path, "/"),
+				diff:     diffFromBlocks(blocks),
+				sha:      c.headSHA,
+			})
+		}
+	}
+	return nil
+}
+
+// loadThreads keeps the threads on a file for dedupe and cleanup, and finds the summary thread among the others
+func (c *azureDevopsCommenter) loadThreads() error {
+	var threads []azureThread
+	continuationToken := ""
+	for {
+		page := struct {
+			Value []azureThread `json:"value"`
+		}{}
+		path := c.prPath + "/threads"
+		if continuationToken != "" {
+			path += "?continuationToken=" + url.QueryEscape(continuationToken)
+		}
+		header, err := c.client.do(http.MethodGet, c.apiPath(path), nil, &page)
+		if err != nil {
+			return err
+		}
+		threads = append(threads, page.Value...)
+		continuationToken = header.Get(azureContinuationHeader)
+		if continuationToken == "" || len(page.Value) == 0 {
+			break
+		}
+	}
+
+	for i := range threads {
+		thread := &threads[i]
+		if thread.IsDeleted || len(thread.Comments) == 0 || thread.Comments[0].IsDeleted {
+			continue
+		}
+		comment := &thread.Comments[0]
+		if thread.ThreadContext == nil {
+			if c.summaryThread == nil && strings.Contains(comment.Content, summaryMarker) {
+				c.summaryThread = thread
+			}
+			continue
+		}
+		filename := strings.TrimPrefix(thread.ThreadContext.FilePath, "/")
+		resolved := thread.Status != azureThreadActive && thread.Status != azureThreadPending
+		// the comment is edited and cleaned up through its thread, so the thread id is kept rather than its own
+		c.existingComments = append(c.existingComments, &existingComment{
+			filename:    &filename,
+			comment:     &comment.Content,
+			commentId:   &thread.ID,
+			fingerprint: extractFingerprint(comment.Content),
+			resolved:    resolved,
+			fixed:       resolved && isFixedComment(lastReply(thread).Content),
+		})
+	}
+	return nil
+}
+
+// lastReply returns the last reply to the first comment of the thread, leaving out deleted and system comments,
+// or an empty comment if there isn't one
+func lastReply(thread *azureThread) azureComment {
+	for i := len(thread.Comments) - 1; i > 0; i-- {
+		if reply := thread.Comments[i]; !reply.IsDeleted && reply.CommentType != azureCommentSystem {
+			return reply
+		}
+	}
+	return azureComment{}
+}
+
+// WriteMultiLineComment queues a thread on the range, or edits the thread from an earlier run for the same finding
+func (c *azureDevopsCommenter) WriteMultiLineComment(file, comment string, startLine, endLine int) error {
+	existing, err := c.queueComment(file, comment, startLine, endLine)
+	if err != nil || existing == nil {
+		return err
+	}
+	if err := c.editFirstComment(*existing.commentId, comment); err != nil {
+		return fmt.Errorf("edit pull request thread: %w", err)
+	}
+	c.edited(existing, comment)
+	return nil
+}
+
+// editFirstComment changes the comment that started the thread, which is always the first
+func (c *azureDevopsCommenter) editFirstComment(threadId int64, comment string) error {
+	path := c.apiPath(fmt.Sprintf("%s/threads/%d/comments/1", c.prPath, threadId))
+	_, err := c.client.do(http.MethodPatch, path, azureComment{Content: comment}, nil)
+	return err
+}
+
+// SubmitReview starts a thread for every queued comment. Azure DevOps has no review event, so a request for
+// changes is written as comments like any other
+func (c *azureDevopsCommenter) SubmitReview(string) error {
+	for len(c.pending) > 0 {
+		pending := c.pending[0]
+		thread := azureThread{
+			Status:   azureThreadActive,
+			Comments: []azureComment{{Content: pending.comment, CommentType: azureCommentText}},
+			ThreadContext: &azureThreadContext{
+				FilePath:       "/" + pending.filename,
+				RightFileStart: &azureFilePosition{Line: pending.startLine, Offset: 1},
+				RightFileEnd:   &azureFilePosition{Line: pending.endLine, Offset: 1},
+			},
+		}
+		if _, err := c.client.do(http.MethodPost, c.apiPath(c.prPath+"/threads"), thread, nil); err != nil {
+			return fmt.Errorf("write pull request thread: %w", err)
+		}
+		c.pending = c.pending[1:]
+	}
+	return nil
+}
+
+// CleanupStaleComments marks the threads from earlier runs whose finding is no longer reported as fixed, or
+// closes them for minimize, which collapses them in the pull request
+func (c *azureDevopsCommenter) CleanupStaleComments(current []findingComment, scope, action string, reply bool) (int, error) {
+	if action == staleActionNone {
+		return 0, nil
+	}
+	status := azureThreadFixed
+	if action == staleActionMinimize {
+		status = azureThreadClosed
+	}
+
+	var cleaned int
+	for _, existing := range c.staleComments(current, scope) {
+		threadPath := fmt.Sprintf("%s/threads/%d", c.prPath, *existing.commentId)
+		if reply {
+			comment := azureComment{Content: c.fixedComment(), ParentCommentID: 1, CommentType: azureCommentText}
+			if _, err := c.client.do(http.MethodPost, c.apiPath(threadPath+"/comments"), comment, nil); err != nil {
+				return cleaned, fmt.Errorf("write reply comment: %w", err)
+			}
+		}
+		if _, err := c.client.do(http.MethodPatch, c.apiPath(threadPath), azureThread{Status: status}, nil); err != nil {
+			return cleaned, fmt.Errorf("%s stale comment: %w", action, err)
+		}
+		existing.resolved, existing.fixed = true, reply
+		cleaned++
+	}
+	return cleaned, nil
+}
+
+// PreviousSummary returns the body of the summary thread written on an earlier run, or empty if there isn't one
+func (c *azureDevopsCommenter) PreviousSummary() (string, error) {
+	if c.summaryThread == nil {
+		return "", nil
+	}
+	return c.summaryThread.Comments[0].Content, nil
+}
+
+// WriteSummaryComment writes the summary thread on the pull request, updating the one from an earlier run in
+// place. It is closed so it doesn't stop the pull request completing when comments have to be resolved
+func (c *azureDevopsCommenter) WriteSummaryComment(comment string) error {
+	if c.summaryThread != nil {
+		return c.editFirstComment(c.summaryThread.ID, comment)
+	}
+	thread := azureThread{
+		Status:   azureThreadClosed,
+		Comments: []azureComment{{Content: comment, CommentType: azureCommentText}},
+	}
+	_, err := c.client.do(http.MethodPost, c.apiPath(c.prPath+"/threads"), thread, nil)
+	return err
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+const (
+	azureTestRepo   = "/org/Infra/_apis/git/repositories/r1"
+	azureTestPr     = azureTestRepo + "/pullRequests/9"
+	azureTestCommit = "d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4"
+	// azureTestToken is the continuation token for the second page of threads, which has to be escaped
+	azureTestToken = "page/2+threads"
+)
+
+// newAzureTestApi serves the iterations, the changes of the last iteration over two pages, their file diffs and
+// the threads over two pages from testdata/azure-devops
+func newAzureTestApi(t *testing.T) *fakeApi {
+	api := newFakeApi(t)
+	api.replyFixture(http.MethodGet, azureTestPr+"/iterations?api-version=7.0", "azure-devops/iterations.json")
+	api.replyFixture(http.MethodGet, azureTestPr+"/iterations/2/changes?$top=100&$skip=0&api-version=7.0", "azure-devops/changes_page1.json")
+	api.replyFixture(http.MethodGet, azureTestPr+"/iterations/2/changes?$top=100&$skip=2&api-version=7.0", "azure-devops/changes_page2.json")
+	api.replyFixture(http.MethodPost, azureTestRepo+"/FileDiffs?api-version=7.1", "azure-devops/file_diffs.json")
+	api.handle(http.MethodGet, azureTestPr+"/threads?api-version=7.0", fakeResponse{
+		header: http.Header{"X-Ms-Continuationtoken": []string{azureTestToken}},
+		body:   readFixture(t, "azure-devops/threads_page1.json"),
+	})
+	api.replyFixture(http.MethodGet, azureTestPr+"/threads?continuationToken=page%2F2%2Bthreads&api-version=7.0", "azure-devops/threads_page2.json")
+	return api
+}
+
+func newAzureTestCommenter(t *testing.T, api *fakeApi) *azureDevopsCommenter {
+	t.Helper()
+	c, err := newAzureDevopsCommenter(&restClient{
+		httpClient: restHttpClient,
+		baseURL:    api.url() + azureTestRepo,
+		header:     http.Header{"Authorization": []string{"Bearer secret"}},
+		owner:      "Infra",
+		repo:       "infra",
+		prNumber:   9,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestAzureDevopsCommenterLoadsPullRequest(t *testing.T) {
+	api := newAzureTestApi(t)
+	defer api.close()
+	c := newAzureTestCommenter(t, api)
+
+	if c.HeadSHA() != azureTestCommit {
+		t.Errorf("HeadSHA = %s, want the source commit of the last iteration", c.HeadSHA())
+	}
+
+	// the deleted file is left out of the file diffs, and the renamed file is compared with its original path
+	request := struct {
+		BaseVersionCommit   string              `json:"baseVersionCommit"`
+		TargetVersionCommit string              `json:"targetVersionCommit"`
+		FileDiffParams      []map[string]string `json:"fileDiffParams"`
+	}{}
+	api.sentOnce(http.MethodPost, azureTestRepo+"/FileDiffs?api-version=7.1").decode(t, &request)
+	if request.BaseVersionCommit != "b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0" || request.TargetVersionCommit != azureTestCommit {
+		t.Errorf("file diffs between %s and %s", request.BaseVersionCommit, request.TargetVersionCommit)
+	}
+	if len(request.FileDiffParams) != 2 || request.FileDiffParams[1]["originalPath"] != "/infra/old/vpc.tf" {
+		t.Errorf("file diff params = %v", request.FileDiffParams)
+	}
+
+	// the blocks are turned into hunks with context lines, as git diff would
+	if len(c.files) != 2 {
+		t.Fatalf("got %d files, want 2", len(c.files))
+	}
+	if !c.InChangedLines("infra/main.tf", 2, 2) || c.InChangedLines("infra/main.tf", 3, 12) {
+		t.Errorf("only line 2 of infra/main.tf was added")
+	}
+	if !c.InDiff("infra/main.tf", 1, 5) || !c.InDiff("infra/main.tf", 10, 15) || c.InDiff("infra/main.tf", 7, 7) {
+		t.Errorf("infra/main.tf should have a hunk around each change")
+	}
+	if !c.InChangedLines("infra/new/vpc.tf", 3, 3) {
+		t.Errorf("line 3 of infra/new/vpc.tf was edited")
+	}
+
+	// the second page of threads is loaded with the continuation token, deleted threads and the summary are left out
+	var ids []int64
+	for _, existing := range c.existingComments {
+		ids = append(ids, *existing.commentId)
+	}
+	if len(ids) != 7 || ids[4] != 505 || ids[5] != 509 || ids[6] != 511 {
+		t.Fatalf("existing comments = %v, want the threads 501 to 505, 509 and 511", ids)
+	}
+	if !c.existingComments[2].resolved || c.existingComments[0].resolved || c.existingComments[5].resolved || !c.existingComments[6].resolved {
+		t.Errorf("only the fixed thread 503 and the won't fix thread 511 are resolved")
+	}
+	// 503 ends with the reply of the commenter, 511 was resolved by hand
+	if !c.existingComments[2].fixed || c.existingComments[6].fixed {
+		t.Errorf("only thread 503 was resolved as fixed by the commenter")
+	}
+	if c.summaryThread == nil || c.summaryThread.ID != 507 {
+		t.Errorf("summary thread = %+v, want 507", c.summaryThread)
+	}
+}
+
+func TestAzureDevopsCommenterPullRequestNotFound(t *testing.T) {
+	api := newFakeApi(t)
+	defer api.close()
+	api.handle(http.MethodGet, azureTestPr+"/iterations?api-version=7.0", fakeResponse{status: http.StatusNotFound, body: `{"message":"not found"}`})
+
+	_, err := newAzureDevopsCommenter(&restClient{httpClient: restHttpClient, baseURL: api.url() + azureTestRepo, prNumber: 9})
+	if _, ok := err.(PrDoesNotExistError); !ok {
+		t.Errorf("err = %v, want PrDoesNotExistError", err)
+	}
+}
+
+func TestAzureDevopsCommenterWritesThreads(t *testing.T) {
+	api := newAzureTestApi(t)
+	defer api.close()
+	api.reply(http.MethodPatch, azureTestPr+"/threads/502/comments/1?api-version=7.0", `{"id":1}`)
+	api.reply(http.MethodPost, azureTestPr+"/threads?api-version=7.0", `{"id":510}`)
+	c := newAzureTestCommenter(t, api)
+
+	added := testComment("aws-added", "f0f0f0f0")
+	multiLine := testComment("aws-multi-line", "f1f1f1f1")
+	acrossHunks := testComment("aws-across-hunks", "f2f2f2f2")
+	reintroduced := testComment("aws-resolved", "cccc3333")
+	reworded := testComment("aws-new-wording", "bbbb2222")
+
+	if _, ok := c.WriteMultiLineComment("infra/main.tf", testComment("aws-already-written", "aaaa1111"), 2, 2).(CommentAlreadyWrittenError); !ok {
+		t.Errorf("a comment from an earlier run should not be written again")
+	}
+	// an issue a person dismissed by resolving its comment isn't commented on again
+	if _, ok := c.WriteMultiLineComment("infra/main.tf", testComment("aws-dismissed", "acac8888"), 2, 2).(CommentAlreadyWrittenError); !ok {
+		t.Errorf("a comment resolved by hand should not be written again")
+	}
+	if err := c.WriteMultiLineComment("infra/main.tf", reworded, 3, 3); err != nil {
+		t.Fatal(err)
+	}
+	for _, comment := range []struct {
+		body       string
+		start, end int
+	}{
+		{body: added, start: 2, end: 2},
+		{body: multiLine, start: 1, end: 3},
+		{body: acrossHunks, start: 4, end: 11},
+		{body: reintroduced, start: 2, end: 2},
+	} {
+		if err := c.WriteMultiLineComment("infra/main.tf", comment.body, comment.start, comment.end); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var edit azureComment
+	api.sentOnce(http.MethodPatch, azureTestPr+"/threads/502/comments/1?api-version=7.0").decode(t, &edit)
+	if edit.Content != reworded {
+		t.Errorf("edit = %+v", edit)
+	}
+
+	if err := c.SubmitReview("COMMENT"); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		content    string
+		start, end int
+	}{
+		{content: added, start: 2, end: 2},
+		{content: multiLine, start: 1, end: 3},
+		// a range across hunks falls back to its last line
+		{content: acrossHunks, start: 11, end: 11},
+		{content: reintroduced, start: 2, end: 2},
+	}
+	posts := api.sent(http.MethodPost, azureTestPr+"/threads?api-version=7.0")
+	if len(posts) != len(want) {
+		t.Fatalf("got %d threads, want %d", len(posts), len(want))
+	}
+	for i, post := range posts {
+		var thread azureThread
+		post.decode(t, &thread)
+		if thread.Status != azureThreadActive || len(thread.Comments) != 1 || thread.Comments[0].Content != want[i].content {
+			t.Errorf("thread %d = %+v", i, thread)
+			continue
+		}
+		context := thread.ThreadContext
+		if context == nil || context.FilePath != "/infra/main.tf" || context.RightFileStart.Line != want[i].start || context.RightFileEnd.Line != want[i].end {
+			t.Errorf("thread %d context = %+v, want lines %d to %d", i, context, want[i].start, want[i].end)
+		}
+	}
+}
+
+func TestAzureDevopsCommenterClosesStaleThreads(t *testing.T) {
+	api := newAzureTestApi(t)
+	defer api.close()
+	api.reply(http.MethodPost, azureTestPr+"/threads/504/comments?api-version=7.0", `{"id":2}`)
+	api.reply(http.MethodPatch, azureTestPr+"/threads/504?api-version=7.0", `{"id":504}`)
+	c := newAzureTestCommenter(t, api)
+
+	current := []findingComment{
+		{filename: "infra/main.tf", comment: testComment("aws-already-written", "aaaa1111")},
+		{filename: "infra/main.tf", comment: testComment("aws-new-wording", "bbbb2222")},
+	}
+	cleaned, err := c.CleanupStaleComments(current, "infra/", staleActionMinimize, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 503 is already fixed, 505 is outside the working directory and 509 wasn't written by the commenter
+	if cleaned != 1 {
+		t.Errorf("cleaned = %d, want 1", cleaned)
+	}
+
+	var reply azureComment
+	api.sentOnce(http.MethodPost, azureTestPr+"/threads/504/comments?api-version=7.0").decode(t, &reply)
+	if reply.Content != fixedCommentFor(azureTestCommit) || reply.ParentCommentID != 1 {
+		t.Errorf("reply = %+v", reply)
+	}
+	var thread azureThread
+	api.sentOnce(http.MethodPatch, azureTestPr+"/threads/504?api-version=7.0").decode(t, &thread)
+	if thread.Status != azureThreadClosed {
+		t.Errorf("status = %s, want closed for minimize", thread.Status)
+	}
+}
+
+func TestAzureDevopsCommenterUpdatesSummaryThread(t *testing.T) {
+	api := newAzureTestApi(t)
+	defer api.close()
+	api.reply(http.MethodPatch, azureTestPr+"/threads/507/comments/1?api-version=7.0", `{"id":1}`)
+	c := newAzureTestCommenter(t, api)
+
+	previous, err := c.PreviousSummary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous != summaryMarker+"\n## tfsec summary\n\n:white_check_mark: tfsec found no issues.\n" {
+		t.Errorf("PreviousSummary = %q", previous)
+	}
+	if err := c.WriteSummaryComment(summaryMarker + "\nupdated"); err != nil {
+		t.Fatal(err)
+	}
+	var update azureComment
+	api.sentOnce(http.MethodPatch, azureTestPr+"/threads/507/comments/1?api-version=7.0").decode(t, &update)
+	if update.Content != summaryMarker+"\nupdated" {
+		t.Errorf("summary = %+v", update)
+	}
+}
+
+func TestAzureDevopsCommenterWritesNewSummaryThread(t *testing.T) {
+	api := newAzureTestApi(t)
+	defer api.close()
+	api.reply(http.MethodGet, azureTestPr+"/threads?api-version=7.0", `{"value":[],"count":0}`)
+	api.reply(http.MethodPost, azureTestPr+"/threads?api-version=7.0", `{"id":510}`)
+	c := newAzureTestCommenter(t, api)
+
+	if err := c.WriteSummaryComment(summaryMarker + "\nfirst"); err != nil {
+		t.Fatal(err)
+	}
+	var thread azureThread
+	api.sentOnce(http.MethodPost, azureTestPr+"/threads?api-version=7.0").decode(t, &thread)
+	// closed so it doesn't have to be resolved before the pull request can complete
+	if thread.Status != azureThreadClosed || thread.ThreadContext != nil || thread.Comments[0].Content != summaryMarker+"\nfirst" {
+		t.Errorf("summary thread = %+v", thread)
+	}
+}
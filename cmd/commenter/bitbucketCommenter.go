@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	bitbucketDefaultApiUrl = "https://api.bitbucket.org/2.0"
+	bitbucketPageSize      = 100
+	// bitbucketReportId is the key of the Code Insights report on the commit, so each run replaces the last
+	bitbucketReportId = "tfsec"
+	// bitbucketMaxAnnotations is the most annotations a Code Insights report can have, and
+	// bitbucketAnnotationBatch the most that can be added in a single request
+	bitbucketMaxAnnotations  = 1000
+	bitbucketAnnotationBatch = 100
+	// bitbucketMaxSummary is the longest annotation summary Bitbucket Cloud accepts
+	bitbucketMaxSummary = 450
+)
+
+// bitbucketPlatform comments on pull requests in Bitbucket Cloud, using the BITBUCKET_* variables of a pull
+// request pipeline
+type bitbucketPlatform struct {
+	header    http.Header
+	apiUrl    string
+	workspace string
+	repoSlug  string
+}
+
+func newBitbucketPlatform() (*bitbucketPlatform, error) {
+	header, err := extractBitbucketAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	workspace, repoSlug := os.Getenv("BITBUCKET_WORKSPACE"), os.Getenv("BITBUCKET_REPO_SLUG")
+	if workspace == "" || repoSlug == "" {
+		return nil, fmt.Errorf("the BITBUCKET_WORKSPACE and BITBUCKET_REPO_SLUG have not been set")
+	}
+	logf("Working in repository %s\n", repoSlug)
+
+	apiUrl := os.Getenv("BITBUCKET_API_URL")
+	if apiUrl == "" {
+		apiUrl = bitbucketDefaultApiUrl
+	}
+
+	return &bitbucketPlatform{
+		header:    header,
+		apiUrl:    apiUrl,
+		workspace: workspace,
+		repoSlug:  repoSlug,
+	}, nil
+}
+
+// extractBitbucketAuth uses an access token when there is one, otherwise a username and app password
+func extractBitbucketAuth() (http.Header, error) {
+	token := os.Getenv("INPUT_BITBUCKET_TOKEN")
+	if token == "" {
+		token = os.Getenv("BITBUCKET_ACCESS_TOKEN")
+	}
+	if token != "" {
+		return http.Header{"Authorization": []string{"Bearer " + token}}, nil
+	}
+
+	username, password := os.Getenv("BITBUCKET_USERNAME"), os.Getenv("BITBUCKET_APP_PASSWORD")
+	if username != "" && password != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		return http.Header{"Authorization": []string{"Basic " + credentials}}, nil
+	}
+	return nil, fmt.Errorf("the INPUT_BITBUCKET_TOKEN has not been set")
+}
+
+// extractBitbucketPullRequestId is the pull request, which is only set for pull request pipelines
+func extractBitbucketPullRequestId() (int, error) {
+	id := os.Getenv("INPUT_PR_NUMBER")
+	if id == "" {
+		id = os.Getenv("BITBUCKET_PR_ID")
+	}
+	if id == "" {
+		return 0, fmt.Errorf("the BITBUCKET_PR_ID has not been set")
+	}
+	return strconv.Atoi(id)
+}
+
+func (p *bitbucketPlatform) name() string {
+	return "Bitbucket"
+}
+
+func (p *bitbucketPlatform) pullRequestNumber() (int, error) {
+	return extractBitbucketPullRequestId()
+}
+
+func (p *bitbucketPlatform) connect(prNo int) (prCommenter, error) {
+	return newBitbucketCommenter(&restClient{
+		httpClient: restHttpClient,
+		baseURL:    p.apiUrl,
+		header:     p.header,
+		owner:      p.workspace,
+		repo:       p.repoSlug,
+		prNumber:   prNo,
+	})
+}
+
+func (p *bitbucketPlatform) commentContext(prNo int, sha string) commentContext {
+	return commentContext{
+		Owner:       p.workspace,
+		Repo:        p.repoSlug,
+		Repository:  p.workspace + "/" + p.repoSlug,
+		PullRequest: prNo,
+		ServerURL:   "https://bitbucket.org",
+		SHA:         sha,
+	}
+}
+
+func (p *bitbucketPlatform) readOnly() bool {
+	return false
+}
+
+type bitbucketContent struct {
+	Raw string `json:"raw"`
+}
+
+type bitbucketInline struct {
+	Path string `json:"path"`
+	To   int    `json:"to,omitempty"`
+}
+
+type bitbucketRef struct {
+	ID int64 `json:"id"`
+}
+
+type bitbucketComment struct {
+	ID         int64            `json:"id,omitempty"`
+	Content    bitbucketContent `json:"content"`
+	Inline     *bitbucketInline `json:"inline,omitempty"`
+	Parent     *bitbucketRef    `json:"parent,omitempty"`
+	Deleted    bool             `json:"deleted,omitempty"`
+	Resolution interface{}      `json:"resolution,omitempty"`
+}
+
+// bitbucketAnnotation is an annotation on a Code Insights report. Bitbucket Cloud and Server take the same
+// fields under different names, so each builds its own from these
+type bitbucketAnnotation struct {
+	externalId string
+	path       string
+	line       int
+	summary    string
+	details    string
+	severity   string
+}
+
+// bitbucketCommenter writes pull request comments and a Code Insights report through the Bitbucket Cloud API
+type bitbucketCommenter struct {
+	reviewState
+	client         *restClient
+	repoPath       string
+	prPath         string
+	summaryComment *bitbucketComment
+}
+
+// newBitbucketCommenter loads the pull request diff and the existing comments
+func newBitbucketCommenter(client *restClient) (*bitbucketCommenter, error) {
+	c := &bitbucketCommenter{
+		client:   client,
+		repoPath: fmt.Sprintf("/repositories/%s/%s", client.owner, client.repo),
+	}
+	c.prPath = fmt.Sprintf("%s/pullrequests/%d", c.repoPath, client.prNumber)
+
+	pr := struct {
+		Source struct {
+			Commit struct {
+				Hash string `json:"hash"`
+			} `json:"commit"`
+		} `json:"source"`
+	}{}
+	if _, err := client.do(http.MethodGet, c.prPath, nil, &pr); err != nil {
+		if _, ok := err.(restStatusError); ok {
+			return nil, newPrDoesNotExistError(client.owner, client.repo, client.prNumber)
+		}
+		return nil, err
+	}
+	// the pull request only has the short hash, the pipeline has the full one
+	c.headSHA = pr.Source.Commit.Hash
+	if commit := os.Getenv("BITBUCKET_COMMIT"); strings.HasPrefix(commit, c.headSHA) {
+		c.headSHA = commit
+	}
+
+	patch, err := client.getText(c.prPath + "/diff")
+	if err != nil {
+		return nil, fmt.Errorf("load pull request diff: %w", err)
+	}
+	if c.files, err = changedFilesFromPatch(patch, c.headSHA); err != nil {
+		return nil, fmt.Errorf("load pull request diff: %w", err)
+	}
+
+	if err := c.loadComments(); err != nil {
+		return nil, fmt.Errorf("load pull request comments: %w", err)
+	}
+	return c, nil
+}
+
+// loadComments keeps the inline comments, which aren't replies, for dedupe and cleanup, and finds the summary
+// comment among the others. The newest reply to a comment says whether the commenter resolved it as fixed
+func (c *bitbucketCommenter) loadComments() error {
+	lastReplies := make(map[int64]*bitbucketComment)
+	next := fmt.Sprintf("%s/comments?pagelen=%d", c.prPath, bitbucketPageSize)
+	for next != "" {
+		page := struct {
+			Values []bitbucketComment `json:"values"`
+			Next   string             `json:"next"`
+		}{}
+		if _, err := c.client.do(http.MethodGet, next, nil, &page); err != nil {
+			return err
+		}
+		for i := range page.Values {
+			comment := &page.Values[i]
+			switch {
+			case comment.Deleted:
+			case comment.Parent != nil:
+				if last, ok := lastReplies[comment.Parent.ID]; !ok || comment.ID > last.ID {
+					lastReplies[comment.Parent.ID] = comment
+				}
+			case comment.Inline == nil:
+				if c.summaryComment == nil && strings.Contains(comment.Content.Raw, summaryMarker) {
+					c.summaryComment = comment
+				}
+			default:
+				c.existingComments = append(c.existingComments, &existingComment{
+					filename:    &comment.Inline.Path,
+					comment:     &comment.Content.Raw,
+					commentId:   &comment.ID,
+					fingerprint: extractFingerprint(comment.Content.Raw),
+					resolved:    comment.Resolution != nil,
+				})
+			}
+		}
+		next = page.Next
+	}
+
+	for _, existing := range c.existingComments {
+		if last, ok := lastReplies[*existing.commentId]; ok {
+			existing.fixed = existing.resolved && isFixedComment(last.Content.Raw)
+		}
+	}
+	return nil
+}
+
+// WriteMultiLineComment queues an inline comment on the last line of the range, or edits the comment from an
+// earlier run for the same finding
+func (c *bitbucketCommenter) WriteMultiLineComment(file, comment string, startLine, endLine int) error {
+	existing, err := c.queueComment(file, comment, startLine, endLine)
+	if err != nil || existing == nil {
+		return err
+	}
+	path := fmt.Sprintf("%s/comments/%d", c.prPath, *existing.commentId)
+	if _, err := c.client.do(http.MethodPut, path, bitbucketComment{Content: bitbucketContent{Raw: comment}}, nil); err != nil {
+		return fmt.Errorf("edit pull request comment: %w", err)
+	}
+	c.edited(existing, comment)
+	return nil
+}
+
+// SubmitReview writes every queued comment. Bitbucket has no review event, so a request for changes is written
+// as comments like any other
+func (c *bitbucketCommenter) SubmitReview(string) error {
+	for len(c.pending) > 0 {
+		comment := bitbucketComment{
+			Content: bitbucketContent{Raw: c.pending[0].comment},
+			Inline:  &bitbucketInline{Path: c.pending[0].filename, To: c.pending[0].endLine},
+		}
+		if _, err := c.client.do(http.MethodPost, c.prPath+"/comments", comment, nil); err != nil {
+			return fmt.Errorf("write pull request comment: %w", err)
+		}
+		c.pending = c.pending[1:]
+	}
+	return nil
+}
+
+// CleanupStaleComments resolves the comments from earlier runs whose finding is no longer reported. Bitbucket
+// can't hide a comment, so minimize resolves it as well
+func (c *bitbucketCommenter) CleanupStaleComments(current []findingComment, scope, action string, reply bool) (int, error) {
+	if action == staleActionNone {
+		return 0, nil
+	}
+
+	var cleaned int
+	for _, existing := range c.staleComments(current, scope) {
+		if reply {
+			comment := bitbucketComment{
+				Content: bitbucketContent{Raw: c.fixedComment()},
+				Parent:  &bitbucketRef{ID: *existing.commentId},
+			}
+			if _, err := c.client.do(http.MethodPost, c.prPath+"/comments", comment, nil); err != nil {
+				return cleaned, fmt.Errorf("write reply comment: %w", err)
+			}
+		}
+		path := fmt.Sprintf("%s/comments/%d/resolve", c.prPath, *existing.commentId)
+		if _, err := c.client.do(http.MethodPost, path, nil, nil); err != nil {
+			return cleaned, fmt.Errorf("%s stale comment: %w", action, err)
+		}
+		existing.resolved, existing.fixed = true, reply
+		cleaned++
+	}
+	return cleaned, nil
+}
+
+// PreviousSummary returns the body of the summary comment written on an earlier run, or empty if there isn't one
+func (c *bitbucketCommenter) PreviousSummary() (string, error) {
+	if c.summaryComment == nil {
+		return "", nil
+	}
+	return c.summaryComment.Content.Raw, nil
+}
+
+// WriteSummaryComment writes the summary comment on the pull request, updating the one from an earlier run in place
+func (c *bitbucketCommenter) WriteSummaryComment(comment string) error {
+	body := bitbucketComment{Content: bitbucketContent{Raw: comment}}
+	if c.summaryComment == nil {
+		_, err := c.client.do(http.MethodPost, c.prPath+"/comments", body, nil)
+		return err
+	}
+	_, err := c.client.do(http.MethodPut, fmt.Sprintf("%s/comments/%d", c.prPath, c.summaryComment.ID), body, nil)
+	return err
+}
+
+// WriteFindingsReport replaces the tfsec Code Insights report on the head commit, with an annotation for every
+// result
+func (c *bitbucketCommenter) WriteFindingsReport(results []result, findings []summaryFinding, decision policyDecision) error {
+	reportPath := fmt.Sprintf("%s/commit/%s/reports/%s", c.repoPath, c.headSHA, bitbucketReportId)
+	if _, err := c.client.do(http.MethodDelete, reportPath, nil, nil); err != nil && !isNotFound(err) {
+		return fmt.Errorf("delete code insights report: %w", err)
+	}
+
+	result := "PASSED"
+	if decision.exitCode != exitCodeSuccess {
+		result = "FAILED"
+	}
+	report := map[string]interface{}{
+		"title":       checkRunName,
+		"details":     generateReportDetails(findings, decision),
+		"report_type": "SECURITY",
+		"reporter":    "tfsec-pr-commenter",
+		"result":      result,
+		"data": []map[string]interface{}{
+			{"title": "Issues", "type": "NUMBER", "value": len(findings)},
+		},
+	}
+	if _, err := c.client.do(http.MethodPut, reportPath, report, nil); err != nil {
+		return fmt.Errorf("write code insights report: %w", err)
+	}
+
+	annotations := buildBitbucketAnnotations(results)
+	for i := 0; i < len(annotations); i += bitbucketAnnotationBatch {
+		end := i + bitbucketAnnotationBatch
+		if end > len(annotations) {
+			end = len(annotations)
+		}
+		var batch []map[string]interface{}
+		for _, annotation := range annotations[i:end] {
+			batch = append(batch, map[string]interface{}{
+				"external_id":     annotation.externalId,
+				"annotation_type": "VULNERABILITY",
+				"path":            annotation.path,
+				"line":            annotation.line,
+				"summary":         annotation.summary,
+				"details":         annotation.details,
+				"severity":        annotation.severity,
+			})
+		}
+		if _, err := c.client.do(http.MethodPost, reportPath+"/annotations", batch, nil); err != nil {
+			return fmt.Errorf("write code insights annotations: %w", err)
+		}
+	}
+	return nil
+}
+
+// generateReportDetails is the text shown on a Code Insights report, which can't be markdown
+func generateReportDetails(findings []summaryFinding, decision policyDecision) string {
+	if len(findings) == 0 {
+		return fmt.Sprintf("tfsec found no issues. %s", decision.reason)
+	}
+	return fmt.Sprintf("tfsec found %d issues. %s", len(findings), decision.reason)
+}
+
+// buildBitbucketAnnotations annotates the first line of every result, up to the most a report can have
+func buildBitbucketAnnotations(results []result) []bitbucketAnnotation {
+	if len(results) > bitbucketMaxAnnotations {
+		logf("Warning: %d results but a Code Insights report can only have %d annotations\n", len(results), bitbucketMaxAnnotations)
+		results = results[:bitbucketMaxAnnotations]
+	}
+
+	var annotations []bitbucketAnnotation
+	for i, result := range results {
+		severity := strings.ToUpper(result.Severity)
+		if severityRanks[severity] == 0 {
+			severity = "LOW"
+		}
+		var details []string
+		if result.Resolution != "" {
+			details = append(details, result.Resolution)
+		}
+		details = append(details, result.Links...)
+
+		summary := fmt.Sprintf("%s: %s", result.RuleID, result.Description)
+		// the limit is in characters, so the summary is cut by rune rather than in the middle of one
+		if runes := []rune(summary); len(runes) > bitbucketMaxSummary {
+			summary = string(runes[:bitbucketMaxSummary-3]) + "..."
+		}
+		annotations = append(annotations, bitbucketAnnotation{
+			externalId: fmt.Sprintf("%s-%d", bitbucketReportId, i+1),
+			path:       result.Range.Filename,
+			line:       result.Range.StartLine,
+			summary:    summary,
+			details:    strings.Join(details, "\n"),
+			severity:   severity,
+		})
+	}
+	return annotations
+}
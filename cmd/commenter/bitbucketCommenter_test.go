@@ -0,0 +1,265 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+const (
+	bitbucketTestPr     = "/repositories/team/infra/pullrequests/5"
+	bitbucketTestCommit = "d4d4d4d4d4d4ffffffffffffffffffffffffffff"
+)
+
+// newBitbucketTestApi serves the pull request, its diff and its comments over two pages from testdata/bitbucket
+func newBitbucketTestApi(t *testing.T) *fakeApi {
+	api := newFakeApi(t)
+	api.replyFixture(http.MethodGet, bitbucketTestPr, "bitbucket/pull_request.json")
+	api.replyFixture(http.MethodGet, bitbucketTestPr+"/diff", "bitbucket/pull_request.diff")
+	api.replyFixture(http.MethodGet, bitbucketTestPr+"/comments?pagelen=100", "bitbucket/comments_page1.json")
+	api.replyFixture(http.MethodGet, bitbucketTestPr+"/comments?pagelen=100&page=2", "bitbucket/comments_page2.json")
+	return api
+}
+
+func newBitbucketTestCommenter(t *testing.T, api *fakeApi) *bitbucketCommenter {
+	t.Helper()
+	_ = os.Setenv("BITBUCKET_COMMIT", bitbucketTestCommit)
+	defer os.Unsetenv("BITBUCKET_COMMIT")
+
+	c, err := newBitbucketCommenter(&restClient{
+		httpClient: restHttpClient,
+		baseURL:    api.url(),
+		header:     http.Header{"Authorization": []string{"Bearer secret"}},
+		owner:      "team",
+		repo:       "infra",
+		prNumber:   5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestBitbucketCommenterLoadsPullRequest(t *testing.T) {
+	api := newBitbucketTestApi(t)
+	defer api.close()
+	c := newBitbucketTestCommenter(t, api)
+
+	if auth := api.sentOnce(http.MethodGet, bitbucketTestPr).header.Get("Authorization"); auth != "Bearer secret" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	// the pull request only has the short hash
+	if c.HeadSHA() != bitbucketTestCommit {
+		t.Errorf("HeadSHA = %s", c.HeadSHA())
+	}
+	if len(c.files) != 1 || !c.InChangedLines("infra/main.tf", 2, 2) || !c.InDiff("infra/main.tf", 11, 14) {
+		t.Errorf("the diff of infra/main.tf should be loaded without the deleted file")
+	}
+
+	// replies, deleted comments and comments that aren't inline are left out
+	var ids []int64
+	for _, existing := range c.existingComments {
+		ids = append(ids, *existing.commentId)
+	}
+	if len(ids) != 7 || ids[5] != 310 || ids[6] != 312 {
+		t.Fatalf("existing comments = %v, want 301 to 305, 310 and 312", ids)
+	}
+	if !c.existingComments[2].resolved || c.existingComments[0].resolved || !c.existingComments[6].resolved {
+		t.Errorf("only comments 303 and 312 are resolved")
+	}
+	// the newest reply to 303, on the next page, is the one of the commenter, 312 was resolved by hand
+	if !c.existingComments[2].fixed || c.existingComments[6].fixed {
+		t.Errorf("only comment 303 was resolved as fixed by the commenter")
+	}
+}
+
+func TestBitbucketCommenterPullRequestNotFound(t *testing.T) {
+	api := newFakeApi(t)
+	defer api.close()
+	api.handle(http.MethodGet, bitbucketTestPr, fakeResponse{status: http.StatusNotFound, body: `{"type":"error"}`})
+
+	_, err := newBitbucketCommenter(&restClient{httpClient: restHttpClient, baseURL: api.url(), owner: "team", repo: "infra", prNumber: 5})
+	if _, ok := err.(PrDoesNotExistError); !ok {
+		t.Errorf("err = %v, want PrDoesNotExistError", err)
+	}
+}
+
+func TestBitbucketCommenterWritesComments(t *testing.T) {
+	api := newBitbucketTestApi(t)
+	defer api.close()
+	api.reply(http.MethodPut, bitbucketTestPr+"/comments/302", `{"id":302}`)
+	api.reply(http.MethodPost, bitbucketTestPr+"/comments", `{"id":311}`)
+	c := newBitbucketTestCommenter(t, api)
+
+	added := testComment("aws-added", "f0f0f0f0")
+	context := testComment("aws-context", "f1f1f1f1")
+	reintroduced := testComment("aws-resolved", "cccc3333")
+	reworded := testComment("aws-new-wording", "bbbb2222")
+
+	if _, ok := c.WriteMultiLineComment("infra/main.tf", testComment("aws-already-written", "aaaa1111"), 2, 2).(CommentAlreadyWrittenError); !ok {
+		t.Errorf("a comment from an earlier run should not be written again")
+	}
+	// an issue a person dismissed by resolving its comment isn't commented on again
+	if _, ok := c.WriteMultiLineComment("infra/main.tf", testComment("aws-dismissed", "acac8888"), 2, 2).(CommentAlreadyWrittenError); !ok {
+		t.Errorf("a comment resolved by hand should not be written again")
+	}
+	if err := c.WriteMultiLineComment("infra/main.tf", reworded, 3, 3); err != nil {
+		t.Fatal(err)
+	}
+	for _, comment := range []struct {
+		body       string
+		start, end int
+	}{
+		{body: added, start: 2, end: 2},
+		{body: context, start: 11, end: 12},
+		{body: reintroduced, start: 2, end: 2},
+	} {
+		if err := c.WriteMultiLineComment("infra/main.tf", comment.body, comment.start, comment.end); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := c.WriteMultiLineComment("infra/main.tf", testComment("aws-outside", "f3f3f3f3"), 8, 8).(CommentNotValidError); !ok {
+		t.Errorf("a comment outside the diff should not be queued")
+	}
+
+	var edit bitbucketComment
+	api.sentOnce(http.MethodPut, bitbucketTestPr+"/comments/302").decode(t, &edit)
+	if edit.Content.Raw != reworded || edit.Inline != nil {
+		t.Errorf("edit = %+v", edit)
+	}
+
+	if err := c.SubmitReview("COMMENT"); err != nil {
+		t.Fatal(err)
+	}
+	want := []bitbucketComment{
+		{Content: bitbucketContent{Raw: added}, Inline: &bitbucketInline{Path: "infra/main.tf", To: 2}},
+		{Content: bitbucketContent{Raw: context}, Inline: &bitbucketInline{Path: "infra/main.tf", To: 12}},
+		{Content: bitbucketContent{Raw: reintroduced}, Inline: &bitbucketInline{Path: "infra/main.tf", To: 2}},
+	}
+	posts := api.sent(http.MethodPost, bitbucketTestPr+"/comments")
+	if len(posts) != len(want) {
+		t.Fatalf("got %d comments, want %d", len(posts), len(want))
+	}
+	for i, post := range posts {
+		var comment bitbucketComment
+		post.decode(t, &comment)
+		if comment.Content != want[i].Content || comment.Inline == nil || *comment.Inline != *want[i].Inline {
+			t.Errorf("comment %d = %+v, want %+v", i, comment, want[i])
+		}
+	}
+	if c.PendingComments() != 0 {
+		t.Errorf("PendingComments = %d after submitting", c.PendingComments())
+	}
+}
+
+func TestBitbucketCommenterResolvesStaleComments(t *testing.T) {
+	api := newBitbucketTestApi(t)
+	defer api.close()
+	api.reply(http.MethodPost, bitbucketTestPr+"/comments", `{"id":311}`)
+	api.reply(http.MethodPost, bitbucketTestPr+"/comments/304/resolve", `{"type":"comment_resolution"}`)
+	c := newBitbucketTestCommenter(t, api)
+
+	current := []findingComment{
+		{filename: "infra/main.tf", comment: testComment("aws-already-written", "aaaa1111")},
+		{filename: "infra/main.tf", comment: testComment("aws-new-wording", "bbbb2222")},
+	}
+	cleaned, err := c.CleanupStaleComments(current, "infra/", staleActionMinimize, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 303 is already resolved, 305 is outside the working directory and 310 wasn't written by the commenter
+	if cleaned != 1 {
+		t.Errorf("cleaned = %d, want 1", cleaned)
+	}
+
+	var reply bitbucketComment
+	api.sentOnce(http.MethodPost, bitbucketTestPr+"/comments").decode(t, &reply)
+	if reply.Content.Raw != fixedCommentFor(bitbucketTestCommit) || reply.Parent == nil || reply.Parent.ID != 304 {
+		t.Errorf("reply = %+v", reply)
+	}
+	api.sentOnce(http.MethodPost, bitbucketTestPr+"/comments/304/resolve")
+
+	if cleaned, err = c.CleanupStaleComments(current, "infra/", staleActionResolve, false); err != nil || cleaned != 0 {
+		t.Errorf("second cleanup = %d, %v, want nothing", cleaned, err)
+	}
+}
+
+func TestBitbucketCommenterUpdatesSummaryComment(t *testing.T) {
+	api := newBitbucketTestApi(t)
+	defer api.close()
+	api.reply(http.MethodPut, bitbucketTestPr+"/comments/309", `{"id":309}`)
+	c := newBitbucketTestCommenter(t, api)
+
+	previous, err := c.PreviousSummary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous != summaryMarker+"\n## tfsec summary\n\n:white_check_mark: tfsec found no issues.\n" {
+		t.Errorf("PreviousSummary = %q", previous)
+	}
+	if err := c.WriteSummaryComment(summaryMarker + "\nupdated"); err != nil {
+		t.Fatal(err)
+	}
+	var update bitbucketComment
+	api.sentOnce(http.MethodPut, bitbucketTestPr+"/comments/309").decode(t, &update)
+	if update.Content.Raw != summaryMarker+"\nupdated" {
+		t.Errorf("summary = %+v", update)
+	}
+}
+
+func TestBitbucketCommenterWritesFindingsReport(t *testing.T) {
+	api := newBitbucketTestApi(t)
+	defer api.close()
+	reportPath := "/repositories/team/infra/commit/" + bitbucketTestCommit + "/reports/tfsec"
+	api.handle(http.MethodDelete, reportPath, fakeResponse{status: http.StatusNotFound, body: `{"type":"error"}`})
+	api.reply(http.MethodPut, reportPath, `{"uuid":"{r1}"}`)
+	api.reply(http.MethodPost, reportPath+"/annotations", `[]`)
+	c := newBitbucketTestCommenter(t, api)
+
+	results := []result{
+		{RuleID: "aws-added", Description: "bucket is public", Severity: "CRITICAL", Resolution: "make it private",
+			Links: []string{"https://example.com/aws-added"}, Range: &checkRange{Filename: "infra/main.tf", StartLine: 2, EndLine: 2}},
+		{RuleID: "aws-other", Description: "no logging", Severity: "LOW", Range: &checkRange{Filename: "infra/main.tf", StartLine: 8, EndLine: 9}},
+	}
+	decision := policyDecision{result: "diff", exitCode: 3, reason: "Failing - 1 issues found in the PR diff"}
+	if err := c.WriteFindingsReport(results, []summaryFinding{newSummaryFinding(results[0], true), newSummaryFinding(results[1], false)}, decision); err != nil {
+		t.Fatal(err)
+	}
+
+	report := struct {
+		Result  string `json:"result"`
+		Details string `json:"details"`
+	}{}
+	api.sentOnce(http.MethodPut, reportPath).decode(t, &report)
+	if report.Result != "FAILED" || report.Details != "tfsec found 2 issues. "+decision.reason {
+		t.Errorf("report = %+v", report)
+	}
+
+	var annotations []map[string]interface{}
+	api.sentOnce(http.MethodPost, reportPath+"/annotations").decode(t, &annotations)
+	if len(annotations) != 2 {
+		t.Fatalf("got %d annotations, want 2", len(annotations))
+	}
+	if annotations[0]["external_id"] != "tfsec-1" || annotations[0]["severity"] != "CRITICAL" ||
+		annotations[0]["details"] != "make it private\nhttps://example.com/aws-added" || annotations[1]["line"] != float64(8) {
+		t.Errorf("annotations = %+v", annotations)
+	}
+}
+
+func TestBitbucketAnnotationSummaryIsCut(t *testing.T) {
+	results := []result{
+		{RuleID: "aws-short", Description: "no logging", Range: &checkRange{Filename: "infra/main.tf", StartLine: 2, EndLine: 2}},
+		// a description that isn't ASCII is cut between characters rather than bytes
+		{RuleID: "aws-long", Description: strings.Repeat("é", bitbucketMaxSummary), Range: &checkRange{Filename: "infra/main.tf", StartLine: 4, EndLine: 4}},
+	}
+	annotations := buildBitbucketAnnotations(results)
+	if annotations[0].summary != "aws-short: no logging" {
+		t.Errorf("summary = %q", annotations[0].summary)
+	}
+	summary := annotations[1].summary
+	if !utf8.ValidString(summary) || utf8.RuneCountInString(summary) != bitbucketMaxSummary || !strings.HasSuffix(summary, "é...") {
+		t.Errorf("summary has %d characters and is valid %t, want %d ending with ...", utf8.RuneCountInString(summary), utf8.ValidString(summary), bitbucketMaxSummary)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// bitbucketServerPlatform comments on pull requests in Bitbucket Server and Data Center. There are no pipeline
+// variables to read, so the server and repository are given as BITBUCKET_* variables by the build
+type bitbucketServerPlatform struct {
+	header     http.Header
+	serverUrl  string
+	projectKey string
+	repoSlug   string
+}
+
+func newBitbucketServerPlatform() (*bitbucketServerPlatform, error) {
+	header, err := extractBitbucketAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	serverUrl := strings.TrimSuffix(os.Getenv("BITBUCKET_SERVER_URL"), "/")
+	if serverUrl == "" {
+		return nil, fmt.Errorf("the BITBUCKET_SERVER_URL has not been set")
+	}
+	projectKey, repoSlug := os.Getenv("BITBUCKET_PROJECT_KEY"), os.Getenv("BITBUCKET_REPO_SLUG")
+	if projectKey == "" || repoSlug == "" {
+		return nil, fmt.Errorf("the BITBUCKET_PROJECT_KEY and BITBUCKET_REPO_SLUG have not been set")
+	}
+	logf("Working in repository %s\n", repoSlug)
+
+	return &bitbucketServerPlatform{
+		header:     header,
+		serverUrl:  serverUrl,
+		projectKey: projectKey,
+		repoSlug:   repoSlug,
+	}, nil
+}
+
+func (p *bitbucketServerPlatform) name() string {
+	return "Bitbucket Server"
+}
+
+func (p *bitbucketServerPlatform) pullRequestNumber() (int, error) {
+	return extractBitbucketPullRequestId()
+}
+
+func (p *bitbucketServerPlatform) connect(prNo int) (prCommenter, error) {
+	return newBitbucketServerCommenter(&restClient{
+		httpClient: restHttpClient,
+		baseURL:    p.serverUrl,
+		header:     p.header,
+		owner:      p.projectKey,
+		repo:       p.repoSlug,
+		prNumber:   prNo,
+	})
+}
+
+func (p *bitbucketServerPlatform) commentContext(prNo int, sha string) commentContext {
+	return commentContext{
+		Owner:       p.projectKey,
+		Repo:        p.repoSlug,
+		Repository:  p.projectKey + "/" + p.repoSlug,
+		PullRequest: prNo,
+		ServerURL:   p.serverUrl,
+		SHA:         sha,
+	}
+}
+
+func (p *bitbucketServerPlatform) readOnly() bool {
+	return false
+}
+
+type bitbucketServerAnchor struct {
+	Path     string `json:"path"`
+	Line     int    `json:"line"`
+	LineType string `json:"lineType"`
+	FileType string `json:"fileType"`
+	DiffType string `json:"diffType"`
+}
+
+// bitbucketServerComment is a pull request comment. Its state is only used by tasks, a plain comment is resolved
+// by resolving its thread
+type bitbucketServerComment struct {
+	ID             int64                    `json:"id"`
+	Version        int                      `json:"version"`
+	Text           string                   `json:"text"`
+	ThreadResolved bool                     `json:"threadResolved"`
+	Comments       []bitbucketServerComment `json:"comments"`
+}
+
+type bitbucketServerActivity struct {
+	Action        string                 `json:"action"`
+	CommentAction string                 `json:"commentAction"`
+	Comment       bitbucketServerComment `json:"comment"`
+	CommentAnchor *bitbucketServerAnchor `json:"commentAnchor"`
+}
+
+// bitbucketServerCommenter writes pull request comments and a Code Insights report through the Bitbucket Server
+// REST API
+type bitbucketServerCommenter struct {
+	reviewState
+	client         *restClient
+	repoPath       string
+	prPath         string
+	versions       map[int64]int
+	summaryComment *bitbucketServerComment
+}
+
+// newBitbucketServerCommenter loads the pull request diff and the existing comments
+func newBitbucketServerCommenter(client *restClient) (*bitbucketServerCommenter, error) {
+	c := &bitbucketServerCommenter{
+		client:   client,
+		repoPath: fmt.Sprintf("/projects/%s/repos/%s", client.owner, client.repo),
+		versions: make(map[int64]int),
+	}
+	c.prPath = fmt.Sprintf("/rest/api/1.0%s/pull-requests/%d", c.repoPath, client.prNumber)
+
+	pr := struct {
+		FromRef struct {
+			LatestCommit string `json:"latestCommit"`
+		} `json:"fromRef"`
+	}{}
+	if _, err := client.do(http.MethodGet, c.prPath, nil, &pr); err != nil {
+		if _, ok := err.(restStatusError); ok {
+			return nil, newPrDoesNotExistError(client.owner, client.repo, client.prNumber)
+		}
+		return nil, err
+	}
+	c.headSHA = pr.FromRef.LatestCommit
+
+	patch, err := client.getText(c.prPath + ".diff")
+	if err != nil {
+		return nil, fmt.Errorf("load pull request diff: %w", err)
+	}
+	if c.files, err = changedFilesFromPatch(patch, c.headSHA); err != nil {
+		return nil, fmt.Errorf("load pull request diff: %w", err)
+	}
+
+	if err := c.loadComments(); err != nil {
+		return nil, fmt.Errorf("load pull request comments: %w", err)
+	}
+	return c, nil
+}
+
+// loadComments finds the comments in the pull request activity, newest first, keeping the ones on a line of a
+// file for dedupe and cleanup and finding the summary comment among the others
+func (c *bitbucketServerCommenter) loadComments() error {
+	deleted := make(map[int64]bool)
+	start := 0
+	for {
+		page := struct {
+			Values        []bitbucketServerActivity `json:"values"`
+			IsLastPage    bool                      `json:"isLastPage"`
+			NextPageStart int                       `json:"nextPageStart"`
+		}{}
+		path := fmt.Sprintf("%s/activities?limit=%d&start=%d", c.prPath, bitbucketPageSize, start)
+		if _, err := c.client.do(http.MethodGet, path, nil, &page); err != nil {
+			return err
+		}
+		for i := range page.Values {
+			activity := &page.Values[i]
+			comment := &activity.Comment
+			if activity.Action != "COMMENTED" {
+				continue
+			}
+			if activity.CommentAction == "DELETED" {
+				deleted[comment.ID] = true
+			}
+			if activity.CommentAction != "ADDED" || deleted[comment.ID] {
+				continue
+			}
+			c.versions[comment.ID] = comment.Version
+			if activity.CommentAnchor == nil {
+				if c.summaryComment == nil && strings.Contains(comment.Text, summaryMarker) {
+					c.summaryComment = comment
+				}
+				continue
+			}
+			// the replies are nested in the comment, the last of them says whether the commenter resolved it as fixed
+			replies := comment.Comments
+			c.existingComments = append(c.existingComments, &existingComment{
+				filename:    &activity.CommentAnchor.Path,
+				comment:     &comment.Text,
+				commentId:   &comment.ID,
+				fingerprint: extractFingerprint(comment.Text),
+				resolved:    comment.ThreadResolved,
+				fixed:       comment.ThreadResolved && len(replies) > 0 && isFixedComment(replies[len(replies)-1].Text),
+			})
+		}
+		if page.IsLastPage || len(page.Values) == 0 {
+			return nil
+		}
+		start = page.NextPageStart
+	}
+}
+
+// updateComment changes a comment, which Bitbucket Server only allows with its current version
+func (c *bitbucketServerCommenter) updateComment(commentId int64, change map[string]interface{}) error {
+	change["version"] = c.versions[commentId]
+	updated := bitbucketServerComment{}
+	path := fmt.Sprintf("%s/comments/%d", c.prPath, commentId)
+	if _, err := c.client.do(http.MethodPut, path, change, &updated); err != nil {
+		return err
+	}
+	c.versions[commentId] = updated.Version
+	return nil
+}
+
+// WriteMultiLineComment queues a comment on the last line of the range, or edits the comment from an earlier
+// run for the same finding
+func (c *bitbucketServerCommenter) WriteMultiLineComment(file, comment string, startLine, endLine int) error {
+	existing, err := c.queueComment(file, comment, startLine, endLine)
+	if err != nil || existing == nil {
+		return err
+	}
+	if err := c.updateComment(*existing.commentId, map[string]interface{}{"text": comment}); err != nil {
+		return fmt.Errorf("edit pull request comment: %w", err)
+	}
+	c.edited(existing, comment)
+	return nil
+}
+
+// SubmitReview writes every queued comment. Bitbucket has no review event, so a request for changes is written
+// as comments like any other
+func (c *bitbucketServerCommenter) SubmitReview(string) error {
+	for len(c.pending) > 0 {
+		pending := c.pending[0]
+		lineType := "CONTEXT"
+		if c.files.find(pending.filename).diff.isAdded(pending.endLine) {
+			lineType = "ADDED"
+		}
+		comment := map[string]interface{}{
+			"text": pending.comment,
+			"anchor": bitbucketServerAnchor{
+				Path:     pending.filename,
+				Line:     pending.endLine,
+				LineType: lineType,
+				FileType: "TO",
+				DiffType: "EFFECTIVE",
+			},
+		}
+		if _, err := c.client.do(http.MethodPost, c.prPath+"/comments", comment, nil); err != nil {
+			return fmt.Errorf("write pull request comment: %w", err)
+		}
+		c.pending = c.pending[1:]
+	}
+	return nil
+}
+
+// CleanupStaleComments resolves the comments from earlier runs whose finding is no longer reported. Bitbucket
+// can't hide a comment, so minimize resolves it as well
+func (c *bitbucketServerCommenter) CleanupStaleComments(current []findingComment, scope, action string, reply bool) (int, error) {
+	if action == staleActionNone {
+		return 0, nil
+	}
+
+	var cleaned int
+	for _, existing := range c.staleComments(current, scope) {
+		if reply {
+			comment := map[string]interface{}{
+				"text":   c.fixedComment(),
+				"parent": bitbucketRef{ID: *existing.commentId},
+			}
+			if _, err := c.client.do(http.MethodPost, c.prPath+"/comments", comment, nil); err != nil {
+				return cleaned, fmt.Errorf("write reply comment: %w", err)
+			}
+		}
+		if err := c.updateComment(*existing.commentId, map[string]interface{}{"threadResolved": true}); err != nil {
+			return cleaned, fmt.Errorf("%s stale comment: %w", action, err)
+		}
+		existing.resolved, existing.fixed = true, reply
+		cleaned++
+	}
+	return cleaned, nil
+}
+
+// PreviousSummary returns the body of the summary comment written on an earlier run, or empty if there isn't one
+func (c *bitbucketServerCommenter) PreviousSummary() (string, error) {
+	if c.summaryComment == nil {
+		return "", nil
+	}
+	return c.summaryComment.Text, nil
+}
+
+// WriteSummaryComment writes the summary comment on the pull request, updating the one from an earlier run in place
+func (c *bitbucketServerCommenter) WriteSummaryComment(comment string) error {
+	if c.summaryComment == nil {
+		_, err := c.client.do(http.MethodPost, c.prPath+"/comments", map[string]string{"text": comment}, nil)
+		return err
+	}
+	return c.updateComment(c.summaryComment.ID, map[string]interface{}{"text": comment})
+}
+
+// WriteFindingsReport replaces the tfsec Code Insights report on the head commit, with an annotation for every
+// result. Bitbucket Server only has three severities, so CRITICAL issues are HIGH
+func (c *bitbucketServerCommenter) WriteFindingsReport(results []result, findings []summaryFinding, decision policyDecision) error {
+	reportPath := fmt.Sprintf("/rest/insights/1.0%s/commits/%s/reports/%s", c.repoPath, c.headSHA, bitbucketReportId)
+	if _, err := c.client.do(http.MethodDelete, reportPath, nil, nil); err != nil && !isNotFound(err) {
+		return fmt.Errorf("delete code insights report: %w", err)
+	}
+
+	result := "PASS"
+	if decision.exitCode != exitCodeSuccess {
+		result = "FAIL"
+	}
+	report := map[string]interface{}{
+		"title":    checkRunName,
+		"details":  generateReportDetails(findings, decision),
+		"reporter": "tfsec-pr-commenter",
+		"result":   result,
+		"data": []map[string]interface{}{
+			{"title": "Issues", "type": "NUMBER", "value": len(findings)},
+		},
+	}
+	if _, err := c.client.do(http.MethodPut, reportPath, report, nil); err != nil {
+		return fmt.Errorf("write code insights report: %w", err)
+	}
+
+	var annotations []map[string]interface{}
+	for _, annotation := range buildBitbucketAnnotations(results) {
+		severity := annotation.severity
+		if severity == "CRITICAL" {
+			severity = "HIGH"
+		}
+		annotations = append(annotations, map[string]interface{}{
+			"externalId": annotation.externalId,
+			"type":       "VULNERABILITY",
+			"path":       annotation.path,
+			"line":       annotation.line,
+			"message":    annotation.summary,
+			"severity":   severity,
+		})
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	body := map[string]interface{}{"annotations": annotations}
+	if _, err := c.client.do(http.MethodPost, reportPath+"/annotations", body, nil); err != nil {
+		return fmt.Errorf("write code insights annotations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+const (
+	bitbucketServerTestPr     = "/rest/api/1.0/projects/PRJ/repos/infra/pull-requests/5"
+	bitbucketServerTestCommit = "d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4"
+)
+
+// newBitbucketServerTestApi serves the pull request, its diff and its activity over two pages from
+// testdata/bitbucket-server
+func newBitbucketServerTestApi(t *testing.T) *fakeApi {
+	api := newFakeApi(t)
+	api.replyFixture(http.MethodGet, bitbucketServerTestPr, "bitbucket-server/pull_request.json")
+	api.replyFixture(http.MethodGet, bitbucketServerTestPr+".diff", "bitbucket-server/pull_request.diff")
+	api.replyFixture(http.MethodGet, bitbucketServerTestPr+"/activities?limit=100&start=0", "bitbucket-server/activities_page1.json")
+	api.replyFixture(http.MethodGet, bitbucketServerTestPr+"/activities?limit=100&start=6", "bitbucket-server/activities_page2.json")
+	return api
+}
+
+func newBitbucketServerTestCommenter(t *testing.T, api *fakeApi) *bitbucketServerCommenter {
+	t.Helper()
+	c, err := newBitbucketServerCommenter(&restClient{
+		httpClient: restHttpClient,
+		baseURL:    api.url(),
+		header:     http.Header{"Authorization": []string{"Bearer secret"}},
+		owner:      "PRJ",
+		repo:       "infra",
+		prNumber:   5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestBitbucketServerCommenterLoadsPullRequest(t *testing.T) {
+	api := newBitbucketServerTestApi(t)
+	defer api.close()
+	c := newBitbucketServerTestCommenter(t, api)
+
+	if c.HeadSHA() != bitbucketServerTestCommit {
+		t.Errorf("HeadSHA = %s", c.HeadSHA())
+	}
+	if len(c.files) != 1 || !c.InChangedLines("infra/main.tf", 2, 2) || !c.InDiff("infra/main.tf", 11, 14) {
+		t.Errorf("the diff of infra/main.tf should be loaded without the deleted file")
+	}
+
+	// the deleted comment, the summary and activity other than comments are left out
+	var ids []int64
+	for _, existing := range c.existingComments {
+		ids = append(ids, *existing.commentId)
+	}
+	if len(ids) != 6 || ids[0] != 410 || ids[1] != 401 || ids[5] != 405 {
+		t.Fatalf("existing comments = %v, want 410 and 401 to 405", ids)
+	}
+	if !c.existingComments[0].resolved || c.existingComments[1].resolved || !c.existingComments[3].resolved {
+		t.Errorf("only the threads of comments 410 and 403 are resolved")
+	}
+	// the last reply to 403 is the one of the commenter, the thread of 410 was resolved by hand
+	if !c.existingComments[3].fixed || c.existingComments[0].fixed {
+		t.Errorf("only the thread of comment 403 was resolved as fixed by the commenter")
+	}
+	if c.summaryComment == nil || c.summaryComment.ID != 407 {
+		t.Errorf("summary comment = %+v, want 407", c.summaryComment)
+	}
+}
+
+func TestBitbucketServerCommenterWritesComments(t *testing.T) {
+	api := newBitbucketServerTestApi(t)
+	defer api.close()
+	api.reply(http.MethodPut, bitbucketServerTestPr+"/comments/402", `{"id":402,"version":3}`)
+	api.reply(http.MethodPost, bitbucketServerTestPr+"/comments", `{"id":408,"version":0}`)
+	c := newBitbucketServerTestCommenter(t, api)
+
+	added := testComment("aws-added", "f0f0f0f0")
+	context := testComment("aws-context", "f1f1f1f1")
+	reintroduced := testComment("aws-resolved", "cccc3333")
+	reworded := testComment("aws-new-wording", "bbbb2222")
+
+	if _, ok := c.WriteMultiLineComment("infra/main.tf", testComment("aws-already-written", "aaaa1111"), 2, 2).(CommentAlreadyWrittenError); !ok {
+		t.Errorf("a comment from an earlier run should not be written again")
+	}
+	// a deleted comment doesn't count as written
+	if err := c.WriteMultiLineComment("infra/main.tf", testComment("aws-deleted", "ffff6666"), 2, 2); err != nil {
+		t.Fatal(err)
+	}
+	// an issue a person dismissed by resolving its comment isn't commented on again
+	if _, ok := c.WriteMultiLineComment("infra/main.tf", testComment("aws-dismissed", "acac8888"), 2, 2).(CommentAlreadyWrittenError); !ok {
+		t.Errorf("a comment resolved by hand should not be written again")
+	}
+	if err := c.WriteMultiLineComment("infra/main.tf", reworded, 3, 3); err != nil {
+		t.Fatal(err)
+	}
+	for _, comment := range []struct {
+		body       string
+		start, end int
+	}{
+		{body: added, start: 2, end: 2},
+		{body: context, start: 11, end: 12},
+		{body: reintroduced, start: 2, end: 2},
+	} {
+		if err := c.WriteMultiLineComment("infra/main.tf", comment.body, comment.start, comment.end); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	edit := struct {
+		Text    string `json:"text"`
+		Version int    `json:"version"`
+	}{}
+	api.sentOnce(http.MethodPut, bitbucketServerTestPr+"/comments/402").decode(t, &edit)
+	if edit.Text != reworded || edit.Version != 2 {
+		t.Errorf("edit = %+v, want the new text at version 2", edit)
+	}
+	if c.versions[402] != 3 {
+		t.Errorf("version after the edit = %d, want 3", c.versions[402])
+	}
+
+	if err := c.SubmitReview("REQUEST_CHANGES"); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		text   string
+		anchor bitbucketServerAnchor
+	}{
+		{text: testComment("aws-deleted", "ffff6666"), anchor: bitbucketServerAnchor{Path: "infra/main.tf", Line: 2, LineType: "ADDED"}},
+		{text: added, anchor: bitbucketServerAnchor{Path: "infra/main.tf", Line: 2, LineType: "ADDED"}},
+		{text: context, anchor: bitbucketServerAnchor{Path: "infra/main.tf", Line: 12, LineType: "CONTEXT"}},
+		{text: reintroduced, anchor: bitbucketServerAnchor{Path: "infra/main.tf", Line: 2, LineType: "ADDED"}},
+	}
+	posts := api.sent(http.MethodPost, bitbucketServerTestPr+"/comments")
+	if len(posts) != len(want) {
+		t.Fatalf("got %d comments, want %d", len(posts), len(want))
+	}
+	for i, post := range posts {
+		comment := struct {
+			Text   string                `json:"text"`
+			Anchor bitbucketServerAnchor `json:"anchor"`
+		}{}
+		post.decode(t, &comment)
+
+		anchor := want[i].anchor
+		anchor.FileType, anchor.DiffType = "TO", "EFFECTIVE"
+		if comment.Text != want[i].text || comment.Anchor != anchor {
+			t.Errorf("comment %d = %+v, want %+v", i, comment, want[i])
+		}
+	}
+}
+
+func TestBitbucketServerCommenterResolvesStaleThreads(t *testing.T) {
+	api := newBitbucketServerTestApi(t)
+	defer api.close()
+	api.reply(http.MethodPost, bitbucketServerTestPr+"/comments", `{"id":408,"version":0}`)
+	api.reply(http.MethodPut, bitbucketServerTestPr+"/comments/404", `{"id":404,"version":2,"threadResolved":true}`)
+	c := newBitbucketServerTestCommenter(t, api)
+
+	current := []findingComment{
+		{filename: "infra/main.tf", comment: testComment("aws-already-written", "aaaa1111")},
+		{filename: "infra/main.tf", comment: testComment("aws-new-wording", "bbbb2222")},
+	}
+	cleaned, err := c.CleanupStaleComments(current, "infra/", staleActionResolve, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 403 is already resolved and 405 is outside the working directory
+	if cleaned != 1 {
+		t.Errorf("cleaned = %d, want 1", cleaned)
+	}
+
+	reply := struct {
+		Text   string       `json:"text"`
+		Parent bitbucketRef `json:"parent"`
+	}{}
+	api.sentOnce(http.MethodPost, bitbucketServerTestPr+"/comments").decode(t, &reply)
+	if reply.Text != fixedCommentFor(bitbucketServerTestCommit) || reply.Parent.ID != 404 {
+		t.Errorf("reply = %+v", reply)
+	}
+
+	// the thread is resolved rather than the comment state changed, which is only for tasks
+	var resolve map[string]interface{}
+	api.sentOnce(http.MethodPut, bitbucketServerTestPr+"/comments/404").decode(t, &resolve)
+	if len(resolve) != 2 || resolve["threadResolved"] != true || resolve["version"] != float64(1) {
+		t.Errorf("resolve = %v, want threadResolved at version 1", resolve)
+	}
+
+	if cleaned, err = c.CleanupStaleComments(current, "infra/", staleActionResolve, true); err != nil || cleaned != 0 {
+		t.Errorf("second cleanup = %d, %v, want nothing", cleaned, err)
+	}
+}
+
+func TestBitbucketServerCommenterUpdatesSummaryComment(t *testing.T) {
+	api := newBitbucketServerTestApi(t)
+	defer api.close()
+	api.reply(http.MethodPut, bitbucketServerTestPr+"/comments/407", `{"id":407,"version":4}`)
+	c := newBitbucketServerTestCommenter(t, api)
+
+	previous, err := c.PreviousSummary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous != summaryMarker+"\n## tfsec summary\n\n:white_check_mark: tfsec found no issues.\n" {
+		t.Errorf("PreviousSummary = %q", previous)
+	}
+	if err := c.WriteSummaryComment(summaryMarker + "\nupdated"); err != nil {
+		t.Fatal(err)
+	}
+	update := struct {
+		Text    string `json:"text"`
+		Version int    `json:"version"`
+	}{}
+	api.sentOnce(http.MethodPut, bitbucketServerTestPr+"/comments/407").decode(t, &update)
+	if update.Text != summaryMarker+"\nupdated" || update.Version != 3 {
+		t.Errorf("summary = %+v, want the new text at version 3", update)
+	}
+}
+
+func TestBitbucketServerCommenterWritesFindingsReport(t *testing.T) {
+	api := newBitbucketServerTestApi(t)
+	defer api.close()
+	reportPath := "/rest/insights/1.0/projects/PRJ/repos/infra/commits/" + bitbucketServerTestCommit + "/reports/tfsec"
+	api.reply(http.MethodDelete, reportPath, ``)
+	api.reply(http.MethodPut, reportPath, `{"key":"tfsec"}`)
+	api.reply(http.MethodPost, reportPath+"/annotations", ``)
+	c := newBitbucketServerTestCommenter(t, api)
+
+	results := []result{
+		{RuleID: "aws-added", Description: "bucket is public", Severity: "CRITICAL", Range: &checkRange{Filename: "infra/main.tf", StartLine: 2, EndLine: 2}},
+	}
+	if err := c.WriteFindingsReport(results, []summaryFinding{newSummaryFinding(results[0], true)}, passDecision()); err != nil {
+		t.Fatal(err)
+	}
+
+	report := struct {
+		Result string `json:"result"`
+	}{}
+	api.sentOnce(http.MethodPut, reportPath).decode(t, &report)
+	if report.Result != "PASS" {
+		t.Errorf("report result = %s", report.Result)
+	}
+
+	body := struct {
+		Annotations []map[string]interface{} `json:"annotations"`
+	}{}
+	api.sentOnce(http.MethodPost, reportPath+"/annotations").decode(t, &body)
+	// Bitbucket Server has no CRITICAL severity
+	if len(body.Annotations) != 1 || body.Annotations[0]["severity"] != "HIGH" || body.Annotations[0]["externalId"] != "tfsec-1" {
+		t.Errorf("annotations = %+v", body.Annotations)
+	}
+}
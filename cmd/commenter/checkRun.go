@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	checkRunName = "tfsec"
+	// githubMaxAnnotations is the most annotations the Checks API accepts in a single request
+	githubMaxAnnotations = 50
+)
+
+// findingsReporter is implemented by the platforms that can report every result alongside the comments, such as
+// the GitHub check run, so the issues outside the diff still show in the PR
+type findingsReporter interface {
+	WriteFindingsReport(results []result, findings []summaryFinding, decision policyDecision) error
+}
+
+// WriteFindingsReport writes the tfsec check run
+func (c *Commenter) WriteFindingsReport(results []result, findings []summaryFinding, decision policyDecision) error {
+	return c.WriteCheckRun(generateCheckRunSummary(findings, decision), checkRunConclusion(findings, decision), buildAnnotations(results))
+}
+
+// annotationLevel maps the severity of a result onto the level of a check run annotation
+func annotationLevel(severity string) string {
+	switch strings.ToUpper(severity) {
+	case "CRITICAL", "HIGH":
+		return "failure"
+	case "MEDIUM":
+		return "warning"
+	default:
+		return "notice"
+	}
+}
+
+// buildAnnotations annotates the lines of every result, whether or not they are part of the PR diff
+func buildAnnotations(results []result) []*github.CheckRunAnnotation {
+	var annotations []*github.CheckRunAnnotation
+	for _, result := range results {
+		title := fmt.Sprintf("%s: %s", result.Severity, result.RuleID)
+		var details []string
+		if result.Resolution != "" {
+			details = append(details, result.Resolution)
+		}
+		details = append(details, result.Links...)
+
+		annotation := &github.CheckRunAnnotation{
+			Path:            github.String(result.Range.Filename),
+			StartLine:       github.Int(result.Range.StartLine),
+			EndLine:         github.Int(result.Range.EndLine),
+			AnnotationLevel: github.String(annotationLevel(result.Severity)),
+			Message:         github.String(result.Description),
+			Title:           github.String(title),
+		}
+		if len(details) > 0 {
+			annotation.RawDetails = github.String(strings.Join(details, "\n"))
+		}
+		annotations = append(annotations, annotation)
+	}
+	return annotations
+}
+
+// checkRunConclusion fails the check run when the failure policy fails the run. Passing runs with issues are
+// neutral so the issues aren't hidden behind a green tick
+func checkRunConclusion(findings []summaryFinding, decision policyDecision) string {
+	switch {
+	case decision.exitCode != exitCodeSuccess:
+		return "failure"
+	case len(findings) > 0:
+		return "neutral"
+	default:
+		return "success"
+	}
+}
+
+// generateCheckRunSummary is the markdown shown on the check run page, with the findings table cut short like the
+// summary comment's when it would be too long
+func generateCheckRunSummary(findings []summaryFinding, decision policyDecision) string {
+	var sb strings.Builder
+	reason := fmt.Sprintf("\n%s\n", decision.reason)
+	if len(findings) == 0 {
+		sb.WriteString("tfsec found no issues.\n")
+	} else {
+		sb.WriteString(fmt.Sprintf("tfsec found **%d** issues.\n\n", len(findings)))
+		sb.WriteString(formatSeverityCounts(findings))
+		sb.WriteString("\n")
+		sb.WriteString(formatFindingsTable(findings, summaryMaxLength-sb.Len()-len(reason)))
+	}
+	sb.WriteString(reason)
+	return sb.String()
+}
+
+// WriteCheckRun creates the tfsec check run on the head of the PR. The Checks API only takes 50 annotations
+// per request, so the check run is created with the first 50 and the rest are added by updating it
+func (c *Commenter) WriteCheckRun(summary, conclusion string, annotations []*github.CheckRunAnnotation) error {
+
+	title := fmt.Sprintf("%d issues", len(annotations))
+	batch := func(i int) *github.CheckRunOutput {
+		end := i + githubMaxAnnotations
+		if end > len(annotations) {
+			end = len(annotations)
+		}
+		return &github.CheckRunOutput{
+			Title:       github.String(title),
+			Summary:     github.String(summary),
+			Annotations: annotations[i:end],
+		}
+	}
+	completedAt := &github.Timestamp{Time: time.Now()}
+
+	if len(annotations) <= githubMaxAnnotations {
+		_, err := c.ghConnector.createCheckRun(github.CreateCheckRunOptions{
+			Name:        checkRunName,
+			HeadSHA:     c.HeadSHA(),
+			Status:      github.String("completed"),
+			Conclusion:  github.String(conclusion),
+			CompletedAt: completedAt,
+			Output:      batch(0),
+		})
+		return err
+	}
+
+	checkRunId, err := c.ghConnector.createCheckRun(github.CreateCheckRunOptions{
+		Name:    checkRunName,
+		HeadSHA: c.HeadSHA(),
+		Status:  github.String("in_progress"),
+		Output:  batch(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := githubMaxAnnotations; i < len(annotations); i += githubMaxAnnotations {
+		opts := github.UpdateCheckRunOptions{
+			Name:   checkRunName,
+			Output: batch(i),
+		}
+		if i+githubMaxAnnotations >= len(annotations) {
+			opts.Status = github.String("completed")
+			opts.Conclusion = github.String(conclusion)
+			opts.CompletedAt = completedAt
+		}
+		if err := c.ghConnector.updateCheckRun(checkRunId, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const githubTestCheckRuns = "/repos/team/infra/check-runs"
+
+func testAnnotations(count int) []*github.CheckRunAnnotation {
+	var results []result
+	for i := 0; i < count; i++ {
+		results = append(results, result{
+			RuleID:      fmt.Sprintf("aws-rule-%d", i),
+			Range:       &checkRange{Filename: "infra/main.tf", StartLine: i + 1, EndLine: i + 1},
+			Description: "Bucket does not have encryption enabled",
+			Severity:    "HIGH",
+		})
+	}
+	return buildAnnotations(results)
+}
+
+func TestWriteCheckRunBatchesAnnotations(t *testing.T) {
+	tests := []struct {
+		annotations int
+		// annotations in each update after the check run is created
+		updates []int
+	}{
+		{annotations: 0},
+		{annotations: 50},
+		{annotations: 51, updates: []int{1}},
+		{annotations: 101, updates: []int{50, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d annotations", tt.annotations), func(t *testing.T) {
+			api := newGithubTestApi(t)
+			defer api.close()
+			api.reply(http.MethodPost, githubTestCheckRuns, `{"id":77}`)
+			api.reply(http.MethodPatch, githubTestCheckRuns+"/77", `{"id":77}`)
+			c := newGithubTestCommenter(t, api)
+
+			annotations := testAnnotations(tt.annotations)
+			if err := c.WriteCheckRun("summary", "failure", annotations); err != nil {
+				t.Fatal(err)
+			}
+
+			var create github.CreateCheckRunOptions
+			api.sentOnce(http.MethodPost, githubTestCheckRuns).decode(t, &create)
+			if create.Name != checkRunName || create.HeadSHA != githubTestCommit || create.Output.GetSummary() != "summary" {
+				t.Errorf("check run = %+v", create)
+			}
+			if title := fmt.Sprintf("%d issues", tt.annotations); create.Output.GetTitle() != title {
+				t.Errorf("title = %s, want %s", create.Output.GetTitle(), title)
+			}
+			first := tt.annotations
+			if first > githubMaxAnnotations {
+				first = githubMaxAnnotations
+			}
+			if len(create.Output.Annotations) != first {
+				t.Errorf("created with %d annotations, want %d", len(create.Output.Annotations), first)
+			}
+
+			updates := api.sent(http.MethodPatch, githubTestCheckRuns+"/77")
+			if len(updates) != len(tt.updates) {
+				t.Fatalf("got %d updates, want %d", len(updates), len(tt.updates))
+			}
+			if len(updates) == 0 {
+				// everything fits in the request creating the check run, so it is created completed
+				if create.GetStatus() != "completed" || create.GetConclusion() != "failure" || create.CompletedAt == nil {
+					t.Errorf("check run created %s with %s, want completed", create.GetStatus(), create.GetConclusion())
+				}
+				return
+			}
+			if create.GetStatus() != "in_progress" || create.Conclusion != nil || create.CompletedAt != nil {
+				t.Errorf("check run created %s with %s, want in progress", create.GetStatus(), create.GetConclusion())
+			}
+
+			line := first
+			for i, request := range updates {
+				var update github.UpdateCheckRunOptions
+				request.decode(t, &update)
+				if len(update.Output.Annotations) != tt.updates[i] || update.Output.Annotations[0].GetStartLine() != line+1 {
+					t.Errorf("update %d has %d annotations from line %d, want %d from line %d", i, len(update.Output.Annotations),
+						update.Output.Annotations[0].GetStartLine(), tt.updates[i], line+1)
+				}
+				line += len(update.Output.Annotations)
+
+				// only the last update completes the check run
+				last := i == len(updates)-1
+				if completed := update.GetStatus() == "completed" && update.GetConclusion() == "failure" && update.CompletedAt != nil; completed != last {
+					t.Errorf("update %d is %s with %s, want completed %t", i, update.GetStatus(), update.GetConclusion(), last)
+				}
+				if !last && (update.Status != nil || update.Conclusion != nil) {
+					t.Errorf("update %d should leave the check run in progress", i)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteCheckRunStopsOnFailedUpdate(t *testing.T) {
+	api := newGithubTestApi(t)
+	defer api.close()
+	api.reply(http.MethodPost, githubTestCheckRuns, `{"id":77}`)
+	api.handle(http.MethodPatch, githubTestCheckRuns+"/77", fakeResponse{status: http.StatusInternalServerError, body: `{"message":"failed"}`})
+	c := newGithubTestCommenter(t, api)
+
+	if err := c.WriteCheckRun("summary", "neutral", testAnnotations(101)); err == nil {
+		t.Errorf("a failed update should fail the check run")
+	}
+	if updates := api.sent(http.MethodPatch, githubTestCheckRuns+"/77"); len(updates) != 1 {
+		t.Errorf("got %d updates, want to stop after the failed one", len(updates))
+	}
+}
+
+func TestCheckRunConclusion(t *testing.T) {
+	findings := []summaryFinding{{Fingerprint: "aaaa1111", Severity: "HIGH"}}
+	failed := policyDecision{result: "diff", exitCode: exitCodeDiff}
+
+	if got := checkRunConclusion(findings, failed); got != "failure" {
+		t.Errorf("conclusion = %s, want failure when the policy fails", got)
+	}
+	if got := checkRunConclusion(findings, passDecision()); got != "neutral" {
+		t.Errorf("conclusion = %s, want neutral for issues that don't fail the policy", got)
+	}
+	if got := checkRunConclusion(nil, passDecision()); got != "success" {
+		t.Errorf("conclusion = %s, want success without issues", got)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+type checkovReport struct {
+	CheckType string `json:"check_type"`
+	Results   struct {
+		FailedChecks []checkovCheck `json:"failed_checks"`
+	} `json:"results"`
+	Summary checkovSummary `json:"summary"`
+	// CheckovVersion is set when checkov found nothing to scan, in which case the report is only the summary
+	CheckovVersion string `json:"checkov_version"`
+}
+
+type checkovSummary struct {
+	CheckovVersion string `json:"checkov_version"`
+}
+
+type checkovCheck struct {
+	CheckID       string `json:"check_id"`
+	BCCheckID     string `json:"bc_check_id"`
+	CheckName     string `json:"check_name"`
+	FilePath      string `json:"file_path"`
+	FileLineRange []int  `json:"file_line_range"`
+	Resource      string `json:"resource"`
+	Guideline     string `json:"guideline"`
+	Severity      string `json:"severity"`
+	Description   string `json:"description"`
+}
+
+// checkovDefaultSeverity is used when checkov doesn't report a severity, which is the case without a platform API key
+const checkovDefaultSeverity = "MEDIUM"
+
+type checkovAdapter struct{}
+
+func (checkovAdapter) format() string {
+	return "checkov"
+}
+
+// detect reports whether the content is the output of checkov -o json, which is a single report object or an
+// array of them when more than one framework is scanned. When there was nothing to scan checkov only writes the
+// summary, without a check type
+func (checkovAdapter) detect(content []byte) bool {
+	reports, err := parseCheckovReports(content)
+	if err != nil || len(reports) == 0 {
+		return false
+	}
+	for _, report := range reports {
+		if report.CheckType == "" && report.Summary.CheckovVersion == "" && report.CheckovVersion == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// parse maps each failed check onto a result
+func (checkovAdapter) parse(content []byte) ([]result, error) {
+	reports, err := parseCheckovReports(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []result
+	for _, report := range reports {
+		for _, check := range report.Results.FailedChecks {
+			var startLine, endLine int
+			if len(check.FileLineRange) == 2 {
+				startLine, endLine = check.FileLineRange[0], check.FileLineRange[1]
+			}
+
+			severity := strings.ToUpper(check.Severity)
+			if severity == "" {
+				severity = checkovDefaultSeverity
+			}
+
+			description := check.Description
+			if description == "" {
+				description = check.CheckName
+			}
+
+			var links []string
+			if check.Guideline != "" {
+				links = append(links, check.Guideline)
+			}
+
+			results = append(results, result{
+				RuleID:          check.CheckID,
+				RuleShortID:     check.BCCheckID,
+				RuleDescription: check.CheckName,
+				RuleProvider:    report.CheckType,
+				Links:           links,
+				Range: &checkRange{
+					// checkov paths are relative to the scanned directory with a leading slash
+					Filename:  strings.TrimPrefix(check.FilePath, "/"),
+					StartLine: startLine,
+					EndLine:   endLine,
+				},
+				Description: description,
+				Severity:    severity,
+				Resource:    check.Resource,
+			})
+		}
+	}
+	return results, nil
+}
+
+func parseCheckovReports(content []byte) ([]checkovReport, error) {
+	var reports []checkovReport
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '[' {
+		err := json.Unmarshal(trimmed, &reports)
+		return reports, err
+	}
+
+	var report checkovReport
+	if err := json.Unmarshal(content, &report); err != nil {
+		return nil, err
+	}
+	return append(reports, report), nil
+}
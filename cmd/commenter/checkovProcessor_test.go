@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestCheckovDetect(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		detect  bool
+	}{
+		{
+			name:    "report",
+			content: `{"check_type": "terraform", "results": {"failed_checks": []}, "summary": {"passed": 1, "failed": 0, "checkov_version": "2.0.1"}}`,
+			detect:  true,
+		},
+		{
+			name:    "reports",
+			content: `[{"check_type": "terraform", "results": {}}, {"check_type": "kubernetes", "results": {}}]`,
+			detect:  true,
+		},
+		{
+			name:    "nothing scanned",
+			content: `{"passed": 0, "failed": 0, "skipped": 0, "parsing_errors": 0, "resource_count": 0, "checkov_version": "2.0.1"}`,
+			detect:  true,
+		},
+		{
+			name:    "summary only",
+			content: `{"summary": {"passed": 0, "failed": 0, "checkov_version": "2.0.1"}}`,
+			detect:  true,
+		},
+		{
+			name:    "tfsec",
+			content: `{"results": [{"rule_id": "AWS001"}]}`,
+			detect:  false,
+		},
+		{
+			name:    "not json",
+			content: `checkov`,
+			detect:  false,
+		},
+	}
+	for _, test := range tests {
+		if got := (checkovAdapter{}).detect([]byte(test.content)); got != test.detect {
+			t.Errorf("%s: detect = %v, want %v", test.name, got, test.detect)
+		}
+	}
+}
+
+func TestCheckovParseSummaryOnly(t *testing.T) {
+	content := []byte(`{"passed": 0, "failed": 0, "skipped": 0, "parsing_errors": 0, "resource_count": 0, "checkov_version": "2.0.1"}`)
+	adapter, err := selectResultsAdapter(content, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adapter.format() != "checkov" {
+		t.Fatalf("detected %s, want checkov", adapter.format())
+	}
+	results, err := adapter.parse(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("parsed %d results, want none", len(results))
+	}
+}
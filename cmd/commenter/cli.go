@@ -0,0 +1,201 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Set at build time by goreleaser
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+const (
+	commandComment        = "comment"
+	commandSummary        = "summary"
+	commandCleanup        = "cleanup"
+	commandRender         = "render"
+	commandValidateConfig = "validate-config"
+	commandVersion        = "version"
+	commandHelp           = "help"
+)
+
+// settingFlag is a command line flag for a setting that is otherwise read from an environment variable. Flags
+// that are given override the variable, so everything downstream keeps reading the environment
+type settingFlag struct {
+	name    string
+	env     string
+	usage   string
+	boolean bool
+}
+
+var prNumberFlag = settingFlag{name: "pr-number", env: "INPUT_PR_NUMBER", usage: "PR to comment on, instead of reading it from the event"}
+
+var githubFlags = []settingFlag{
+	{name: "github-token", env: "INPUT_GITHUB_TOKEN", usage: "token used to call the GitHub API"},
+	{name: "github-app-id", env: "INPUT_GITHUB_APP_ID", usage: "ID of a GitHub App to authenticate as instead of the token"},
+	{name: "github-app-private-key-file", env: "INPUT_GITHUB_APP_PRIVATE_KEY_FILE", usage: "PEM private key of the GitHub App"},
+	{name: "github-app-installation-id", env: "INPUT_GITHUB_APP_INSTALLATION_ID", usage: "installation of the GitHub App (default the installation on the repository)"},
+	{name: "repository", env: "GITHUB_REPOSITORY", usage: "repository of the PR as owner/name"},
+	prNumberFlag,
+	{name: "event-name", env: "GITHUB_EVENT_NAME", usage: "name of the event that triggered the workflow"},
+	{name: "event-path", env: "GITHUB_EVENT_PATH", usage: "path of the event payload"},
+	{name: "api-url", env: "GITHUB_API_URL", usage: "GitHub API url, for GitHub Enterprise"},
+}
+
+var platformFlags = []settingFlag{
+	{name: "platform", env: "INPUT_PLATFORM", usage: "github, gitlab, bitbucket, bitbucket-server, azure-devops or gitea (default detected from the CI variables, otherwise github)"},
+	{name: "gitlab-token", env: "INPUT_GITLAB_TOKEN", usage: "token used to call the GitLab API, falling back to GITLAB_TOKEN"},
+	{name: "bitbucket-token", env: "INPUT_BITBUCKET_TOKEN", usage: "access token used to call the Bitbucket API, falling back to BITBUCKET_ACCESS_TOKEN"},
+	{name: "azure-devops-token", env: "INPUT_AZURE_DEVOPS_TOKEN", usage: "token used to call the Azure DevOps API, falling back to SYSTEM_ACCESSTOKEN"},
+	{name: "gitea-token", env: "INPUT_GITEA_TOKEN", usage: "token used to call the Gitea API, falling back to INPUT_GITHUB_TOKEN"},
+}
+
+var resultsFlags = []settingFlag{
+	{name: "config", env: "INPUT_CONFIG_FILE", usage: "config file (default .tfsec-commenter.yml in the workspace)"},
+	{name: "results", env: "INPUT_RESULTS_FILE", usage: "comma separated results files or globs (default results.json)"},
+	{name: "results-format", env: "INPUT_RESULTS_FORMAT", usage: "auto, tfsec, sarif, trivy, checkov or tflint (default auto)"},
+	{name: "workspace", env: "GITHUB_WORKSPACE", usage: "path removed from the start of filenames in the results (default the current directory)"},
+	{name: "working-directory", env: "INPUT_WORKING_DIRECTORY", usage: "directory the results are relative to, from the repository root"},
+	{name: "minimum-severity", env: "INPUT_MINIMUM_SEVERITY", usage: "ignore results below LOW, MEDIUM, HIGH or CRITICAL"},
+	{name: "comment-severity", env: "INPUT_COMMENT_SEVERITY", usage: "only summarise results below LOW, MEDIUM, HIGH or CRITICAL"},
+	{name: "include-rules", env: "INPUT_INCLUDE_RULES", usage: "comma separated rules to keep"},
+	{name: "exclude-rules", env: "INPUT_EXCLUDE_RULES", usage: "comma separated rules to ignore"},
+	{name: "include-paths", env: "INPUT_INCLUDE_PATHS", usage: "comma separated path globs to keep"},
+	{name: "exclude-paths", env: "INPUT_EXCLUDE_PATHS", usage: "comma separated path globs to ignore"},
+	{name: "comment-template", env: "INPUT_COMMENT_TEMPLATE", usage: "text/template file used to render each comment"},
+	{name: "server-url", env: "GITHUB_SERVER_URL", usage: "GitHub url used in comment templates (default https://github.com)"},
+}
+
+var reviewFlags = []settingFlag{
+	{name: "review-event", env: "INPUT_REVIEW_EVENT", usage: "COMMENT or REQUEST_CHANGES (default COMMENT)"},
+	{name: "stale-comments", env: "INPUT_STALE_COMMENTS", usage: "resolve, minimize or none for comments on fixed issues (default resolve)"},
+	{name: "reply-on-fix", env: "INPUT_REPLY_ON_FIX", usage: "reply to comments on fixed issues with the commit that fixed them", boolean: true},
+	{name: "summary-comment", env: "INPUT_SUMMARY_COMMENT", usage: "write the summary comment (default false)"},
+	{name: "check-run", env: "INPUT_CHECK_RUN", usage: "create a tfsec check run annotating every result, a Code Insights report on Bitbucket", boolean: true},
+	{name: "workflow-annotations", env: "INPUT_WORKFLOW_ANNOTATIONS", usage: "auto, always or never print workflow annotations for each result (default auto)"},
+}
+
+var policyFlags = []settingFlag{
+	{name: "fail-on", env: "INPUT_FAIL_ON", usage: "comma separated conditions that fail the run (default diff,errors)"},
+	{name: "fail-severity", env: "INPUT_FAIL_SEVERITY", usage: "only consider issues at or above LOW, MEDIUM, HIGH or CRITICAL for fail-on"},
+	{name: "soft-fail", env: "INPUT_SOFT_FAIL_COMMENTER", usage: "only fail the run for errors writing to the PR", boolean: true},
+}
+
+var commandUsages = []struct {
+	name  string
+	usage string
+}{
+	{commandComment, "comment on the PR, clean up comments for fixed issues and write the summary (default)"},
+	{commandSummary, "only write the summary comment"},
+	{commandCleanup, "only clean up the comments for issues that have been fixed"},
+	{commandRender, "print the comment for each result without calling GitHub"},
+	{commandValidateConfig, "check the config file and settings without calling GitHub"},
+	{commandVersion, "print the version"},
+	{commandHelp, "print this help"},
+}
+
+// runCli runs the subcommand named by the first argument. With no subcommand it comments on the PR, as the
+// action always has
+func runCli(args []string) {
+	command := commandComment
+	if len(args) > 0 {
+		switch {
+		case !strings.HasPrefix(args[0], "-"):
+			command, args = args[0], args[1:]
+		case args[0] == "-h" || args[0] == "-help" || args[0] == "--help":
+			command = commandHelp
+		case args[0] == "-version" || args[0] == "--version":
+			command = commandVersion
+		}
+	}
+
+	switch command {
+	case commandComment:
+		flags := newCommandFlags(command, platformFlags, githubFlags, resultsFlags, reviewFlags, policyFlags)
+		dryRun := flags.Bool("dry-run", false, "render the comments locally without calling GitHub")
+		diffFile := flags.String("diff-file", "", "unified diff file to decide which lines are part of the change, for --dry-run")
+		gitRange := flags.String("git-range", "", "git range such as main...HEAD to diff locally, for --dry-run")
+		output := flags.String("output", "markdown", "output format for --dry-run, either markdown or json")
+		parseCommandFlags(flags, args)
+		if *dryRun {
+			runDryRun(*diffFile, *gitRange, *output, os.Stdout)
+			return
+		}
+		runComment(command)
+	case commandSummary, commandCleanup:
+		parseCommandFlags(newCommandFlags(command, platformFlags, githubFlags, resultsFlags, reviewFlags, policyFlags), args)
+		runComment(command)
+	case commandRender:
+		parseCommandFlags(newCommandFlags(command, resultsFlags, []settingFlag{prNumberFlag}), args)
+		runRender(os.Stdout)
+	case commandValidateConfig:
+		parseCommandFlags(newCommandFlags(command, resultsFlags, reviewFlags, policyFlags), args)
+		runValidateConfig()
+	case commandVersion:
+		fmt.Printf("commenter %s (commit %s, built %s)\n", version, commit, date)
+	case commandHelp:
+		printUsage()
+	default:
+		printUsage()
+		fail(fmt.Sprintf("unknown command %s", command))
+	}
+}
+
+func newCommandFlags(command string, groups ...[]settingFlag) *flag.FlagSet {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	for _, group := range groups {
+		for _, setting := range group {
+			usage := fmt.Sprintf("%s, overrides %s", setting.usage, setting.env)
+			if setting.boolean {
+				flags.Bool(setting.name, false, usage)
+			} else {
+				flags.String(setting.name, "", usage)
+			}
+		}
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: commenter %s [flags]\n\nFlags:\n", command)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseCommandFlags parses the flags and copies every setting that was given into its environment variable
+func parseCommandFlags(flags *flag.FlagSet, args []string) {
+	_ = flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		fail(fmt.Sprintf("unexpected arguments %s", strings.Join(flags.Args(), " ")))
+	}
+
+	envs := make(map[string]string)
+	for _, group := range [][]settingFlag{platformFlags, githubFlags, resultsFlags, reviewFlags, policyFlags} {
+		for _, setting := range group {
+			envs[setting.name] = setting.env
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		if env, ok := envs[f.Name]; ok {
+			_ = os.Setenv(env, f.Value.String())
+		}
+	})
+}
+
+func printUsage() {
+	fmt.Println("Usage: commenter [command] [flags]")
+	fmt.Println()
+	fmt.Println("Comments on a pull request with the results of tfsec and other IaC scanners. Every flag falls back")
+	fmt.Println("to the environment variable the GitHub action sets.")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, command := range commandUsages {
+		fmt.Printf("  %-16s %s\n", command.name, command.usage)
+	}
+	fmt.Println()
+	fmt.Println("Run commenter <command> -h for the flags of each command.")
+}
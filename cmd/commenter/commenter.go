@@ -1,153 +1,186 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"net/url"
 	"os"
-	"strconv"
 	"strings"
-	"net/url"
 
-	"github.com/owenrumney/go-github-pr-commenter/commenter"
+	"github.com/google/go-github/v32/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// commentPrefix starts every comment written before fingerprints were added
+	commentPrefix = ":warning: tfsec found a "
+
+	staleActionResolve  = "resolve"
+	staleActionMinimize = "minimize"
+	staleActionNone     = "none"
 )
 
 func main() {
-	fmt.Println("Starting the github commenter")
+	runCli(os.Args[1:])
+}
 
-	token := os.Getenv("INPUT_GITHUB_TOKEN")
-	if len(token) == 0 {
-		fail("the INPUT_GITHUB_TOKEN has not been set")
+// runComment comments on the PR for the comment command, or only does the part of it needed by the summary and
+// cleanup commands
+func runComment(command string) {
+	logln("Starting the commenter")
+
+	platform, err := extractPlatform()
+	if err != nil {
+		fail(err.Error())
 	}
 
-	githubRepository := os.Getenv("GITHUB_REPOSITORY")
-	split := strings.Split(githubRepository, "/")
-	if len(split) != 2 {
-		fail(fmt.Sprintf("unexpected value for GITHUB_REPOSITORY. Expected <organisation/name>, found %v", split))
+	prNo, err := platform.pullRequestNumber()
+	if err != nil {
+		logln("Not a PR, nothing to comment on, exiting")
+		return
 	}
-	owner := split[0]
-	repo := split[1]
+	logf("Working in PR %v\n", prNo)
 
-	fmt.Printf("Working in repository %s\n", repo)
+	config, err := extractRepositoryConfig()
+	if err != nil {
+		fail(err.Error())
+	}
 
-	prNo, err := extractPullRequestNumber()
+	settings, err := extractRunSettings(config)
 	if err != nil {
-		fmt.Println("Not a PR, nothing to comment on, exiting")
-		return
+		fail(err.Error())
 	}
-	fmt.Printf("Working in PR %v\n", prNo)
 
-	results, err := loadResultsFile()
+	policy, err := extractFailurePolicy()
+	if err != nil {
+		fail(err.Error())
+	}
+
+	switch command {
+	case commandSummary:
+		settings.inlineComments, settings.cleanupComments, settings.summaryComment = false, false, true
+		settings.checkRun = false
+	case commandCleanup:
+		settings.inlineComments, settings.cleanupComments, settings.summaryComment = false, true, false
+		settings.checkRun = false
+		// findings are only there to work out which comments are stale, so only errors fail the run
+		policy = &failurePolicy{conditions: map[string]bool{failOnErrors: true}}
+	}
+	if err := policy.checkSummaryComment(settings.summaryComment); err != nil {
+		fail(err.Error())
+	}
+	settings.policy = policy
+
+	results, err := loadResultsFiles(splitList(os.Getenv("INPUT_RESULTS_FILE")), os.Getenv("INPUT_RESULTS_FORMAT"))
 	if err != nil {
 		fail(fmt.Sprintf("failed to load results. %s", err.Error()))
 	}
 
 	if len(results) == 0 {
-		fmt.Println("No issues found.")
-		os.Exit(0)
+		logln("No issues found.")
+		if !settings.summaryComment && !settings.cleanupComments {
+			exit(passDecision())
+		}
+	} else {
+		logf("TFSec found %v issues\n", len(results))
 	}
-	fmt.Printf("TFSec found %v issues\n", len(results))
 
-	c, err := createCommenter(token, owner, repo, prNo)
+	c, err := platform.connect(prNo)
 	if err != nil {
-		fail(fmt.Sprintf("could not connect to GitHub (%s)", err.Error()))
-	}
-
-	workspacePath := fmt.Sprintf("%s/", os.Getenv("GITHUB_WORKSPACE"))
-	fmt.Printf("Working in GITHUB_WORKSPACE %s\n", workspacePath)
-
-	workingDir := os.Getenv("INPUT_WORKING_DIRECTORY")
-	if workingDir != "" {
-		workingDir = strings.TrimPrefix(workingDir, "./")
-		workingDir = strings.TrimSuffix(workingDir, "/") + "/"
-	}
-
-	var errMessages []string
-	var validCommentWritten bool
-	for _, result := range results {
-		result.Range.Filename = workingDir + strings.ReplaceAll(result.Range.Filename, workspacePath, "")
-		comment := generateErrorMessage(result)
-		fmt.Printf("Preparing comment for violation of rule %v in %v\n", result.RuleID, result.Range.Filename)
-		err := c.WriteMultiLineComment(result.Range.Filename, comment, result.Range.StartLine, result.Range.EndLine)
-		if err != nil {
-			// don't error if its simply that the comments aren't valid for the PR
-			switch err.(type) {
-			case commenter.CommentAlreadyWrittenError:
-				fmt.Println("Ignoring - comment already written")
-				validCommentWritten = true
-			case commenter.CommentNotValidError:
-				fmt.Println("Ignoring - change not part of the current PR")
-				continue
-			default:
-				errMessages = append(errMessages, err.Error())
-			}
-		} else {
-			validCommentWritten = true
-			fmt.Printf("Commenting for %s to %s:%d:%d\n", result.Description, result.Range.Filename, result.Range.StartLine, result.Range.EndLine)
-		}
+		fail(fmt.Sprintf("could not connect to %s (%s)", platform.name(), err.Error()))
 	}
 
-	if len(errMessages) > 0 {
-		fmt.Printf("There were %d errors:\n", len(errMessages))
-		for _, err := range errMessages {
-			fmt.Println(err)
+	settings.renderer, err = newCommentRenderer(os.Getenv("INPUT_COMMENT_TEMPLATE"), platform.commentContext(prNo, c.HeadSHA()))
+	if err != nil {
+		fail(err.Error())
+	}
+
+	if settings.workflowAnnotations == workflowAnnotationsAuto && platform.readOnly() {
+		logln("The PR is from a fork so the token is read-only, falling back to workflow annotations")
+		settings.readOnly = true
+	}
+
+	report := processResults(c, results, settings)
+	if reporter, ok := c.(findingsReporter); ok && settings.checkRun && !report.readOnly {
+		decision := policy.decide(report.findings, report.previousSummary, len(report.errMessages))
+		logf("Writing the findings report with %d annotations\n", len(report.results))
+		report.writeFailed(settings, reporter.WriteFindingsReport(report.results, report.findings, decision))
+	}
+	if report.readOnly || settings.workflowAnnotations == workflowAnnotationsAlways {
+		if err := writeWorkflowAnnotations(os.Stdout, report.results); err != nil {
+			report.errMessages = append(report.errMessages, err.Error())
 		}
-		os.Exit(1)
 	}
-	if validCommentWritten || len(errMessages) > 0 {
-		if softFail, ok := os.LookupEnv("INPUT_SOFT_FAIL_COMMENTER"); ok && strings.ToLower(softFail) == "true" {
-			return
+	if len(report.errMessages) > 0 {
+		logf("There were %d errors:\n", len(report.errMessages))
+		for _, err := range report.errMessages {
+			logln(err)
 		}
-		os.Exit(1)
 	}
+	exit(policy.decide(report.findings, report.previousSummary, len(report.errMessages)))
 }
 
-func createCommenter(token, owner, repo string, prNo int) (*commenter.Commenter, error) {
-	var err error
-	var c *commenter.Commenter
-
-	githubApiUrl := os.Getenv("GITHUB_API_URL")
-	if githubApiUrl == "" || githubApiUrl == "https://api.github.com" {
-		c, err = commenter.NewCommenter(token, owner, repo, prNo)
-	} else {
-		url, err := url.Parse(githubApiUrl)
-		if err == nil {
-			enterpriseUrl := fmt.Sprintf("%s://%s", url.Scheme, url.Hostname())
-			c, err = commenter.NewEnterpriseCommenter(token, enterpriseUrl, enterpriseUrl, owner, repo, prNo)	
-		}
+func createCommenter(tokenSource oauth2.TokenSource, owner, repo string, prNo int) (*Commenter, error) {
+	enterpriseUrl, err := extractEnterpriseUrl()
+	if err != nil {
+		return nil, err
 	}
-
-	return c, err
+	if enterpriseUrl == "" {
+		return NewCommenter(tokenSource, owner, repo, prNo)
+	}
+	return NewEnterpriseCommenter(tokenSource, enterpriseUrl, enterpriseUrl, owner, repo, prNo)
 }
 
-func generateErrorMessage(result result) string {
-	return fmt.Sprintf(`:warning: tfsec found a **%s** severity issue from rule `+"`%s`"+`:
-> %s
-
-More information available %s`,
-		result.Severity, result.RuleID, result.Description, formatUrls(result.Links))
+func createGithubClient(tokenSource oauth2.TokenSource) (*github.Client, error) {
+	enterpriseUrl, err := extractEnterpriseUrl()
+	if err != nil {
+		return nil, err
+	}
+	if enterpriseUrl == "" {
+		return github.NewClient(newHttpClient(tokenSource)), nil
+	}
+	return github.NewEnterpriseClient(enterpriseUrl, enterpriseUrl, newHttpClient(tokenSource))
 }
 
-func extractPullRequestNumber() (int, error) {
-	github_event_file := "/github/workflow/event.json"
-	file, err := ioutil.ReadFile(github_event_file)
+// extractEnterpriseUrl returns the GitHub Enterprise server from GITHUB_API_URL, or empty when using github.com
+func extractEnterpriseUrl() (string, error) {
+	githubApiUrl := os.Getenv("GITHUB_API_URL")
+	if githubApiUrl == "" || githubApiUrl == "https://api.github.com" {
+		return "", nil
+	}
+	url, err := url.Parse(githubApiUrl)
 	if err != nil {
-		fail(fmt.Sprintf("GitHub event payload not found in %s", github_event_file))
-		return -1, err
+		return "", err
 	}
+	return fmt.Sprintf("%s://%s", url.Scheme, url.Hostname()), nil
+}
 
-	var data interface{}
-	err = json.Unmarshal(file, &data)
-	if err != nil {
-		return -1, err
+func extractReviewEvent() (string, error) {
+	reviewEvent := strings.ToUpper(os.Getenv("INPUT_REVIEW_EVENT"))
+	switch reviewEvent {
+	case "":
+		return "COMMENT", nil
+	case "COMMENT", "REQUEST_CHANGES":
+		return reviewEvent, nil
 	}
-	payload := data.(map[string]interface{})
+	return "", fmt.Errorf("unexpected value for INPUT_REVIEW_EVENT. Expected COMMENT or REQUEST_CHANGES, found %s", reviewEvent)
+}
 
-	prNumber, err := strconv.Atoi(fmt.Sprintf("%v", payload["number"]))
-	if err != nil {
-		return 0, fmt.Errorf("not a valid PR")
+func extractStaleCommentAction() (string, error) {
+	staleAction := strings.ToLower(os.Getenv("INPUT_STALE_COMMENTS"))
+	switch staleAction {
+	case "":
+		return staleActionResolve, nil
+	case staleActionResolve, staleActionMinimize, staleActionNone:
+		return staleAction, nil
 	}
-	return prNumber, nil
+	return "", fmt.Errorf("unexpected value for INPUT_STALE_COMMENTS. Expected resolve, minimize or none, found %s", staleAction)
+}
+
+// isCommenterComment reports whether a review comment was written by the commenter, either carrying a
+// fingerprint or in the format used before fingerprints were added
+func isCommenterComment(comment string) bool {
+	return extractFingerprint(comment) != "" || strings.HasPrefix(comment, commentPrefix)
 }
 
 func formatUrls(urls []string) string {
@@ -161,7 +194,28 @@ func formatUrls(urls []string) string {
 	return urlList
 }
 
+// exit reports the decision of the failure policy, both in the log and as action outputs, and exits with its code
+func exit(decision policyDecision) {
+	if err := writeActionOutputs(decision); err != nil {
+		logf("Warning: could not write action outputs (%s)\n", err.Error())
+	}
+	logln(decision.reason)
+	os.Exit(decision.exitCode)
+}
+
+// logOutput is where the progress of a run is logged. The local commands move it to stderr, keeping stdout for
+// the report they print
+var logOutput io.Writer = os.Stdout
+
+func logf(format string, a ...interface{}) {
+	fmt.Fprintf(logOutput, format, a...)
+}
+
+func logln(a ...interface{}) {
+	fmt.Fprintln(logOutput, a...)
+}
+
 func fail(err string) {
-	fmt.Printf("Error: %s\n", err)
+	logf("Error: %s\n", err)
 	os.Exit(-1)
 }
@@ -0,0 +1,47 @@
+package main
+
+type commitFileInfo struct {
+	FileName     string
+	diff         *fileDiff
+	sha          string
+	likelyBinary bool
+}
+
+// changedFiles is every file changed in the PR, from whichever diffProvider loaded them
+type changedFiles []*commitFileInfo
+
+func (cfi commitFileInfo) isBinary() bool {
+	return cfi.likelyBinary
+}
+
+// isResolvable reports whether lines in the file can be commented on
+func (cfi commitFileInfo) isResolvable() bool {
+	return !cfi.isBinary() && cfi.diff != nil
+}
+
+// find returns the changed file if its lines can be commented on
+func (files changedFiles) find(filename string) *commitFileInfo {
+	for _, info := range files {
+		if info.FileName == filename && info.isResolvable() {
+			return info
+		}
+	}
+	return nil
+}
+
+// containsLine reports whether the line of the file appears in its diff as an added or context line
+func (files changedFiles) containsLine(filename string, line int) bool {
+	info := files.find(filename)
+	return info != nil && info.diff.containsLine(line)
+}
+
+// inDiff reports whether both ends of the line range are part of the diff for the file
+func (files changedFiles) inDiff(filename string, startLine, endLine int) bool {
+	return files.containsLine(filename, startLine) && files.containsLine(filename, endLine)
+}
+
+// inChangedLines reports whether any line in the range was added or modified
+func (files changedFiles) inChangedLines(filename string, startLine, endLine int) bool {
+	info := files.find(filename)
+	return info != nil && info.diff.addedInRange(startLine, endLine)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	githubAbuseErrorRetries = 6
+	githubPageSize          = 100
+	// githubMaxPrFiles is the most files the GitHub API will list for a single PR
+	githubMaxPrFiles = 3000
+)
+
+type connector struct {
+	prs          *github.PullRequestsService
+	comments     *github.IssuesService
+	checks       *github.ChecksService
+	graphql      *graphqlClient
+	owner        string
+	repo         string
+	prNumber     int
+	headSHA      string
+	changedFiles int
+}
+
+type existingComment struct {
+	filename    *string
+	comment     *string
+	commentId   *int64
+	nodeId      *string
+	fingerprint string
+	// resolved is set when the comment's thread was resolved or the comment was minimized
+	resolved bool
+	// fixed is set when the comment is still resolved by the commenter itself, which replied that the issue was
+	// fixed. A comment resolved by hand isn't fixed, so an issue a person dismissed isn't commented on again
+	fixed bool
+}
+
+type commentFn func() (*github.Response, error)
+
+// create github connector and check if supplied pr number exists
+func createConnector(tokenSource oauth2.TokenSource, owner, repo string, prNumber int) (*connector, error) {
+
+	httpClient := newHttpClient(tokenSource)
+	return newConnector(github.NewClient(httpClient), httpClient, owner, repo, prNumber)
+}
+
+// create github connector and check if supplied pr number exists
+func createEnterpriseConnector(tokenSource oauth2.TokenSource, baseUrl, uploadUrl, owner, repo string, prNumber int) (*connector, error) {
+
+	httpClient := newHttpClient(tokenSource)
+	client, err := github.NewEnterpriseClient(baseUrl, uploadUrl, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return newConnector(client, httpClient, owner, repo, prNumber)
+}
+
+func newConnector(client *github.Client, httpClient *http.Client, owner, repo string, prNumber int) (*connector, error) {
+
+	pr, _, err := client.PullRequests.Get(context.Background(), owner, repo, prNumber)
+	if err != nil {
+		return nil, newPrDoesNotExistError(owner, repo, prNumber)
+	}
+
+	return &connector{
+		prs:          client.PullRequests,
+		comments:     client.Issues,
+		checks:       client.Checks,
+		graphql:      newGraphqlClient(httpClient, client.BaseURL),
+		owner:        owner,
+		repo:         repo,
+		prNumber:     prNumber,
+		headSHA:      pr.GetHead().GetSHA(),
+		changedFiles: pr.GetChangedFiles(),
+	}, nil
+}
+
+func newHttpClient(tokenSource oauth2.TokenSource) *http.Client {
+
+	ctx := context.Background()
+	return oauth2.NewClient(ctx, tokenSource)
+}
+
+// staticTokenSource is the token source for a personal access or workflow token, which never changes
+func staticTokenSource(token string) oauth2.TokenSource {
+	return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+}
+
+func (c *connector) writeReview(review *github.PullRequestReviewRequest) error {
+
+	ctx := context.Background()
+	return writeCommentWithRetries(c.owner, c.repo, c.prNumber, func() (*github.Response, error) {
+		_, resp, err := c.prs.CreateReview(ctx, c.owner, c.repo, c.prNumber, review)
+		return resp, err
+	})
+}
+
+func (c *connector) editReviewComment(commentId int64, body string) error {
+
+	ctx := context.Background()
+	return writeCommentWithRetries(c.owner, c.repo, c.prNumber, func() (*github.Response, error) {
+		_, resp, err := c.prs.EditComment(ctx, c.owner, c.repo, commentId, &github.PullRequestComment{
+			Body: &body,
+		})
+		return resp, err
+	})
+}
+
+func (c *connector) writeGeneralComment(comment *github.IssueComment) error {
+
+	ctx := context.Background()
+	writeReviewCommentFn := func() (*github.Response, error) {
+		_, resp, err := c.comments.CreateComment(ctx, c.owner, c.repo, c.prNumber, comment)
+		return resp, err
+	}
+	return writeCommentWithRetries(c.owner, c.repo, c.prNumber, writeReviewCommentFn)
+}
+
+func (c *connector) editGeneralComment(commentId int64, comment *github.IssueComment) error {
+
+	ctx := context.Background()
+	return writeCommentWithRetries(c.owner, c.repo, c.prNumber, func() (*github.Response, error) {
+		_, resp, err := c.comments.EditComment(ctx, c.owner, c.repo, commentId, comment)
+		return resp, err
+	})
+}
+
+func (c *connector) createCheckRun(opts github.CreateCheckRunOptions) (int64, error) {
+
+	ctx := context.Background()
+	var checkRunId int64
+	err := writeCommentWithRetries(c.owner, c.repo, c.prNumber, func() (*github.Response, error) {
+		checkRun, resp, err := c.checks.CreateCheckRun(ctx, c.owner, c.repo, opts)
+		checkRunId = checkRun.GetID()
+		return resp, err
+	})
+	return checkRunId, err
+}
+
+func (c *connector) updateCheckRun(checkRunId int64, opts github.UpdateCheckRunOptions) error {
+
+	ctx := context.Background()
+	return writeCommentWithRetries(c.owner, c.repo, c.prNumber, func() (*github.Response, error) {
+		_, resp, err := c.checks.UpdateCheckRun(ctx, c.owner, c.repo, checkRunId, opts)
+		return resp, err
+	})
+}
+
+func writeCommentWithRetries(owner, repo string, prNumber int, commentFn commentFn) error {
+
+	var abuseError AbuseRateLimitError
+	for i := 0; i < githubAbuseErrorRetries; i++ {
+
+		retrySeconds := i * i
+		time.Sleep(time.Second * time.Duration(retrySeconds))
+
+		if resp, err := commentFn(); err != nil {
+			if isSecondaryRateLimitError(resp, err) {
+				abuseError = newAbuseRateLimitError(owner, repo, prNumber, retrySeconds)
+				continue
+			}
+			if resp != nil && resp.StatusCode == 403 && !isRateLimitError(err) {
+				return newPermissionDeniedError(owner, repo, prNumber)
+			}
+			return fmt.Errorf("write comment: %w", err)
+		}
+		return nil
+	}
+	return abuseError
+}
+
+// isSecondaryRateLimitError reports whether a write was refused for being one of too many in a short time, which
+// is worth retrying. GitHub reports this either as an abuse rate limit or as a 422 saying the content was
+// submitted too quickly, any other 422 is a validation error that would fail again
+func isSecondaryRateLimitError(resp *github.Response, err error) bool {
+	if _, ok := err.(*github.AbuseRateLimitError); ok {
+		return true
+	}
+	return resp != nil && resp.StatusCode == http.StatusUnprocessableEntity && strings.Contains(err.Error(), "submitted too quickly")
+}
+
+// isValidationError reports whether GitHub rejected a write as invalid, such as a review comment on a line it
+// can't place
+func isValidationError(err error) bool {
+	var errResp *github.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusUnprocessableEntity
+}
+
+// isRateLimitError reports whether a 403 is because of rate limiting rather than permissions
+func isRateLimitError(err error) bool {
+	switch err.(type) {
+	case *github.RateLimitError, *github.AbuseRateLimitError:
+		return true
+	}
+	return false
+}
+
+func (c *connector) getFilesForPr() ([]*github.CommitFile, error) {
+
+	if c.changedFiles > githubMaxPrFiles {
+		logf("Warning: PR has %d changed files but GitHub only lists the first %d, "+
+			"issues in the remaining files can't be commented on\n", c.changedFiles, githubMaxPrFiles)
+	}
+
+	ctx := context.Background()
+	opts := &github.ListOptions{PerPage: githubPageSize}
+
+	var commitFiles []*github.CommitFile
+	for {
+		files, resp, err := c.prs.ListFiles(ctx, c.owner, c.repo, c.prNumber, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if *file.Status != "deleted" {
+				commitFiles = append(commitFiles, file)
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return commitFiles, nil
+}
+
+func (c *connector) getExistingComments() ([]*existingComment, error) {
+
+	ctx := context.Background()
+	opts := &github.PullRequestListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: githubPageSize},
+	}
+
+	var comments []*github.PullRequestComment
+	for {
+		page, resp, err := c.prs.ListComments(ctx, c.owner, c.repo, c.prNumber, opts)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	var existingComments []*existingComment
+	for _, comment := range comments {
+		// replies are part of an existing thread so aren't considered for dedupe or cleanup
+		if comment.InReplyTo != nil {
+			continue
+		}
+		existingComments = append(existingComments, &existingComment{
+			filename:    comment.Path,
+			comment:     comment.Body,
+			commentId:   comment.ID,
+			nodeId:      comment.NodeID,
+			fingerprint: extractFingerprint(comment.GetBody()),
+		})
+	}
+	return existingComments, nil
+}
+
+func (c *connector) getSummaryComment() (*github.IssueComment, error) {
+
+	ctx := context.Background()
+	opts := &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: githubPageSize},
+	}
+
+	for {
+		comments, resp, err := c.comments.ListComments(ctx, c.owner, c.repo, c.prNumber, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range comments {
+			if strings.Contains(comment.GetBody(), summaryMarker) {
+				return comment, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return nil, nil
+}
+
+func (c *connector) writeReplyComment(commentId int64, body string) error {
+
+	ctx := context.Background()
+	return writeCommentWithRetries(c.owner, c.repo, c.prNumber, func() (*github.Response, error) {
+		_, resp, err := c.prs.CreateCommentInReplyTo(ctx, c.owner, c.repo, c.prNumber, body, commentId)
+		return resp, err
+	})
+}
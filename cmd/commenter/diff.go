@@ -0,0 +1,385 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var hunkHeaderRegex = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+type diffLineKind int
+
+const (
+	diffLineContext diffLineKind = iota
+	diffLineAdded
+	diffLineRemoved
+)
+
+// diffLine is a single line of a hunk. Removed lines have no new line number and added lines have no old
+// line number, in both cases the missing number is 0
+type diffLine struct {
+	kind    diffLineKind
+	oldLine int
+	newLine int
+}
+
+type diffHunk struct {
+	oldStart int
+	oldLines int
+	newStart int
+	newLines int
+	lines    []diffLine
+}
+
+// fileDiff is the unified diff of a single file, made up of every hunk in its patch
+type fileDiff struct {
+	hunks []*diffHunk
+}
+
+// parsePatch builds the diff model for a file from its unified diff patch, as returned by the GitHub API
+// or by git diff without the file headers
+func parsePatch(patch string) (*fileDiff, error) {
+
+	diff := &fileDiff{}
+	var (
+		hunk             *diffHunk
+		oldLine, newLine int
+	)
+
+	for _, line := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(line, "@@") {
+			var err error
+			if hunk, err = parseHunkHeader(line); err != nil {
+				return nil, err
+			}
+			diff.hunks = append(diff.hunks, hunk)
+			oldLine, newLine = hunk.oldStart, hunk.newStart
+			continue
+		}
+		if hunk == nil {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "+"):
+			hunk.lines = append(hunk.lines, diffLine{kind: diffLineAdded, newLine: newLine})
+			newLine++
+		case strings.HasPrefix(line, "-"):
+			hunk.lines = append(hunk.lines, diffLine{kind: diffLineRemoved, oldLine: oldLine})
+			oldLine++
+		case strings.HasPrefix(line, "\\"):
+			// "\ No newline at end of file" doesn't belong to either side
+		default:
+			if oldLine >= hunk.oldStart+hunk.oldLines && newLine >= hunk.newStart+hunk.newLines {
+				// trailing content after the hunk, such as the empty string after a final newline
+				continue
+			}
+			hunk.lines = append(hunk.lines, diffLine{kind: diffLineContext, oldLine: oldLine, newLine: newLine})
+			oldLine++
+			newLine++
+		}
+	}
+
+	if patch != "" && len(diff.hunks) == 0 {
+		return nil, fmt.Errorf("no hunks found in patch")
+	}
+	return diff, nil
+}
+
+func parseHunkHeader(header string) (*diffHunk, error) {
+
+	groups := hunkHeaderRegex.FindStringSubmatch(header)
+	if len(groups) < 5 {
+		return nil, fmt.Errorf("the hunk header [%s] could not be resolved", header)
+	}
+
+	count := func(value string) int {
+		// a missing count means the hunk covers a single line
+		if value == "" {
+			return 1
+		}
+		n, _ := strconv.Atoi(value)
+		return n
+	}
+	oldStart, _ := strconv.Atoi(groups[1])
+	newStart, _ := strconv.Atoi(groups[3])
+
+	return &diffHunk{
+		oldStart: oldStart,
+		oldLines: count(groups[2]),
+		newStart: newStart,
+		newLines: count(groups[4]),
+	}, nil
+}
+
+// parseUnifiedDiff splits the output of git diff, or any other multi-file unified diff, into the diff of each
+// file keyed by its new path. Deleted files are left out as there's nothing left to comment on
+func parseUnifiedDiff(content string) (map[string]*fileDiff, error) {
+
+	files := make(map[string]*fileDiff)
+	var (
+		filename         string
+		patch            []string
+		oldLeft, newLeft int
+	)
+
+	flush := func() error {
+		if filename != "" && len(patch) > 0 {
+			diff, err := parsePatch(strings.Join(patch, "\n"))
+			if err != nil {
+				return fmt.Errorf("%s: %w", filename, err)
+			}
+			files[filename] = diff
+		}
+		filename, patch = "", nil
+		return nil
+	}
+
+	lines := strings.Split(content, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		inHunk := oldLeft > 0 || newLeft > 0
+
+		switch {
+		case !inHunk && strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			filename = diffPath(lines[i+1][4:])
+			i++
+			continue
+		case !inHunk && strings.HasPrefix(line, "diff "):
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		case strings.HasPrefix(line, "@@"):
+			hunk, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			oldLeft, newLeft = hunk.oldLines, hunk.newLines
+		case !inHunk:
+			// file headers such as index and mode lines
+			continue
+		case strings.HasPrefix(line, "+"):
+			newLeft--
+		case strings.HasPrefix(line, "-"):
+			oldLeft--
+		case strings.HasPrefix(line, "\\"):
+		default:
+			oldLeft--
+			newLeft--
+		}
+		if filename != "" {
+			patch = append(patch, line)
+		}
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// diffContextLines is how many unchanged lines git diff shows around each change
+const diffContextLines = 3
+
+// diffBlock is a run of changed lines, for platforms that say where a file changed rather than returning a
+// patch. The lines between one block and the next are unchanged
+type diffBlock struct {
+	newStart int
+	oldLines int
+	newLines int
+}
+
+// diffFromBlocks builds the diff model from the changed blocks of a file in order, adding the context lines git
+// diff would so the same lines can be commented on as on other platforms
+func diffFromBlocks(blocks []diffBlock) *fileDiff {
+
+	diff := &fileDiff{}
+	var hunk *diffHunk
+	oldNext, newNext := 1, 1
+	context := func(from, to int) {
+		for line := from; line < to; line++ {
+			hunk.lines = append(hunk.lines, diffLine{kind: diffLineContext, oldLine: line + oldNext - newNext, newLine: line})
+		}
+	}
+
+	for _, block := range blocks {
+		gap := block.newStart - newNext
+		if hunk == nil || gap > 2*diffContextLines {
+			if hunk != nil {
+				context(newNext, newNext+diffContextLines)
+			}
+			hunk = &diffHunk{}
+			diff.hunks = append(diff.hunks, hunk)
+			from := block.newStart - diffContextLines
+			if from < newNext {
+				from = newNext
+			}
+			context(from, block.newStart)
+		} else {
+			context(newNext, block.newStart)
+		}
+
+		oldStart := oldNext + gap
+		for line := oldStart; line < oldStart+block.oldLines; line++ {
+			hunk.lines = append(hunk.lines, diffLine{kind: diffLineRemoved, oldLine: line})
+		}
+		for line := block.newStart; line < block.newStart+block.newLines; line++ {
+			hunk.lines = append(hunk.lines, diffLine{kind: diffLineAdded, newLine: line})
+		}
+		oldNext, newNext = oldStart+block.oldLines, block.newStart+block.newLines
+	}
+	if hunk != nil {
+		context(newNext, newNext+diffContextLines)
+	}
+
+	for _, hunk := range diff.hunks {
+		hunk.setRange()
+	}
+	return diff
+}
+
+// setRange sets the header of a hunk that was built from its lines rather than parsed
+func (h *diffHunk) setRange() {
+	for _, line := range h.lines {
+		if line.oldLine > 0 {
+			if h.oldLines == 0 {
+				h.oldStart = line.oldLine
+			}
+			h.oldLines++
+		}
+		if line.newLine > 0 {
+			if h.newLines == 0 {
+				h.newStart = line.newLine
+			}
+			h.newLines++
+		}
+	}
+}
+
+// diffPath removes the b/ prefix git adds and any timestamp diff -u adds to a file header path, returning
+// empty for /dev/null
+func diffPath(path string) string {
+	if i := strings.Index(path, "\t"); i >= 0 {
+		path = path[:i]
+	}
+	if path == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(path, "b/") {
+		return path[2:]
+	}
+	return strings.TrimPrefix(path, "./")
+}
+
+// oldFirst is the first old line number covered by the hunk. When the hunk has no old lines the header
+// start is the line before the insertion point
+func (h *diffHunk) oldFirst() int {
+	if h.oldLines == 0 {
+		return h.oldStart + 1
+	}
+	return h.oldStart
+}
+
+// newFirst is the first new line number covered by the hunk, see oldFirst
+func (h *diffHunk) newFirst() int {
+	if h.newLines == 0 {
+		return h.newStart + 1
+	}
+	return h.newStart
+}
+
+// hunkFor returns the hunk that the new line number appears in as an added or context line
+func (d *fileDiff) hunkFor(newLine int) *diffHunk {
+
+	for _, hunk := range d.hunks {
+		if newLine >= hunk.newStart && newLine < hunk.newStart+hunk.newLines {
+			return hunk
+		}
+	}
+	return nil
+}
+
+// containsLine reports whether the new line number is part of the diff, either added or as context
+func (d *fileDiff) containsLine(newLine int) bool {
+	return d.hunkFor(newLine) != nil
+}
+
+// containsRange reports whether both ends of the range are in the same hunk, which is required for a
+// multi-line review comment
+func (d *fileDiff) containsRange(startLine, endLine int) bool {
+	hunk := d.hunkFor(startLine)
+	return hunk != nil && hunk == d.hunkFor(endLine)
+}
+
+// isAdded reports whether the new line number was added in the diff
+func (d *fileDiff) isAdded(newLine int) bool {
+
+	hunk := d.hunkFor(newLine)
+	if hunk == nil {
+		return false
+	}
+	for _, line := range hunk.lines {
+		if line.newLine == newLine {
+			return line.kind == diffLineAdded
+		}
+	}
+	return false
+}
+
+// addedInRange reports whether any line in the new line range was added in the diff
+func (d *fileDiff) addedInRange(startLine, endLine int) bool {
+	for line := startLine; line <= endLine; line++ {
+		if d.isAdded(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// newToOld maps a line number in the new file to the old file. Lines added in the diff have no old line
+func (d *fileDiff) newToOld(newLine int) (int, bool) {
+
+	offset := 0
+	for _, hunk := range d.hunks {
+		if newLine < hunk.newFirst() {
+			break
+		}
+		if newLine < hunk.newFirst()+hunk.newLines {
+			for _, line := range hunk.lines {
+				if line.newLine == newLine {
+					return line.oldLine, line.kind == diffLineContext
+				}
+			}
+			return 0, false
+		}
+		offset = (hunk.oldFirst() + hunk.oldLines) - (hunk.newFirst() + hunk.newLines)
+	}
+	return newLine + offset, true
+}
+
+// oldToNew maps a line number in the old file to the new file. Lines removed in the diff have no new line
+func (d *fileDiff) oldToNew(oldLine int) (int, bool) {
+
+	offset := 0
+	for _, hunk := range d.hunks {
+		if oldLine < hunk.oldFirst() {
+			break
+		}
+		if oldLine < hunk.oldFirst()+hunk.oldLines {
+			for _, line := range hunk.lines {
+				if line.oldLine == oldLine {
+					return line.newLine, line.kind == diffLineContext
+				}
+			}
+			return 0, false
+		}
+		offset = (hunk.newFirst() + hunk.newLines) - (hunk.oldFirst() + hunk.oldLines)
+	}
+	return oldLine + offset, true
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+)
+
+// diffProvider loads the files changed in a PR along with their diffs, so deciding whether a result is part of
+// the change doesn't depend on where the diff came from
+type diffProvider interface {
+	changedFiles() (changedFiles, error)
+	// headSHA is the commit the diff was taken at, or empty if it isn't known
+	headSHA() string
+}
+
+// githubDiffProvider reads the patch of each file in the PR from the GitHub API
+type githubDiffProvider struct {
+	ghConnector *connector
+}
+
+// gitDiffProvider runs git diff base...head in the local repository, so it sees the same changes as the PR
+type gitDiffProvider struct {
+	base string
+	head string
+}
+
+// patchFileDiffProvider reads a multi-file unified diff from disk
+type patchFileDiffProvider struct {
+	path string
+}
+
+func (p githubDiffProvider) changedFiles() (changedFiles, error) {
+
+	prFiles, err := p.ghConnector.getFilesForPr()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		errs  []string
+		files changedFiles
+	)
+
+	for _, file := range prFiles {
+		info, err := getCommitInfo(file)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		files = append(files, info)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("there were errors processing the PR files.\n%s", strings.Join(errs, "\n"))
+	}
+	return files, nil
+}
+
+func (p githubDiffProvider) headSHA() string {
+	return p.ghConnector.headSHA
+}
+
+// newGitDiffProvider splits a range such as main...HEAD, where a missing head means HEAD
+func newGitDiffProvider(gitRange string) gitDiffProvider {
+	base, head := gitRange, ""
+	if i := strings.Index(gitRange, ".."); i >= 0 {
+		base, head = gitRange[:i], strings.TrimLeft(gitRange[i:], ".")
+	}
+	if head == "" {
+		head = "HEAD"
+	}
+	return gitDiffProvider{base: base, head: head}
+}
+
+func (p gitDiffProvider) changedFiles() (changedFiles, error) {
+	patch, err := exec.Command("git", "diff", "--no-color", "--no-ext-diff", fmt.Sprintf("%s...%s", p.base, p.head)).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff %s...%s: %w", p.base, p.head, err)
+	}
+	return changedFilesFromPatch(string(patch), p.headSHA())
+}
+
+func (p gitDiffProvider) headSHA() string {
+	return gitRevParse(p.head)
+}
+
+func (p patchFileDiffProvider) changedFiles() (changedFiles, error) {
+	patch, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	return changedFilesFromPatch(string(patch), p.headSHA())
+}
+
+// headSHA assumes the patch was made from the checked out commit, if there is a repository at all
+func (p patchFileDiffProvider) headSHA() string {
+	return gitRevParse("HEAD")
+}
+
+func changedFilesFromPatch(patch, sha string) (changedFiles, error) {
+	diffs, err := parseUnifiedDiff(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	var files changedFiles
+	for filename, diff := range diffs {
+		files = append(files, &commitFileInfo{
+			FileName: filename,
+			diff:     diff,
+			sha:      sha,
+		})
+	}
+	return files, nil
+}
+
+// gitRevParse resolves the ref to a commit sha, or empty if it can't
+func gitRevParse(ref string) string {
+	sha, err := exec.Command("git", "rev-parse", ref).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(sha))
+}
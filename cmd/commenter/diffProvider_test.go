@@ -0,0 +1,182 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v32/github"
+)
+
+func TestNewGitDiffProvider(t *testing.T) {
+	tests := []struct {
+		gitRange string
+		base     string
+		head     string
+	}{
+		{gitRange: "main", base: "main", head: "HEAD"},
+		{gitRange: "main..HEAD", base: "main", head: "HEAD"},
+		{gitRange: "main...HEAD", base: "main", head: "HEAD"},
+		{gitRange: "main...feature", base: "main", head: "feature"},
+		{gitRange: "main..feature", base: "main", head: "feature"},
+		{gitRange: "main...", base: "main", head: "HEAD"},
+		{gitRange: "origin/main..feature/vpc", base: "origin/main", head: "feature/vpc"},
+	}
+	for _, test := range tests {
+		provider := newGitDiffProvider(test.gitRange)
+		if provider.base != test.base || provider.head != test.head {
+			t.Errorf("newGitDiffProvider(%q) = %s, %s, want %s, %s", test.gitRange, provider.base, provider.head, test.base, test.head)
+		}
+	}
+}
+
+// TestGitDiffProvider checks a two dot range is still diffed from the merge base, so a change made on main after
+// the branch doesn't count as part of the PR, and that every hunk of a file is loaded
+func TestGitDiffProvider(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "diff-provider")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+		}
+	}
+	write := func(name string, lines ...string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var original []string
+	for i := 1; i <= 20; i++ {
+		original = append(original, "line")
+	}
+	git("init", "-q")
+	git("checkout", "-q", "-b", "main")
+	write("main.tf", original...)
+	write("other.tf", "a")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	git("checkout", "-q", "-b", "feature")
+	changed := append([]string{}, original...)
+	changed[1] = "changed2"
+	changed[17] = "changed18"
+	write("main.tf", changed...)
+	git("commit", "-q", "-am", "feature")
+
+	git("checkout", "-q", "main")
+	write("other.tf", "b")
+	git("commit", "-q", "-am", "main")
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	for _, gitRange := range []string{"main..feature", "main...feature"} {
+		provider := newGitDiffProvider(gitRange)
+		files, err := provider.changedFiles()
+		if err != nil {
+			t.Fatalf("%s: %v", gitRange, err)
+		}
+		if len(files) != 1 || files[0].FileName != "main.tf" {
+			var names []string
+			for _, file := range files {
+				names = append(names, file.FileName)
+			}
+			t.Fatalf("%s: changed files = %v, want [main.tf]", gitRange, names)
+		}
+		if !files.inChangedLines("main.tf", 2, 2) || !files.inChangedLines("main.tf", 18, 18) {
+			t.Errorf("%s: both hunks should be part of the diff", gitRange)
+		}
+		if files.inDiff("main.tf", 10, 10) {
+			t.Errorf("%s: line 10 is between the hunks", gitRange)
+		}
+		if sha := provider.headSHA(); len(sha) != 40 || files[0].sha != sha {
+			t.Errorf("%s: head sha = %q, file sha = %q", gitRange, sha, files[0].sha)
+		}
+	}
+}
+
+func TestPatchFileDiffProvider(t *testing.T) {
+	file, err := ioutil.TempFile("", "diff-*.patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(gitMultiFileDiff); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	files, err := patchFileDiffProvider{path: file.Name()}.changedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("got %d files, want 3", len(files))
+	}
+	if !files.inChangedLines("main.tf", 2, 2) || !files.inChangedLines("main.tf", 13, 13) {
+		t.Errorf("both hunks of main.tf should be loaded")
+	}
+	if files.find("deleted.tf") != nil {
+		t.Errorf("deleted files should be left out")
+	}
+}
+
+func TestGetCommitInfo(t *testing.T) {
+	info, err := getCommitInfo(&github.CommitFile{
+		Filename:    github.String("main.tf"),
+		Patch:       github.String(multiHunkPatch),
+		ContentsURL: github.String("https://api.github.com/repos/owner/repo/contents/main.tf?ref=abc123"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.sha != "abc123" || !info.isResolvable() {
+		t.Errorf("info = %+v", info)
+	}
+	files := changedFiles{info}
+	if !files.inDiff("main.tf", 11, 14) || files.inDiff("main.tf", 5, 7) {
+		t.Errorf("the diff should cover both hunks and not the lines between them")
+	}
+
+	binary, err := getCommitInfo(&github.CommitFile{
+		Filename:    github.String("logo.png"),
+		ContentsURL: github.String("https://api.github.com/repos/owner/repo/contents/logo.png?ref=abc123"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary.isResolvable() {
+		t.Errorf("a file without a patch should not be resolvable")
+	}
+
+	if _, err := getCommitInfo(&github.CommitFile{
+		Filename:    github.String("main.tf"),
+		Patch:       github.String("@@ bad @@"),
+		ContentsURL: github.String("https://api.github.com/repos/owner/repo/contents/main.tf?ref=abc123"),
+	}); err == nil {
+		t.Errorf("expected an error for an invalid patch")
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// multiHunkPatch adds a line near the top of the file and removes one further down, giving two hunks
+const multiHunkPatch = `@@ -1,4 +1,5 @@
+ line1
++added2
+ line2
+ line3
+ line4
+@@ -10,5 +11,4 @@ resource "aws_s3_bucket" "bucket" {
+ line10
+ line11
+-line12
+ line13
+ line14
+`
+
+func mustParsePatch(t *testing.T, patch string) *fileDiff {
+	t.Helper()
+	diff, err := parsePatch(patch)
+	if err != nil {
+		t.Fatalf("parsePatch: %v", err)
+	}
+	return diff
+}
+
+func TestParseHunkHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		hunk   diffHunk
+		err    bool
+	}{
+		{header: "@@ -1,4 +1,5 @@", hunk: diffHunk{oldStart: 1, oldLines: 4, newStart: 1, newLines: 5}},
+		{header: "@@ -10,5 +11,4 @@ resource \"a\" \"b\" {", hunk: diffHunk{oldStart: 10, oldLines: 5, newStart: 11, newLines: 4}},
+		{header: "@@ -1 +1 @@", hunk: diffHunk{oldStart: 1, oldLines: 1, newStart: 1, newLines: 1}},
+		{header: "@@ -0,0 +1,3 @@", hunk: diffHunk{oldStart: 0, oldLines: 0, newStart: 1, newLines: 3}},
+		{header: "@@ -3,2 +2,0 @@", hunk: diffHunk{oldStart: 3, oldLines: 2, newStart: 2, newLines: 0}},
+		{header: "@@ bad @@", err: true},
+		{header: "@@ -a,1 +1 @@", err: true},
+	}
+	for _, test := range tests {
+		hunk, err := parseHunkHeader(test.header)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseHunkHeader(%q) expected an error", test.header)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseHunkHeader(%q): %v", test.header, err)
+			continue
+		}
+		if !reflect.DeepEqual(*hunk, test.hunk) {
+			t.Errorf("parseHunkHeader(%q) = %+v, want %+v", test.header, *hunk, test.hunk)
+		}
+	}
+}
+
+func TestParsePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		lines [][]diffLine
+		err   bool
+	}{
+		{
+			name:  "multiple hunks",
+			patch: multiHunkPatch,
+			lines: [][]diffLine{
+				{
+					{kind: diffLineContext, oldLine: 1, newLine: 1},
+					{kind: diffLineAdded, newLine: 2},
+					{kind: diffLineContext, oldLine: 2, newLine: 3},
+					{kind: diffLineContext, oldLine: 3, newLine: 4},
+					{kind: diffLineContext, oldLine: 4, newLine: 5},
+				},
+				{
+					{kind: diffLineContext, oldLine: 10, newLine: 11},
+					{kind: diffLineContext, oldLine: 11, newLine: 12},
+					{kind: diffLineRemoved, oldLine: 12},
+					{kind: diffLineContext, oldLine: 13, newLine: 13},
+					{kind: diffLineContext, oldLine: 14, newLine: 14},
+				},
+			},
+		},
+		{
+			name:  "no newline at end of file",
+			patch: "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+			lines: [][]diffLine{{
+				{kind: diffLineContext, oldLine: 1, newLine: 1},
+				{kind: diffLineRemoved, oldLine: 2},
+				{kind: diffLineAdded, newLine: 2},
+			}},
+		},
+		{
+			name:  "new file",
+			patch: "@@ -0,0 +1,2 @@\n+a\n+b",
+			lines: [][]diffLine{{
+				{kind: diffLineAdded, newLine: 1},
+				{kind: diffLineAdded, newLine: 2},
+			}},
+		},
+		{
+			name:  "empty",
+			patch: "",
+		},
+		{
+			name:  "no hunks",
+			patch: "Binary files differ",
+			err:   true,
+		},
+	}
+	for _, test := range tests {
+		diff, err := parsePatch(test.patch)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		var lines [][]diffLine
+		for _, hunk := range diff.hunks {
+			lines = append(lines, hunk.lines)
+		}
+		if !reflect.DeepEqual(lines, test.lines) {
+			t.Errorf("%s: lines = %+v, want %+v", test.name, lines, test.lines)
+		}
+	}
+}
+
+func TestFileDiffLines(t *testing.T) {
+	diff := mustParsePatch(t, multiHunkPatch)
+
+	for line, added := range map[int]bool{1: false, 2: true, 3: false, 5: false, 8: false, 12: false, 13: false} {
+		if got := diff.isAdded(line); got != added {
+			t.Errorf("isAdded(%d) = %v, want %v", line, got, added)
+		}
+	}
+
+	for line, contained := range map[int]bool{0: false, 1: true, 5: true, 6: false, 10: false, 11: true, 14: true, 15: false} {
+		if got := diff.containsLine(line); got != contained {
+			t.Errorf("containsLine(%d) = %v, want %v", line, got, contained)
+		}
+	}
+
+	ranges := []struct {
+		start, end int
+		contained  bool
+	}{
+		{start: 1, end: 5, contained: true},
+		{start: 11, end: 14, contained: true},
+		{start: 2, end: 2, contained: true},
+		{start: 4, end: 11, contained: false},
+		{start: 5, end: 6, contained: false},
+		{start: 7, end: 8, contained: false},
+	}
+	for _, r := range ranges {
+		if got := diff.containsRange(r.start, r.end); got != r.contained {
+			t.Errorf("containsRange(%d, %d) = %v, want %v", r.start, r.end, got, r.contained)
+		}
+	}
+
+	if !diff.addedInRange(1, 3) || diff.addedInRange(11, 14) {
+		t.Errorf("addedInRange should only cover the added line")
+	}
+}
+
+func TestNewToOld(t *testing.T) {
+	tests := []struct {
+		diff  string
+		new   int
+		old   int
+		found bool
+	}{
+		{diff: multiHunkPatch, new: 1, old: 1, found: true},
+		{diff: multiHunkPatch, new: 2, old: 0, found: false},
+		{diff: multiHunkPatch, new: 3, old: 2, found: true},
+		{diff: multiHunkPatch, new: 5, old: 4, found: true},
+		// between the hunks the added line shifts everything down one
+		{diff: multiHunkPatch, new: 7, old: 6, found: true},
+		{diff: multiHunkPatch, new: 10, old: 9, found: true},
+		{diff: multiHunkPatch, new: 11, old: 10, found: true},
+		{diff: multiHunkPatch, new: 13, old: 13, found: true},
+		// after the removed line the offsets cancel out
+		{diff: multiHunkPatch, new: 20, old: 20, found: true},
+		{diff: "@@ -0,0 +1,2 @@\n+a\n+b", new: 1, old: 0, found: false},
+		{diff: "@@ -3,2 +2,0 @@\n-x\n-y", new: 2, old: 2, found: true},
+		{diff: "@@ -3,2 +2,0 @@\n-x\n-y", new: 3, old: 5, found: true},
+	}
+	for _, test := range tests {
+		old, found := mustParsePatch(t, test.diff).newToOld(test.new)
+		if old != test.old || found != test.found {
+			t.Errorf("newToOld(%d) = %d, %v, want %d, %v", test.new, old, found, test.old, test.found)
+		}
+	}
+}
+
+func TestOldToNew(t *testing.T) {
+	tests := []struct {
+		diff  string
+		old   int
+		new   int
+		found bool
+	}{
+		{diff: multiHunkPatch, old: 1, new: 1, found: true},
+		{diff: multiHunkPatch, old: 2, new: 3, found: true},
+		{diff: multiHunkPatch, old: 7, new: 8, found: true},
+		{diff: multiHunkPatch, old: 11, new: 12, found: true},
+		{diff: multiHunkPatch, old: 12, new: 0, found: false},
+		{diff: multiHunkPatch, old: 13, new: 13, found: true},
+		{diff: multiHunkPatch, old: 20, new: 20, found: true},
+		{diff: "@@ -3,2 +2,0 @@\n-x\n-y", old: 3, new: 0, found: false},
+		{diff: "@@ -3,2 +2,0 @@\n-x\n-y", old: 5, new: 3, found: true},
+		{diff: "@@ -0,0 +1,2 @@\n+a\n+b", old: 1, new: 3, found: true},
+	}
+	for _, test := range tests {
+		new, found := mustParsePatch(t, test.diff).oldToNew(test.old)
+		if new != test.new || found != test.found {
+			t.Errorf("oldToNew(%d) = %d, %v, want %d, %v", test.old, new, found, test.new, test.found)
+		}
+	}
+}
+
+// gitMultiFileDiff is git diff output with a modified file, a renamed file with changes, a deleted file, a new
+// file and a binary file. The modified file removes a line starting with "-- " and adds one starting with "++ ",
+// which must not be mistaken for file headers
+const gitMultiFileDiff = `diff --git a/main.tf b/main.tf
+index 1111111..2222222 100644
+--- a/main.tf
++++ b/main.tf
+@@ -1,4 +1,5 @@
+ line1
++added2
+ line2
+ line3
+ line4
+@@ -10,5 +11,5 @@
+ line10
+ line11
+--- removed comment
++++ added comment
+ line13
+ line14
+diff --git a/old/vpc.tf b/new/vpc.tf
+similarity index 90%
+rename from old/vpc.tf
+rename to new/vpc.tf
+index 3333333..4444444 100644
+--- a/old/vpc.tf
++++ b/new/vpc.tf
+@@ -2,3 +2,3 @@
+ a
+-b
++c
+ d
+diff --git a/moved.tf b/renamed.tf
+similarity index 100%
+rename from moved.tf
+rename to renamed.tf
+diff --git a/deleted.tf b/deleted.tf
+deleted file mode 100644
+index 5555555..0000000
+--- a/deleted.tf
++++ /dev/null
+@@ -1,2 +0,0 @@
+-x
+-y
+diff --git a/added.tf b/added.tf
+new file mode 100644
+index 0000000..6666666
+--- /dev/null
++++ b/added.tf
+@@ -0,0 +1,2 @@
++x
++y
+\ No newline at end of file
+diff --git a/logo.png b/logo.png
+index 7777777..8888888 100644
+Binary files a/logo.png and b/logo.png differ
+`
+
+func TestParseUnifiedDiff(t *testing.T) {
+	files, err := parseUnifiedDiff(gitMultiFileDiff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"added.tf", "main.tf", "new/vpc.tf"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("files = %v, want %v", names, want)
+	}
+
+	main := files["main.tf"]
+	if len(main.hunks) != 2 {
+		t.Fatalf("main.tf has %d hunks, want 2", len(main.hunks))
+	}
+	if !main.isAdded(2) || !main.isAdded(13) || main.isAdded(12) {
+		t.Errorf("main.tf added lines are wrong: %+v", main.hunks[1].lines)
+	}
+	if !main.containsLine(15) || main.containsLine(16) {
+		t.Errorf("main.tf second hunk should end at line 15")
+	}
+
+	renamed := files["new/vpc.tf"]
+	if !renamed.isAdded(3) || !renamed.containsRange(2, 4) {
+		t.Errorf("new/vpc.tf lines are wrong: %+v", renamed.hunks[0].lines)
+	}
+
+	added := files["added.tf"]
+	if !added.isAdded(1) || !added.isAdded(2) || added.containsLine(3) {
+		t.Errorf("added.tf lines are wrong: %+v", added.hunks[0].lines)
+	}
+}
+
+func TestParseUnifiedDiffWithoutGitHeaders(t *testing.T) {
+	content := "--- main.tf\t2021-01-01 00:00:00\n+++ ./main.tf\t2021-01-02 00:00:00\n@@ -1 +1,2 @@\n a\n+b\n"
+	files, err := parseUnifiedDiff(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, ok := files["main.tf"]
+	if !ok {
+		t.Fatalf("main.tf not found in %v", files)
+	}
+	if !diff.isAdded(2) {
+		t.Errorf("line 2 should be added")
+	}
+}
+
+func TestParseUnifiedDiffInvalidHunk(t *testing.T) {
+	if _, err := parseUnifiedDiff("--- a/main.tf\n+++ b/main.tf\n@@ bad @@\n"); err == nil {
+		t.Errorf("expected an error for an invalid hunk header")
+	}
+}
+
+func TestDiffFromBlocks(t *testing.T) {
+	diff := diffFromBlocks([]diffBlock{{newStart: 5, oldLines: 1, newLines: 2}})
+	if len(diff.hunks) != 1 {
+		t.Fatalf("got %d hunks, want 1", len(diff.hunks))
+	}
+	want := []diffLine{
+		{kind: diffLineContext, oldLine: 2, newLine: 2},
+		{kind: diffLineContext, oldLine: 3, newLine: 3},
+		{kind: diffLineContext, oldLine: 4, newLine: 4},
+		{kind: diffLineRemoved, oldLine: 5},
+		{kind: diffLineAdded, newLine: 5},
+		{kind: diffLineAdded, newLine: 6},
+		{kind: diffLineContext, oldLine: 6, newLine: 7},
+		{kind: diffLineContext, oldLine: 7, newLine: 8},
+		{kind: diffLineContext, oldLine: 8, newLine: 9},
+	}
+	hunk := diff.hunks[0]
+	if !reflect.DeepEqual(hunk.lines, want) {
+		t.Errorf("lines = %+v, want %+v", hunk.lines, want)
+	}
+	if hunk.oldStart != 2 || hunk.oldLines != 7 || hunk.newStart != 2 || hunk.newLines != 8 {
+		t.Errorf("range = -%d,%d +%d,%d, want -2,7 +2,8", hunk.oldStart, hunk.oldLines, hunk.newStart, hunk.newLines)
+	}
+	if old, found := diff.newToOld(20); old != 19 || !found {
+		t.Errorf("newToOld(20) = %d, %v, want 19, true", old, found)
+	}
+}
+
+func TestDiffFromBlocksSplitsDistantChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		blocks []diffBlock
+		hunks  int
+	}{
+		{name: "none", hunks: 0},
+		{name: "close together", blocks: []diffBlock{{newStart: 2, newLines: 1}, {newStart: 8, oldLines: 1, newLines: 1}}, hunks: 1},
+		{name: "far apart", blocks: []diffBlock{{newStart: 2, newLines: 1}, {newStart: 20, oldLines: 1, newLines: 1}}, hunks: 2},
+	}
+	for _, test := range tests {
+		diff := diffFromBlocks(test.blocks)
+		if len(diff.hunks) != test.hunks {
+			t.Errorf("%s: got %d hunks, want %d", test.name, len(diff.hunks), test.hunks)
+		}
+	}
+
+	diff := diffFromBlocks([]diffBlock{{newStart: 2, newLines: 1}, {newStart: 20, oldLines: 1, newLines: 1}})
+	if diff.containsRange(2, 20) {
+		t.Errorf("a range across hunks should not be contained")
+	}
+	if !diff.isAdded(2) || !diff.isAdded(20) || diff.isAdded(3) {
+		t.Errorf("added lines are wrong")
+	}
+	if old, found := diff.newToOld(10); old != 9 || !found {
+		t.Errorf("newToOld(10) = %d, %v, want 9, true", old, found)
+	}
+	if new, found := diff.oldToNew(19); new != 0 || found {
+		t.Errorf("oldToNew(19) = %d, %v, want the removed line", new, found)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// dryRunComment is a review comment the commenter would have written
+type dryRunComment struct {
+	Filename  string `json:"filename"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Body      string `json:"body"`
+}
+
+// dryRunCommenter stands in for the GitHub Commenter, recording the comments against a local diff instead of
+// writing them to a PR. Comments are queued and deduplicated the same way as on the other platforms
+type dryRunCommenter struct {
+	reviewState
+	comments []dryRunComment
+}
+
+// runDryRun processes the results against a local diff and writes what would have been written to the PR to out.
+// The log goes to stderr so the report can be piped
+func runDryRun(diffFile, gitRange, output string, out io.Writer) {
+	logOutput = os.Stderr
+
+	if output != "markdown" && output != "json" {
+		fail(fmt.Sprintf("unexpected value for --output. Expected markdown or json, found %s", output))
+	}
+	if (diffFile == "") == (gitRange == "") {
+		fail("--dry-run needs exactly one of --diff-file or --git-range")
+	}
+
+	config, err := extractRepositoryConfig()
+	if err != nil {
+		fail(err.Error())
+	}
+
+	settings, err := extractRunSettings(config)
+	if err != nil {
+		fail(err.Error())
+	}
+
+	policy, err := extractFailurePolicy()
+	if err != nil {
+		fail(err.Error())
+	}
+
+	results, err := loadResultsFiles(splitList(os.Getenv("INPUT_RESULTS_FILE")), os.Getenv("INPUT_RESULTS_FORMAT"))
+	if err != nil {
+		fail(fmt.Sprintf("failed to load results. %s", err.Error()))
+	}
+
+	c, err := newDryRunCommenter(diffFile, gitRange)
+	if err != nil {
+		fail(fmt.Sprintf("failed to load the diff. %s", err.Error()))
+	}
+
+	settings.renderer, err = newCommentRenderer(os.Getenv("INPUT_COMMENT_TEMPLATE"), extractCommentContext(0, c.HeadSHA()))
+	if err != nil {
+		fail(err.Error())
+	}
+
+	settings.policy = policy
+	report := processResults(c, results, settings)
+	decision := policy.decide(report.findings, report.previousSummary, len(report.errMessages))
+
+	if output == "json" {
+		err = writeDryRunJSON(out, c.comments, report, decision)
+	} else {
+		err = writeDryRunMarkdown(out, c.comments, report, decision)
+	}
+	if err != nil {
+		fail(err.Error())
+	}
+	exit(decision)
+}
+
+// newDryRunCommenter loads the diff from a unified diff file or by running git diff over the range
+func newDryRunCommenter(diffFile, gitRange string) (*dryRunCommenter, error) {
+	var provider diffProvider = patchFileDiffProvider{path: diffFile}
+	if gitRange != "" {
+		provider = newGitDiffProvider(gitRange)
+	}
+
+	files, err := provider.changedFiles()
+	if err != nil {
+		return nil, err
+	}
+	logf("Loaded the diff of %d files\n", len(files))
+
+	return &dryRunCommenter{
+		reviewState: reviewState{
+			headSHA: provider.headSHA(),
+			files:   files,
+		},
+	}, nil
+}
+
+// WriteMultiLineComment queues the comment with the same rules as the GitHub Commenter, falling back to the last
+// line when the range isn't within a single hunk
+func (c *dryRunCommenter) WriteMultiLineComment(file, comment string, startLine, endLine int) error {
+	_, err := c.queueComment(file, comment, startLine, endLine)
+	return err
+}
+
+// SubmitReview records the queued comments as written
+func (c *dryRunCommenter) SubmitReview(string) error {
+	for _, pending := range c.pending {
+		c.comments = append(c.comments, dryRunComment{
+			Filename:  pending.filename,
+			StartLine: pending.startLine,
+			EndLine:   pending.endLine,
+			Body:      pending.comment,
+		})
+	}
+	c.pending = nil
+	return nil
+}
+
+// CleanupStaleComments has nothing to do as there are no existing comments in a dry run
+func (c *dryRunCommenter) CleanupStaleComments([]findingComment, string, string, bool) (int, error) {
+	return 0, nil
+}
+
+func (c *dryRunCommenter) PreviousSummary() (string, error) {
+	return "", nil
+}
+
+func (c *dryRunCommenter) WriteSummaryComment(string) error {
+	return nil
+}
+
+func writeDryRunJSON(w io.Writer, comments []dryRunComment, report runReport, decision policyDecision) error {
+	if comments == nil {
+		comments = []dryRunComment{}
+	}
+	skipped := report.skipped
+	if skipped == nil {
+		skipped = []skippedResult{}
+	}
+	errMessages := report.errMessages
+	if errMessages == nil {
+		errMessages = []string{}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Comments []dryRunComment `json:"comments"`
+		Skipped  []skippedResult `json:"skipped"`
+		Errors   []string        `json:"errors"`
+		Summary  string          `json:"summary,omitempty"`
+		Result   string          `json:"result"`
+		ExitCode int             `json:"exit_code"`
+	}{
+		Comments: comments,
+		Skipped:  skipped,
+		Errors:   errMessages,
+		Summary:  report.summary,
+		Result:   decision.result,
+		ExitCode: decision.exitCode,
+	})
+}
+
+func writeDryRunMarkdown(w io.Writer, comments []dryRunComment, report runReport, decision policyDecision) error {
+	var sb strings.Builder
+	sb.WriteString("# Dry run\n\n")
+
+	sb.WriteString(fmt.Sprintf("## Comments (%d)\n\n", len(comments)))
+	for _, comment := range comments {
+		location := fmt.Sprintf("%s:%d", comment.Filename, comment.EndLine)
+		if comment.StartLine != comment.EndLine {
+			location = fmt.Sprintf("%s:%d-%d", comment.Filename, comment.StartLine, comment.EndLine)
+		}
+		sb.WriteString(fmt.Sprintf("### `%s`\n\n%s\n\n", location, strings.TrimSpace(removeFingerprint(comment.Body))))
+	}
+
+	sb.WriteString(fmt.Sprintf("## Skipped (%d)\n\n", len(report.skipped)))
+	for _, skipped := range report.skipped {
+		sb.WriteString(fmt.Sprintf("- `%s` in `%s:%d-%d` - %s\n", skipped.RuleID, skipped.Filename, skipped.StartLine, skipped.EndLine, skipped.Reason))
+	}
+	if len(report.skipped) > 0 {
+		sb.WriteString("\n")
+	}
+
+	if len(report.errMessages) > 0 {
+		sb.WriteString(fmt.Sprintf("## Errors (%d)\n\n", len(report.errMessages)))
+		for _, err := range report.errMessages {
+			sb.WriteString(fmt.Sprintf("- %s\n", err))
+		}
+		sb.WriteString("\n")
+	}
+
+	if report.summary != "" {
+		sb.WriteString("## Summary\n\n")
+		sb.WriteString(report.summary)
+		sb.WriteString("\n\n")
+	}
+
+	sb.WriteString(fmt.Sprintf("**%s**\n", decision.reason))
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -1,4 +1,4 @@
-package commenter
+package main
 
 import "fmt"
 
@@ -21,6 +21,14 @@ type PrDoesNotExistError struct {
 	prNumber int
 }
 
+// PermissionDeniedError returned when the token isn't allowed to write to the PR, such as the read-only token
+// given to workflows for PRs from forks
+type PermissionDeniedError struct {
+	owner    string
+	repo     string
+	prNumber int
+}
+
 // AbuseRateLimitError return when the GitHub abuse rate limit is hit
 type AbuseRateLimitError struct {
 	owner            string
@@ -75,3 +83,15 @@ func newAbuseRateLimitError(owner, repo string, prNumber, backoffInSeconds int)
 func (e AbuseRateLimitError) Error() string {
 	return fmt.Sprintf("Abuse limit reached on PR [%d] not found for %s/%s", e.prNumber, e.owner, e.repo)
 }
+
+func newPermissionDeniedError(owner, repo string, prNumber int) PermissionDeniedError {
+	return PermissionDeniedError{
+		owner:    owner,
+		repo:     repo,
+		prNumber: prNumber,
+	}
+}
+
+func (e PermissionDeniedError) Error() string {
+	return fmt.Sprintf("The token can't write to PR [%d] for %s/%s", e.prNumber, e.owner, e.repo)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v32/github"
+	"golang.org/x/oauth2"
+)
+
+// defaultEventPath is where the event payload is mounted when running as a Docker action
+const defaultEventPath = "/github/workflow/event.json"
+
+var (
+	errNotPullRequest  = errors.New("not a valid PR")
+	errEventNotFound   = errors.New("GitHub event payload not found")
+	mergeGroupRefRegex = regexp.MustCompile(`/pr-(\d+)-[0-9a-f]+$`)
+)
+
+type githubEvent struct {
+	Number      int               `json:"number"`
+	PullRequest *pullRequestEvent `json:"pull_request"`
+	Issue       *issueEvent       `json:"issue"`
+	WorkflowRun *workflowRunEvent `json:"workflow_run"`
+	MergeGroup  *mergeGroupEvent  `json:"merge_group"`
+}
+
+type pullRequestEvent struct {
+	Number int            `json:"number"`
+	Head   pullRequestRef `json:"head"`
+	Base   pullRequestRef `json:"base"`
+}
+
+type pullRequestRef struct {
+	Repo struct {
+		FullName string `json:"full_name"`
+	} `json:"repo"`
+}
+
+type issueEvent struct {
+	Number int `json:"number"`
+	// PullRequest is only present when the issue is a pull request
+	PullRequest *json.RawMessage `json:"pull_request"`
+}
+
+type workflowRunEvent struct {
+	PullRequests   []pullRequestEvent `json:"pull_requests"`
+	HeadSHA        string             `json:"head_sha"`
+	HeadBranch     string             `json:"head_branch"`
+	HeadRepository *struct {
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"head_repository"`
+}
+
+type mergeGroupEvent struct {
+	HeadRef string `json:"head_ref"`
+}
+
+// extractPullRequestNumber works out the PR being commented on, either from the INPUT_PR_NUMBER override or
+// from the payload of the event that triggered the workflow
+func extractPullRequestNumber(tokenSource oauth2.TokenSource, owner, repo string) (int, error) {
+	if override := os.Getenv("INPUT_PR_NUMBER"); override != "" {
+		prNumber, err := strconv.Atoi(override)
+		if err != nil || prNumber <= 0 {
+			fail(fmt.Sprintf("unexpected value for INPUT_PR_NUMBER. Expected a PR number, found %s", override))
+		}
+		return prNumber, nil
+	}
+
+	event, err := readEvent()
+	if errors.Is(err, errEventNotFound) {
+		fail(err.Error())
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	eventName := os.Getenv("GITHUB_EVENT_NAME")
+	logf("Reading PR number from %s event\n", eventName)
+	switch eventName {
+	case "workflow_run":
+		client, err := createGithubClient(tokenSource)
+		if err != nil {
+			return -1, err
+		}
+		return event.workflowRunPullRequestNumber(client, owner, repo)
+	default:
+		return event.pullRequestNumber(eventName)
+	}
+}
+
+func readEvent() (githubEvent, error) {
+	var event githubEvent
+
+	eventPath := os.Getenv("GITHUB_EVENT_PATH")
+	if eventPath == "" {
+		eventPath = defaultEventPath
+	}
+	file, err := ioutil.ReadFile(eventPath)
+	if err != nil {
+		return event, fmt.Errorf("%w in %s", errEventNotFound, eventPath)
+	}
+
+	err = json.Unmarshal(file, &event)
+	return event, err
+}
+
+// isForkPullRequest reports whether the workflow was triggered by a pull_request event from a fork, where the
+// token is read-only
+func isForkPullRequest() bool {
+	if os.Getenv("GITHUB_EVENT_NAME") != "pull_request" {
+		return false
+	}
+	event, err := readEvent()
+	if err != nil || event.PullRequest == nil {
+		return false
+	}
+	head, base := event.PullRequest.Head.Repo.FullName, event.PullRequest.Base.Repo.FullName
+	return head != "" && base != "" && !strings.EqualFold(head, base)
+}
+
+func (e githubEvent) pullRequestNumber(eventName string) (int, error) {
+	switch eventName {
+	case "pull_request", "pull_request_target", "pull_request_review", "pull_request_review_comment":
+		if e.PullRequest != nil {
+			return e.PullRequest.Number, nil
+		}
+	case "issue_comment":
+		if e.Issue != nil && e.Issue.PullRequest != nil {
+			return e.Issue.Number, nil
+		}
+	case "merge_group":
+		if e.MergeGroup != nil {
+			return mergeGroupPullRequestNumber(e.MergeGroup.HeadRef)
+		}
+	case "":
+		// no event name when run outside of Actions, so fall back to the number in the payload
+		if e.Number > 0 {
+			return e.Number, nil
+		}
+	}
+	return 0, errNotPullRequest
+}
+
+// mergeGroupPullRequestNumber reads the PR number from a merge queue ref such as
+// refs/heads/gh-readonly-queue/main/pr-123-5b6f3d5c
+func mergeGroupPullRequestNumber(headRef string) (int, error) {
+	groups := mergeGroupRefRegex.FindStringSubmatch(headRef)
+	if len(groups) < 2 {
+		return 0, errNotPullRequest
+	}
+	return strconv.Atoi(groups[1])
+}
+
+// workflowRunPullRequestNumber finds the PR that triggered the workflow run. The payload only lists PRs from the
+// same repository, so for fork builds the PR is looked up from the head branch and commit instead
+func (e githubEvent) workflowRunPullRequestNumber(client *github.Client, owner, repo string) (int, error) {
+	run := e.WorkflowRun
+	if run == nil {
+		return 0, errNotPullRequest
+	}
+	if len(run.PullRequests) > 0 {
+		return run.PullRequests[0].Number, nil
+	}
+	if run.HeadRepository == nil || run.HeadBranch == "" {
+		return 0, errNotPullRequest
+	}
+
+	head := fmt.Sprintf("%s:%s", run.HeadRepository.Owner.Login, run.HeadBranch)
+	prs, _, err := client.PullRequests.List(context.Background(), owner, repo, &github.PullRequestListOptions{
+		State: "open",
+		Head:  head,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("look up PR for %s: %w", head, err)
+	}
+	for _, pr := range prs {
+		if strings.EqualFold(pr.GetHead().GetSHA(), run.HeadSHA) {
+			return pr.GetNumber(), nil
+		}
+	}
+	return 0, errNotPullRequest
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// useTestEvent points the event environment at a payload in testdata/events and returns a func restoring it
+func useTestEvent(eventName, payload string) func() {
+	_ = os.Setenv("GITHUB_EVENT_NAME", eventName)
+	_ = os.Setenv("GITHUB_EVENT_PATH", filepath.Join("testdata", "events", payload))
+	return func() {
+		_ = os.Unsetenv("GITHUB_EVENT_NAME")
+		_ = os.Unsetenv("GITHUB_EVENT_PATH")
+	}
+}
+
+func TestPullRequestNumber(t *testing.T) {
+	tests := []struct {
+		eventName string
+		payload   string
+		want      int
+		err       error
+	}{
+		{eventName: "pull_request", payload: "pull_request.json", want: 5},
+		{eventName: "pull_request_target", payload: "pull_request_fork.json", want: 6},
+		{eventName: "pull_request_review", payload: "pull_request.json", want: 5},
+		{eventName: "pull_request_review_comment", payload: "pull_request.json", want: 5},
+		{eventName: "issue_comment", payload: "issue_comment.json", want: 5},
+		{eventName: "issue_comment", payload: "issue_comment_issue.json", err: errNotPullRequest},
+		{eventName: "merge_group", payload: "merge_group.json", want: 123},
+		{eventName: "merge_group", payload: "pull_request.json", err: errNotPullRequest},
+		{eventName: "push", payload: "pull_request.json", err: errNotPullRequest},
+		// run outside of Actions the number at the top of the payload is used
+		{eventName: "", payload: "pull_request.json", want: 5},
+		{eventName: "", payload: "merge_group.json", err: errNotPullRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.eventName+" "+tt.payload, func(t *testing.T) {
+			defer useTestEvent(tt.eventName, tt.payload)()
+			event, err := readEvent()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := event.pullRequestNumber(tt.eventName)
+			if got != tt.want || err != tt.err {
+				t.Errorf("pullRequestNumber = %d, %v, want %d, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestReadEventNotFound(t *testing.T) {
+	defer useTestEvent("pull_request", "missing.json")()
+	if _, err := readEvent(); !errors.Is(err, errEventNotFound) {
+		t.Errorf("err = %v, want errEventNotFound", err)
+	}
+}
+
+func TestMergeGroupPullRequestNumber(t *testing.T) {
+	tests := []struct {
+		headRef string
+		want    int
+		err     error
+	}{
+		{headRef: "refs/heads/gh-readonly-queue/main/pr-123-5b6f3d5c", want: 123},
+		{headRef: "refs/heads/gh-readonly-queue/release/v1.2/pr-7-0123456789abcdef0123456789abcdef01234567", want: 7},
+		// only the last part of the ref is read, whatever the base branch is called
+		{headRef: "refs/heads/gh-readonly-queue/pr-9-fix/pr-42-abc123", want: 42},
+		{headRef: "refs/heads/gh-readonly-queue/main/pr-123", err: errNotPullRequest},
+		{headRef: "refs/heads/gh-readonly-queue/main/pr-123-XYZ", err: errNotPullRequest},
+		{headRef: "refs/heads/gh-readonly-queue/main/pr--5b6f3d5c", err: errNotPullRequest},
+		{headRef: "refs/heads/main", err: errNotPullRequest},
+		{headRef: "", err: errNotPullRequest},
+	}
+	for _, tt := range tests {
+		got, err := mergeGroupPullRequestNumber(tt.headRef)
+		if got != tt.want || err != tt.err {
+			t.Errorf("mergeGroupPullRequestNumber(%q) = %d, %v, want %d, %v", tt.headRef, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestIsForkPullRequest(t *testing.T) {
+	tests := []struct {
+		eventName string
+		payload   string
+		want      bool
+	}{
+		{eventName: "pull_request", payload: "pull_request.json", want: false},
+		{eventName: "pull_request", payload: "pull_request_fork.json", want: true},
+		// pull_request_target runs with a token that can write, even for forks
+		{eventName: "pull_request_target", payload: "pull_request_fork.json", want: false},
+		{eventName: "pull_request", payload: "issue_comment.json", want: false},
+		{eventName: "pull_request", payload: "missing.json", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.eventName+" "+tt.payload, func(t *testing.T) {
+			defer useTestEvent(tt.eventName, tt.payload)()
+			if got := isForkPullRequest(); got != tt.want {
+				t.Errorf("isForkPullRequest = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func newEventTestClient(api *fakeApi) *github.Client {
+	client := github.NewClient(http.DefaultClient)
+	client.BaseURL, _ = url.Parse(api.url() + "/")
+	return client
+}
+
+func readTestEvent(t *testing.T, payload string) githubEvent {
+	t.Helper()
+	defer useTestEvent("workflow_run", payload)()
+	event, err := readEvent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestWorkflowRunPullRequestNumber(t *testing.T) {
+	api := newFakeApi(t)
+	defer api.close()
+
+	// the PR is listed in the payload, so there's nothing to look up and the fake API fails any request
+	got, err := readTestEvent(t, "workflow_run.json").workflowRunPullRequestNumber(newEventTestClient(api), "team", "infra")
+	if got != 5 || err != nil {
+		t.Errorf("workflowRunPullRequestNumber = %d, %v, want 5", got, err)
+	}
+
+	if _, err := (githubEvent{}).workflowRunPullRequestNumber(newEventTestClient(api), "team", "infra"); err != errNotPullRequest {
+		t.Errorf("err = %v, want errNotPullRequest without a workflow run", err)
+	}
+}
+
+func TestWorkflowRunPullRequestNumberFromFork(t *testing.T) {
+	const lookup = "/repos/team/infra/pulls?head=contributor%3Amain&state=open"
+	event := readTestEvent(t, "workflow_run_fork.json")
+
+	tests := []struct {
+		name    string
+		headSHA string
+		want    int
+		err     error
+	}{
+		// the fork's branch has two open PRs, the one for the commit that was built is picked
+		{name: "commit of a PR", headSHA: event.WorkflowRun.HeadSHA, want: 6},
+		{name: "commit of no PR", headSHA: "a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1", err: errNotPullRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeApi(t)
+			defer api.close()
+			api.replyFixture(http.MethodGet, lookup, "events/pulls_fork.json")
+
+			run := *event.WorkflowRun
+			run.HeadSHA = tt.headSHA
+			got, err := githubEvent{WorkflowRun: &run}.workflowRunPullRequestNumber(newEventTestClient(api), "team", "infra")
+			if got != tt.want || err != tt.err {
+				t.Errorf("workflowRunPullRequestNumber = %d, %v, want %d, %v", got, err, tt.want, tt.err)
+			}
+			api.sentOnce(http.MethodGet, lookup)
+		})
+	}
+
+	api := newFakeApi(t)
+	defer api.close()
+	api.handle(http.MethodGet, lookup, fakeResponse{status: http.StatusForbidden, body: `{"message":"Resource not accessible by integration"}`})
+	if _, err := event.workflowRunPullRequestNumber(newEventTestClient(api), "team", "infra"); err == nil || err == errNotPullRequest {
+		t.Errorf("err = %v, want the failed lookup", err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRequest is a request the fake API received
+type fakeRequest struct {
+	method string
+	// uri is the path and query
+	uri    string
+	header http.Header
+	body   string
+}
+
+// decode unmarshals the JSON body of the request
+func (r fakeRequest) decode(t *testing.T, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(r.body), v); err != nil {
+		t.Fatalf("decode %s %s: %v\n%s", r.method, r.uri, err, r.body)
+	}
+}
+
+type fakeResponse struct {
+	status int
+	header http.Header
+	body   string
+}
+
+// fakeApi is an httptest server for the REST platforms. Each request is answered with the response set up for its
+// method and uri, usually a recorded fixture from testdata, and is kept so the test can check what was sent.
+// Anything else fails the test
+type fakeApi struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu        sync.Mutex
+	responses map[string]func(request fakeRequest) fakeResponse
+	requests  []fakeRequest
+}
+
+func newFakeApi(t *testing.T) *fakeApi {
+	a := &fakeApi{t: t, responses: make(map[string]func(request fakeRequest) fakeResponse)}
+	a.server = httptest.NewServer(http.HandlerFunc(a.serve))
+	return a
+}
+
+func (a *fakeApi) close() {
+	a.server.Close()
+}
+
+func (a *fakeApi) url() string {
+	return a.server.URL
+}
+
+func (a *fakeApi) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	request := fakeRequest{method: r.Method, uri: r.URL.RequestURI(), header: r.Header, body: string(body)}
+	a.mu.Lock()
+	a.requests = append(a.requests, request)
+	respond, ok := a.responses[r.Method+" "+r.URL.RequestURI()]
+	a.mu.Unlock()
+
+	if !ok {
+		a.t.Errorf("unexpected request %s %s", r.Method, r.URL.RequestURI())
+		http.NotFound(w, r)
+		return
+	}
+	response := respond(request)
+	if response.status == 0 {
+		response.status = http.StatusOK
+	}
+	for key, values := range response.header {
+		w.Header()[key] = values
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.status)
+	_, _ = w.Write([]byte(response.body))
+}
+
+// handle answers requests for the method and uri with the response
+func (a *fakeApi) handle(method, uri string, response fakeResponse) {
+	a.handleFunc(method, uri, func(fakeRequest) fakeResponse {
+		return response
+	})
+}
+
+// handleFunc answers requests for the method and uri with the response for each request, for an API that answers
+// the same uri differently depending on what was sent
+func (a *fakeApi) handleFunc(method, uri string, respond func(request fakeRequest) fakeResponse) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.responses[method+" "+uri] = respond
+}
+
+// reply answers with the body and a 200
+func (a *fakeApi) reply(method, uri, body string) {
+	a.handle(method, uri, fakeResponse{body: body})
+}
+
+// replyFixture answers with the content of a file in testdata and a 200. Links to the next page in fixtures use
+// {{server}} for the url of the fake API
+func (a *fakeApi) replyFixture(method, uri, fixture string) {
+	a.handle(method, uri, fakeResponse{body: strings.ReplaceAll(readFixture(a.t, fixture), "{{server}}", a.url())})
+}
+
+// sent returns the requests received for the method and uri, in order
+func (a *fakeApi) sent(method, uri string) []fakeRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var requests []fakeRequest
+	for _, request := range a.requests {
+		if request.method == method && request.uri == uri {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// sentOnce returns the only request received for the method and uri, failing the test if there wasn't exactly one
+func (a *fakeApi) sentOnce(method, uri string) fakeRequest {
+	a.t.Helper()
+	requests := a.sent(method, uri)
+	if len(requests) != 1 {
+		a.t.Fatalf("got %d requests for %s %s, want 1", len(requests), method, uri)
+	}
+	return requests[0]
+}
+
+// writes returns every request that wasn't a GET, in order
+func (a *fakeApi) writes() []fakeRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var requests []fakeRequest
+	for _, request := range a.requests {
+		if request.method != http.MethodGet {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	content, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	return string(content)
+}
+
+// testComment is a comment the commenter would have written for the finding with the fingerprint
+func testComment(text, fingerprint string) string {
+	return appendFingerprint(":warning: tfsec found a **HIGH** severity issue from rule `"+text+"`", fingerprint)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+type filterOutcome int
+
+const (
+	// filterComment results are written as inline comments and appear in the summary
+	filterComment filterOutcome = iota
+	// filterSummary results only appear in the summary
+	filterSummary
+	// filterDrop results are ignored entirely
+	filterDrop
+)
+
+var severityRanks = map[string]int{
+	"LOW":      1,
+	"MEDIUM":   2,
+	"HIGH":     3,
+	"CRITICAL": 4,
+}
+
+// unknownSeverity is the level given to results with a severity other than LOW, MEDIUM, HIGH or CRITICAL, such as
+// trivy's UNKNOWN, so they are filtered like any other result rather than ranking below LOW
+const unknownSeverity = "MEDIUM"
+
+type resultFilter struct {
+	minimumSeverity int
+	commentSeverity int
+	includeRules    map[string]bool
+	excludeRules    map[string]bool
+	includePaths    []*regexp.Regexp
+	excludePaths    []*regexp.Regexp
+	overrides       []filterOverride
+}
+
+// filterOverride changes the severities and rules for the files matching its path, a negative severity is unset
+type filterOverride struct {
+	path            *regexp.Regexp
+	minimumSeverity int
+	commentSeverity int
+	includeRules    map[string]bool
+	excludeRules    map[string]bool
+}
+
+// extractResultFilter builds the filter from the INPUT_MINIMUM_SEVERITY, INPUT_COMMENT_SEVERITY,
+// INPUT_INCLUDE_RULES, INPUT_EXCLUDE_RULES, INPUT_INCLUDE_PATHS and INPUT_EXCLUDE_PATHS inputs, along with the
+// per-directory overrides from the config file
+func extractResultFilter(overrides []directoryOverride) (*resultFilter, error) {
+	minimumSeverity, err := parseSeverityInput("INPUT_MINIMUM_SEVERITY")
+	if err != nil {
+		return nil, err
+	}
+	commentSeverity, err := parseSeverityInput("INPUT_COMMENT_SEVERITY")
+	if err != nil {
+		return nil, err
+	}
+	includePaths, err := compileGlobs(splitList(os.Getenv("INPUT_INCLUDE_PATHS")))
+	if err != nil {
+		return nil, err
+	}
+	excludePaths, err := compileGlobs(splitList(os.Getenv("INPUT_EXCLUDE_PATHS")))
+	if err != nil {
+		return nil, err
+	}
+
+	var filterOverrides []filterOverride
+	for _, override := range overrides {
+		path, err := compileGlob(override.Path.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid override path %s: %w", override.Path.value, err)
+		}
+		filterOverrides = append(filterOverrides, filterOverride{
+			path:            path,
+			minimumSeverity: overrideSeverity(override.MinimumSeverity.value),
+			commentSeverity: overrideSeverity(override.CommentSeverity.value),
+			includeRules:    toSet(override.IncludeRules.values),
+			excludeRules:    toSet(override.ExcludeRules.values),
+		})
+	}
+
+	return &resultFilter{
+		minimumSeverity: minimumSeverity,
+		commentSeverity: commentSeverity,
+		includeRules:    toSet(splitList(os.Getenv("INPUT_INCLUDE_RULES"))),
+		excludeRules:    toSet(splitList(os.Getenv("INPUT_EXCLUDE_RULES"))),
+		includePaths:    includePaths,
+		excludePaths:    excludePaths,
+		overrides:       filterOverrides,
+	}, nil
+}
+
+func overrideSeverity(value string) int {
+	if value == "" {
+		return -1
+	}
+	return severityRank(value)
+}
+
+// decide works out what should happen to the result. The filename should already be relative to the repository root
+func (f *resultFilter) decide(result result) filterOutcome {
+	minimumSeverity, commentSeverity, includeRules := f.minimumSeverity, f.commentSeverity, f.includeRules
+	excluded := f.excludeRules[result.RuleID]
+
+	// overrides are applied in order so a later, more specific directory wins
+	for _, override := range f.overrides {
+		if !override.path.MatchString(strings.TrimPrefix(result.Range.Filename, "./")) {
+			continue
+		}
+		if override.minimumSeverity >= 0 {
+			minimumSeverity = override.minimumSeverity
+		}
+		if override.commentSeverity >= 0 {
+			commentSeverity = override.commentSeverity
+		}
+		if len(override.includeRules) > 0 {
+			includeRules = override.includeRules
+		}
+		excluded = excluded || override.excludeRules[result.RuleID]
+	}
+
+	if len(includeRules) > 0 && !includeRules[result.RuleID] {
+		return filterDrop
+	}
+	if excluded {
+		return filterDrop
+	}
+	if len(f.includePaths) > 0 && !matchesAnyGlob(f.includePaths, result.Range.Filename) {
+		return filterDrop
+	}
+	if matchesAnyGlob(f.excludePaths, result.Range.Filename) {
+		return filterDrop
+	}
+
+	severity := severityRank(result.Severity)
+	if severity < minimumSeverity {
+		return filterDrop
+	}
+	if severity < commentSeverity {
+		return filterSummary
+	}
+	return filterComment
+}
+
+func parseSeverityInput(name string) (int, error) {
+	value := strings.ToUpper(strings.TrimSpace(os.Getenv(name)))
+	if value == "" {
+		return 0, nil
+	}
+	rank, ok := severityRanks[value]
+	if !ok {
+		return 0, fmt.Errorf("unexpected value for %s. Expected one of LOW, MEDIUM, HIGH or CRITICAL, found %s", name, value)
+	}
+	return rank, nil
+}
+
+// severityRank orders severities so they can be compared, unknown severities rank as unknownSeverity
+func severityRank(severity string) int {
+	if rank, ok := severityRanks[strings.ToUpper(severity)]; ok {
+		return rank
+	}
+	return severityRanks[unknownSeverity]
+}
+
+// normaliseSeverity upper cases the severity, replacing an unknown one with unknownSeverity. It reports whether the
+// severity was known
+func normaliseSeverity(severity string) (string, bool) {
+	severity = strings.ToUpper(strings.TrimSpace(severity))
+	if _, ok := severityRanks[severity]; !ok {
+		return unknownSeverity, false
+	}
+	return severity, true
+}
+
+// splitList splits a comma or newline separated input into its trimmed, non-empty values
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+func compileGlobs(patterns []string) ([]*regexp.Regexp, error) {
+	var globs []*regexp.Regexp
+	for _, pattern := range patterns {
+		glob, err := compileGlob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern %s: %w", pattern, err)
+		}
+		globs = append(globs, glob)
+	}
+	return globs, nil
+}
+
+// compileGlob turns a path glob into a regular expression. As well as the usual * and ? wildcards, ** matches
+// any number of directories. The pattern is read a character at a time so paths that aren't ASCII match too
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	pattern := []rune(strings.TrimPrefix(glob, "./"))
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					// **/ matches zero or more directories
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+func matchesAnyGlob(globs []*regexp.Regexp, path string) bool {
+	path = strings.TrimPrefix(path, "./")
+	for _, glob := range globs {
+		if glob.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{glob: "*.tf", path: "main.tf", match: true},
+		{glob: "*.tf", path: "modules/main.tf", match: false},
+		{glob: "./modules/*.tf", path: "modules/main.tf", match: true},
+		{glob: "modules/**/*.tf", path: "modules/main.tf", match: true},
+		{glob: "modules/**/*.tf", path: "modules/a/b/main.tf", match: true},
+		{glob: "modules/**", path: "modules/a/b/main.tf", match: true},
+		{glob: "dir/?.tf", path: "dir/a.tf", match: true},
+		{glob: "dir/?.tf", path: "dir/ab.tf", match: false},
+		{glob: "a+b/(x).tf", path: "a+b/(x).tf", match: true},
+		{glob: "modules/café/*.tf", path: "modules/café/main.tf", match: true},
+		{glob: "modules/café/*.tf", path: "modules/cafe/main.tf", match: false},
+		{glob: "dir/?.tf", path: "dir/é.tf", match: true},
+		{glob: "日本/**/*.tf", path: "日本/a/main.tf", match: true},
+	}
+	for _, test := range tests {
+		glob, err := compileGlob(test.glob)
+		if err != nil {
+			t.Fatalf("compileGlob(%q): %v", test.glob, err)
+		}
+		if got := glob.MatchString(test.path); got != test.match {
+			t.Errorf("%q matching %q = %v, want %v", test.glob, test.path, got, test.match)
+		}
+	}
+}
+
+func TestSeverityRank(t *testing.T) {
+	tests := []struct {
+		severity string
+		rank     int
+	}{
+		{severity: "LOW", rank: 1},
+		{severity: "medium", rank: 2},
+		{severity: "High", rank: 3},
+		{severity: "CRITICAL", rank: 4},
+		{severity: "UNKNOWN", rank: 2},
+		{severity: "", rank: 2},
+	}
+	for _, test := range tests {
+		if got := severityRank(test.severity); got != test.rank {
+			t.Errorf("severityRank(%q) = %d, want %d", test.severity, got, test.rank)
+		}
+	}
+}
+
+func TestNormaliseSeverity(t *testing.T) {
+	if severity, known := normaliseSeverity(" high "); severity != "HIGH" || !known {
+		t.Errorf("normaliseSeverity(high) = %s, %v", severity, known)
+	}
+	if severity, known := normaliseSeverity("UNKNOWN"); severity != unknownSeverity || known {
+		t.Errorf("normaliseSeverity(UNKNOWN) = %s, %v", severity, known)
+	}
+}
+
+func TestFilterKeepsUnknownSeverities(t *testing.T) {
+	filter := &resultFilter{minimumSeverity: severityRanks["LOW"], commentSeverity: severityRanks["HIGH"]}
+	r := result{RuleID: "rule", Severity: "UNKNOWN", Range: &checkRange{Filename: "main.tf"}}
+	if outcome := filter.decide(r); outcome != filterSummary {
+		t.Errorf("decide(UNKNOWN) = %v, want summary", outcome)
+	}
+}
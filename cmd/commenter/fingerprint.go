@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	fingerprintRegex = regexp.MustCompile(`\n*<!-- tfsec-pr-commenter:fingerprint ([0-9a-f]+) -->`)
+	whitespaceRegex  = regexp.MustCompile(`\s+`)
+)
+
+// generateFingerprint creates a stable identifier for a finding from the rule, file, resource and the code
+// it was found in. Line numbers and message text are deliberately left out so the fingerprint survives code
+// moving within the file and changes to the comment wording
+func generateFingerprint(result result) string {
+	snippet := readSnippet(result.Range.Filename, result.Range.StartLine, result.Range.EndLine)
+
+	hash := sha256.New()
+	for _, part := range []string{result.RuleID, result.Range.Filename, result.Resource, normaliseSnippet(snippet)} {
+		_, _ = hash.Write([]byte(part))
+		_, _ = hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:32]
+}
+
+// readSnippet returns the lines of the file in the given range, or empty if the file can't be read
+func readSnippet(filename string, startLine, endLine int) string {
+	file, err := os.Open(filename)
+	if err != nil {
+		return ""
+	}
+	defer func() { _ = file.Close() }()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan() && lineNo <= endLine; lineNo++ {
+		if lineNo >= startLine {
+			lines = append(lines, scanner.Text())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// normaliseSnippet removes blank lines and collapses whitespace so formatting changes don't alter the fingerprint
+func normaliseSnippet(snippet string) string {
+	var lines []string
+	for _, line := range strings.Split(snippet, "\n") {
+		line = strings.TrimSpace(whitespaceRegex.ReplaceAllString(line, " "))
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func appendFingerprint(comment, fingerprint string) string {
+	return fmt.Sprintf("%s\n\n<!-- tfsec-pr-commenter:fingerprint %s -->", comment, fingerprint)
+}
+
+func extractFingerprint(comment string) string {
+	groups := fingerprintRegex.FindStringSubmatch(comment)
+	if len(groups) < 2 {
+		return ""
+	}
+	return groups[1]
+}
+
+func removeFingerprint(comment string) string {
+	return fingerprintRegex.ReplaceAllString(comment, "")
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// giteaPageSize is the most items Gitea returns in a page by default
+const giteaPageSize = 50
+
+// giteaPlatform comments on pull requests in Gitea and Forgejo from their Actions, which set the same GITHUB_*
+// variables and event payload as GitHub Actions
+type giteaPlatform struct {
+	token  string
+	apiUrl string
+	owner  string
+	repo   string
+}
+
+func newGiteaPlatform() (*giteaPlatform, error) {
+	token := os.Getenv("INPUT_GITEA_TOKEN")
+	if token == "" {
+		token = os.Getenv("INPUT_GITHUB_TOKEN")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("the INPUT_GITEA_TOKEN has not been set")
+	}
+
+	split := strings.Split(os.Getenv("GITHUB_REPOSITORY"), "/")
+	if len(split) != 2 {
+		return nil, fmt.Errorf("unexpected value for GITHUB_REPOSITORY. Expected <organisation/name>, found %v", split)
+	}
+	logf("Working in repository %s\n", split[1])
+
+	apiUrl := os.Getenv("GITHUB_API_URL")
+	if apiUrl == "" {
+		serverUrl := os.Getenv("GITHUB_SERVER_URL")
+		if serverUrl == "" {
+			return nil, fmt.Errorf("the GITHUB_SERVER_URL has not been set")
+		}
+		apiUrl = strings.TrimSuffix(serverUrl, "/") + "/api/v1"
+	}
+
+	return &giteaPlatform{
+		token:  token,
+		apiUrl: apiUrl,
+		owner:  split[0],
+		repo:   split[1],
+	}, nil
+}
+
+func (p *giteaPlatform) name() string {
+	return "Gitea"
+}
+
+func (p *giteaPlatform) pullRequestNumber() (int, error) {
+	return extractPullRequestNumber(staticTokenSource(p.token), p.owner, p.repo)
+}
+
+func (p *giteaPlatform) connect(prNo int) (prCommenter, error) {
+	return newGiteaCommenter(&restClient{
+		httpClient: restHttpClient,
+		baseURL:    p.apiUrl,
+		header:     http.Header{"Authorization": []string{"token " + p.token}},
+		owner:      p.owner,
+		repo:       p.repo,
+		prNumber:   prNo,
+	})
+}
+
+func (p *giteaPlatform) commentContext(prNo int, sha string) commentContext {
+	return extractCommentContext(prNo, sha)
+}
+
+// readOnly is the same as on GitHub, the token given to workflows for pull requests from forks is read-only
+func (p *giteaPlatform) readOnly() bool {
+	return isForkPullRequest()
+}
+
+type giteaComment struct {
+	ID       int64       `json:"id"`
+	Body     string      `json:"body"`
+	Path     string      `json:"path"`
+	Resolver interface{} `json:"resolver"`
+}
+
+type giteaReviewComment struct {
+	Path        string `json:"path"`
+	Body        string `json:"body"`
+	NewPosition int    `json:"new_position"`
+}
+
+// giteaCommenter writes pull request reviews through the Gitea API
+type giteaCommenter struct {
+	reviewState
+	client         *restClient
+	repoPath       string
+	summaryComment *giteaComment
+}
+
+// newGiteaCommenter loads the pull request diff, the comments of its existing reviews and the summary comment
+func newGiteaCommenter(client *restClient) (*giteaCommenter, error) {
+	c := &giteaCommenter{
+		client:   client,
+		repoPath: fmt.Sprintf("/repos/%s/%s", client.owner, client.repo),
+	}
+
+	pr := struct {
+		Head struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+	}{}
+	prPath := fmt.Sprintf("%s/pulls/%d", c.repoPath, client.prNumber)
+	if _, err := client.do(http.MethodGet, prPath, nil, &pr); err != nil {
+		if _, ok := err.(restStatusError); ok {
+			return nil, newPrDoesNotExistError(client.owner, client.repo, client.prNumber)
+		}
+		return nil, err
+	}
+	c.headSHA = pr.Head.SHA
+
+	patch, err := client.getText(prPath + ".diff")
+	if err != nil {
+		return nil, fmt.Errorf("load pull request diff: %w", err)
+	}
+	if c.files, err = changedFilesFromPatch(patch, c.headSHA); err != nil {
+		return nil, fmt.Errorf("load pull request diff: %w", err)
+	}
+
+	if err := c.loadReviewComments(prPath); err != nil {
+		return nil, fmt.Errorf("load review comments: %w", err)
+	}
+	if err := c.loadSummaryComment(); err != nil {
+		return nil, fmt.Errorf("load summary comment: %w", err)
+	}
+	return c, nil
+}
+
+// getPages calls add with each page of results until a page isn't full
+func (c *giteaCommenter) getPages(add func(page int) (int, error)) error {
+	for page := 1; ; page++ {
+		count, err := add(page)
+		if err != nil {
+			return err
+		}
+		if count < giteaPageSize {
+			return nil
+		}
+	}
+}
+
+// pagePath adds the page to the path
+func pagePath(path string, page int) string {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return fmt.Sprintf("%s%spage=%d&limit=%d", path, separator, page, giteaPageSize)
+}
+
+// loadReviewComments lists the comments of every review on the pull request, as Gitea has no API for all of the
+// review comments at once. Gitea can't resolve a conversation through its API, so a comment the commenter edited
+// to say the issue was fixed counts as resolved and fixed
+func (c *giteaCommenter) loadReviewComments(prPath string) error {
+	var reviewIds []int64
+	err := c.getPages(func(page int) (int, error) {
+		var reviews []struct {
+			ID int64 `json:"id"`
+		}
+		if _, err := c.client.do(http.MethodGet, pagePath(prPath+"/reviews", page), nil, &reviews); err != nil {
+			return 0, err
+		}
+		for _, review := range reviews {
+			reviewIds = append(reviewIds, review.ID)
+		}
+		return len(reviews), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, reviewId := range reviewIds {
+		var comments []*giteaComment
+		path := fmt.Sprintf("%s/reviews/%d/comments", prPath, reviewId)
+		if _, err := c.client.do(http.MethodGet, path, nil, &comments); err != nil {
+			return err
+		}
+		for _, comment := range comments {
+			fixed := isFixedComment(comment.Body)
+			c.existingComments = append(c.existingComments, &existingComment{
+				filename:    &comment.Path,
+				comment:     &comment.Body,
+				commentId:   &comment.ID,
+				fingerprint: extractFingerprint(comment.Body),
+				resolved:    comment.Resolver != nil || fixed,
+				fixed:       fixed,
+			})
+		}
+	}
+	return nil
+}
+
+func (c *giteaCommenter) loadSummaryComment() error {
+	path := fmt.Sprintf("%s/issues/%d/comments", c.repoPath, c.client.prNumber)
+	return c.getPages(func(page int) (int, error) {
+		var comments []*giteaComment
+		if _, err := c.client.do(http.MethodGet, pagePath(path, page), nil, &comments); err != nil {
+			return 0, err
+		}
+		for _, comment := range comments {
+			if c.summaryComment == nil && strings.Contains(comment.Body, summaryMarker) {
+				c.summaryComment = comment
+			}
+		}
+		return len(comments), nil
+	})
+}
+
+// editComment changes a comment, which works the same for review comments and the summary comment
+func (c *giteaCommenter) editComment(commentId int64, comment string) error {
+	path := fmt.Sprintf("%s/issues/comments/%d", c.repoPath, commentId)
+	_, err := c.client.do(http.MethodPatch, path, map[string]string{"body": comment}, nil)
+	return err
+}
+
+// WriteMultiLineComment queues a review comment on the last line of the range, or edits the comment from an
+// earlier run for the same finding
+func (c *giteaCommenter) WriteMultiLineComment(file, comment string, startLine, endLine int) error {
+	existing, err := c.queueComment(file, comment, startLine, endLine)
+	if err != nil || existing == nil {
+		return err
+	}
+	if err := c.editComment(*existing.commentId, comment); err != nil {
+		return fmt.Errorf("edit review comment: %w", err)
+	}
+	c.edited(existing, comment)
+	return nil
+}
+
+// SubmitReview posts every queued comment to the pull request as a single review using the given event
+func (c *giteaCommenter) SubmitReview(event string) error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+
+	var comments []giteaReviewComment
+	for _, pending := range c.pending {
+		comments = append(comments, giteaReviewComment{
+			Path:        pending.filename,
+			Body:        pending.comment,
+			NewPosition: pending.endLine,
+		})
+	}
+	review := map[string]interface{}{
+		"commit_id": c.headSHA,
+		"event":     event,
+		"comments":  comments,
+	}
+	path := fmt.Sprintf("%s/pulls/%d/reviews", c.repoPath, c.client.prNumber)
+	if _, err := c.client.do(http.MethodPost, path, review, nil); err != nil {
+		return fmt.Errorf("write review: %w", err)
+	}
+	c.pending = nil
+	return nil
+}
+
+// CleanupStaleComments edits the comments from earlier runs whose finding is no longer reported to say which
+// commit fixed them. The Gitea API can't resolve, hide or reply to a review comment, so this is done for both
+// resolve and minimize, and with or without a reply
+func (c *giteaCommenter) CleanupStaleComments(current []findingComment, scope, action string, _ bool) (int, error) {
+	if action == staleActionNone {
+		return 0, nil
+	}
+
+	var cleaned int
+	for _, existing := range c.staleComments(current, scope) {
+		comment := fmt.Sprintf("%s\n\n%s", c.fixedComment(), *existing.comment)
+		if err := c.editComment(*existing.commentId, comment); err != nil {
+			return cleaned, fmt.Errorf("%s stale comment: %w", action, err)
+		}
+		c.edited(existing, comment)
+		existing.resolved, existing.fixed = true, true
+		cleaned++
+	}
+	return cleaned, nil
+}
+
+// PreviousSummary returns the body of the summary comment written on an earlier run, or empty if there isn't one
+func (c *giteaCommenter) PreviousSummary() (string, error) {
+	if c.summaryComment == nil {
+		return "", nil
+	}
+	return c.summaryComment.Body, nil
+}
+
+// WriteSummaryComment writes the summary comment on the pull request, updating the one from an earlier run in place
+func (c *giteaCommenter) WriteSummaryComment(comment string) error {
+	if c.summaryComment != nil {
+		return c.editComment(c.summaryComment.ID, comment)
+	}
+	path := fmt.Sprintf("%s/issues/%d/comments", c.repoPath, c.client.prNumber)
+	_, err := c.client.do(http.MethodPost, path, map[string]string{"body": comment}, nil)
+	return err
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const (
+	giteaTestRepo   = "/repos/team/infra"
+	giteaTestPr     = giteaTestRepo + "/pulls/5"
+	giteaTestCommit = "d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4"
+)
+
+// newGiteaTestApi serves the pull request, its diff, three reviews with their comments and the issue comments from
+// testdata/gitea
+func newGiteaTestApi(t *testing.T) *fakeApi {
+	api := newFakeApi(t)
+	api.replyFixture(http.MethodGet, giteaTestPr, "gitea/pull_request.json")
+	api.replyFixture(http.MethodGet, giteaTestPr+".diff", "gitea/pull_request.diff")
+	api.replyFixture(http.MethodGet, giteaTestPr+"/reviews?page=1&limit=50", "gitea/reviews.json")
+	api.replyFixture(http.MethodGet, giteaTestPr+"/reviews/11/comments", "gitea/review_11_comments.json")
+	api.replyFixture(http.MethodGet, giteaTestPr+"/reviews/12/comments", "gitea/review_12_comments.json")
+	api.replyFixture(http.MethodGet, giteaTestPr+"/reviews/13/comments", "gitea/review_13_comments.json")
+	api.replyFixture(http.MethodGet, giteaTestRepo+"/issues/5/comments?page=1&limit=50", "gitea/issue_comments.json")
+	return api
+}
+
+func newGiteaTestCommenter(t *testing.T, api *fakeApi) *giteaCommenter {
+	t.Helper()
+	c, err := newGiteaCommenter(&restClient{
+		httpClient: restHttpClient,
+		baseURL:    api.url(),
+		header:     http.Header{"Authorization": []string{"token secret"}},
+		owner:      "team",
+		repo:       "infra",
+		prNumber:   5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestGiteaCommenterLoadsPullRequest(t *testing.T) {
+	api := newGiteaTestApi(t)
+	defer api.close()
+	c := newGiteaTestCommenter(t, api)
+
+	if auth := api.sentOnce(http.MethodGet, giteaTestPr).header.Get("Authorization"); auth != "token secret" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if c.HeadSHA() != giteaTestCommit {
+		t.Errorf("HeadSHA = %s", c.HeadSHA())
+	}
+	if len(c.files) != 1 || !c.InChangedLines("infra/main.tf", 2, 2) || !c.InDiff("infra/main.tf", 11, 14) {
+		t.Errorf("the diff of infra/main.tf should be loaded without the deleted file")
+	}
+
+	// the comments of every review are loaded, the review without comments adds nothing
+	var ids []int64
+	for _, existing := range c.existingComments {
+		ids = append(ids, *existing.commentId)
+	}
+	if len(ids) != 7 || ids[0] != 601 || ids[6] != 607 {
+		t.Fatalf("existing comments = %v, want 601 to 607", ids)
+	}
+	// 603 was resolved in the UI and 607 was edited as fixed on an earlier run, only the edit marks it as fixed
+	for i, resolved := range []bool{false, false, true, false, false, false, true} {
+		if c.existingComments[i].resolved != resolved || c.existingComments[i].fixed != (i == 6) {
+			t.Errorf("comment %d resolved = %t, fixed = %t", ids[i], c.existingComments[i].resolved, c.existingComments[i].fixed)
+		}
+	}
+	if c.summaryComment == nil || c.summaryComment.ID != 608 {
+		t.Errorf("summary comment = %+v, want 608", c.summaryComment)
+	}
+}
+
+func TestGiteaCommenterPullRequestNotFound(t *testing.T) {
+	api := newFakeApi(t)
+	defer api.close()
+	api.handle(http.MethodGet, giteaTestPr, fakeResponse{status: http.StatusNotFound, body: `{"message":"pull request does not exist"}`})
+
+	_, err := newGiteaCommenter(&restClient{httpClient: restHttpClient, baseURL: api.url(), owner: "team", repo: "infra", prNumber: 5})
+	if _, ok := err.(PrDoesNotExistError); !ok {
+		t.Errorf("err = %v, want PrDoesNotExistError", err)
+	}
+}
+
+func TestGiteaCommenterWritesReview(t *testing.T) {
+	api := newGiteaTestApi(t)
+	defer api.close()
+	api.reply(http.MethodPatch, giteaTestRepo+"/issues/comments/602", `{"id":602}`)
+	api.reply(http.MethodPost, giteaTestPr+"/reviews", `{"id":14}`)
+	c := newGiteaTestCommenter(t, api)
+
+	added := testComment("aws-added", "f0f0f0f0")
+	context := testComment("aws-context", "f1f1f1f1")
+	refixed := testComment("aws-fixed", "abab7777")
+	reworded := testComment("aws-new-wording", "bbbb2222")
+
+	// the comments from earlier runs are spread over the reviews
+	for _, written := range []struct {
+		body string
+		line int
+	}{
+		{body: testComment("aws-already-written", "aaaa1111"), line: 2},
+		{body: testComment("aws-stale", "dddd4444"), line: 13},
+		// an issue a person dismissed by resolving its comment isn't commented on again
+		{body: testComment("aws-resolved", "cccc3333"), line: 2},
+	} {
+		if _, ok := c.WriteMultiLineComment("infra/main.tf", written.body, written.line, written.line).(CommentAlreadyWrittenError); !ok {
+			t.Errorf("%q from an earlier review should not be written again", written.body)
+		}
+	}
+	if err := c.WriteMultiLineComment("infra/main.tf", reworded, 3, 3); err != nil {
+		t.Fatal(err)
+	}
+	for _, comment := range []struct {
+		body       string
+		start, end int
+	}{
+		{body: added, start: 2, end: 2},
+		{body: context, start: 11, end: 12},
+		{body: refixed, start: 4, end: 4},
+	} {
+		if err := c.WriteMultiLineComment("infra/main.tf", comment.body, comment.start, comment.end); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := c.WriteMultiLineComment("infra/main.tf", added, 2, 2).(CommentAlreadyWrittenError); !ok {
+		t.Errorf("a queued comment should not be queued again")
+	}
+	if _, ok := c.WriteMultiLineComment("infra/main.tf", testComment("aws-outside", "f3f3f3f3"), 8, 8).(CommentNotValidError); !ok {
+		t.Errorf("a comment outside the diff should not be queued")
+	}
+
+	edit := struct {
+		Body string `json:"body"`
+	}{}
+	api.sentOnce(http.MethodPatch, giteaTestRepo+"/issues/comments/602").decode(t, &edit)
+	if edit.Body != reworded {
+		t.Errorf("edit = %+v", edit)
+	}
+
+	if err := c.SubmitReview("REQUEST_CHANGES"); err != nil {
+		t.Fatal(err)
+	}
+	review := struct {
+		CommitID string               `json:"commit_id"`
+		Event    string               `json:"event"`
+		Comments []giteaReviewComment `json:"comments"`
+	}{}
+	api.sentOnce(http.MethodPost, giteaTestPr+"/reviews").decode(t, &review)
+	if review.CommitID != giteaTestCommit || review.Event != "REQUEST_CHANGES" {
+		t.Errorf("review on %s with %s", review.CommitID, review.Event)
+	}
+	want := []giteaReviewComment{
+		{Path: "infra/main.tf", Body: added, NewPosition: 2},
+		// a range is written on its last line
+		{Path: "infra/main.tf", Body: context, NewPosition: 12},
+		{Path: "infra/main.tf", Body: refixed, NewPosition: 4},
+	}
+	if len(review.Comments) != len(want) {
+		t.Fatalf("got %d review comments, want %d", len(review.Comments), len(want))
+	}
+	for i, comment := range review.Comments {
+		if comment != want[i] {
+			t.Errorf("review comment %d = %+v, want %+v", i, comment, want[i])
+		}
+	}
+
+	if err := c.SubmitReview("COMMENT"); err != nil || len(api.sent(http.MethodPost, giteaTestPr+"/reviews")) != 1 {
+		t.Errorf("a review without comments should not be written")
+	}
+}
+
+func TestGiteaCommenterEditsStaleComments(t *testing.T) {
+	api := newGiteaTestApi(t)
+	defer api.close()
+	api.reply(http.MethodPatch, giteaTestRepo+"/issues/comments/604", `{"id":604}`)
+	c := newGiteaTestCommenter(t, api)
+
+	current := []findingComment{
+		{filename: "infra/main.tf", comment: testComment("aws-already-written", "aaaa1111")},
+		{filename: "infra/main.tf", comment: testComment("aws-new-wording", "bbbb2222")},
+	}
+	if cleaned, err := c.CleanupStaleComments(current, "infra/", staleActionNone, true); err != nil || cleaned != 0 || len(api.writes()) != 0 {
+		t.Errorf("nothing should be cleaned up with the none action")
+	}
+
+	cleaned, err := c.CleanupStaleComments(current, "infra/", staleActionResolve, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 603 and 607 are already resolved, 605 is outside the working directory and 606 wasn't written by the commenter
+	if cleaned != 1 {
+		t.Errorf("cleaned = %d, want 1", cleaned)
+	}
+
+	// the comment can't be resolved or replied to, so it is edited to say it was fixed and keeps its fingerprint
+	edit := struct {
+		Body string `json:"body"`
+	}{}
+	api.sentOnce(http.MethodPatch, giteaTestRepo+"/issues/comments/604").decode(t, &edit)
+	if want := fixedCommentFor(giteaTestCommit) + "\n\n" + testComment("aws-stale", "dddd4444"); edit.Body != want {
+		t.Errorf("edit = %q, want %q", edit.Body, want)
+	}
+	if !strings.HasPrefix(*c.existingComments[3].comment, fixedCommentPrefix) || extractFingerprint(edit.Body) != "dddd4444" {
+		t.Errorf("the edited comment should be kept with its fingerprint")
+	}
+
+	if cleaned, err = c.CleanupStaleComments(current, "infra/", staleActionMinimize, false); err != nil || cleaned != 0 {
+		t.Errorf("second cleanup = %d, %v, want nothing", cleaned, err)
+	}
+}
+
+func TestGiteaCommenterUpdatesSummaryComment(t *testing.T) {
+	api := newGiteaTestApi(t)
+	defer api.close()
+	api.reply(http.MethodPatch, giteaTestRepo+"/issues/comments/608", `{"id":608}`)
+	c := newGiteaTestCommenter(t, api)
+
+	previous, err := c.PreviousSummary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous != summaryMarker+"\n## tfsec summary\n\n:white_check_mark: tfsec found no issues.\n" {
+		t.Errorf("PreviousSummary = %q", previous)
+	}
+	if err := c.WriteSummaryComment(summaryMarker + "\nupdated"); err != nil {
+		t.Fatal(err)
+	}
+	update := struct {
+		Body string `json:"body"`
+	}{}
+	api.sentOnce(http.MethodPatch, giteaTestRepo+"/issues/comments/608").decode(t, &update)
+	if update.Body != summaryMarker+"\nupdated" {
+		t.Errorf("summary = %+v", update)
+	}
+}
+
+func TestGiteaCommenterWritesNewSummaryComment(t *testing.T) {
+	api := newGiteaTestApi(t)
+	defer api.close()
+	api.reply(http.MethodGet, giteaTestRepo+"/issues/5/comments?page=1&limit=50", `[]`)
+	api.reply(http.MethodPost, giteaTestRepo+"/issues/5/comments", `{"id":610}`)
+	c := newGiteaTestCommenter(t, api)
+
+	if err := c.WriteSummaryComment(summaryMarker + "\nfirst"); err != nil {
+		t.Fatal(err)
+	}
+	summary := struct {
+		Body string `json:"body"`
+	}{}
+	api.sentOnce(http.MethodPost, giteaTestRepo+"/issues/5/comments").decode(t, &summary)
+	if summary.Body != summaryMarker+"\nfirst" {
+		t.Errorf("summary = %+v", summary)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// githubAppJwtLifetime is how long each app JWT is valid for, GitHub allows at most 10 minutes
+	githubAppJwtLifetime = 9 * time.Minute
+	// githubAppClockSkew backdates the JWT in case the runner's clock is ahead of GitHub's
+	githubAppClockSkew = time.Minute
+	// githubAppTokenRefresh is how long before an installation token expires that a new one is requested
+	githubAppTokenRefresh = 5 * time.Minute
+)
+
+// githubAppTokenSource exchanges a JWT signed with the private key of a GitHub App for a token of its installation
+// on the repository. Installation tokens last an hour, so a new one is requested when it is about to expire
+type githubAppTokenSource struct {
+	appId          string
+	installationId int64
+	privateKey     *rsa.PrivateKey
+	owner          string
+	repo           string
+	// newClient creates the client that calls GitHub as the app
+	newClient func(tokenSource oauth2.TokenSource) (*github.Client, error)
+}
+
+// extractGithubAppTokenSource reads the GitHub App settings, returning nil when no app ID has been set
+func extractGithubAppTokenSource(owner, repo string) (oauth2.TokenSource, error) {
+	appId := strings.TrimSpace(os.Getenv("INPUT_GITHUB_APP_ID"))
+	if appId == "" {
+		return nil, nil
+	}
+
+	privateKey, err := extractGithubAppPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var installationId int64
+	if value := strings.TrimSpace(os.Getenv("INPUT_GITHUB_APP_INSTALLATION_ID")); value != "" {
+		installationId, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || installationId <= 0 {
+			return nil, fmt.Errorf("unexpected value for INPUT_GITHUB_APP_INSTALLATION_ID. Expected an installation ID, found %s", value)
+		}
+	}
+
+	source := &githubAppTokenSource{
+		appId:          appId,
+		installationId: installationId,
+		privateKey:     privateKey,
+		owner:          owner,
+		repo:           repo,
+		newClient:      createGithubClient,
+	}
+	return oauth2.ReuseTokenSource(nil, source), nil
+}
+
+// extractGithubAppPrivateKey reads the app's PEM private key from INPUT_GITHUB_APP_PRIVATE_KEY, or from the file
+// in INPUT_GITHUB_APP_PRIVATE_KEY_FILE
+func extractGithubAppPrivateKey() (*rsa.PrivateKey, error) {
+	key := os.Getenv("INPUT_GITHUB_APP_PRIVATE_KEY")
+	if key == "" {
+		keyFile := os.Getenv("INPUT_GITHUB_APP_PRIVATE_KEY_FILE")
+		if keyFile == "" {
+			return nil, fmt.Errorf("the INPUT_GITHUB_APP_PRIVATE_KEY or INPUT_GITHUB_APP_PRIVATE_KEY_FILE has not been set")
+		}
+		content, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read GitHub App private key: %w", err)
+		}
+		key = string(content)
+	}
+	privateKey, err := parseRsaPrivateKey([]byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("parse GitHub App private key: %w", err)
+	}
+	return privateKey, nil
+}
+
+// parseRsaPrivateKey reads a PEM RSA key in either the PKCS #1 format GitHub generates or PKCS #8
+func parseRsaPrivateKey(content []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// Token requests a new installation token, finding the installation on the repository the first time if it
+// wasn't given
+func (s *githubAppTokenSource) Token() (*oauth2.Token, error) {
+	jwt, err := s.jwt(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("sign GitHub App JWT: %w", err)
+	}
+	client, err := s.newClient(staticTokenSource(jwt))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if s.installationId == 0 {
+		installation, _, err := client.Apps.FindRepositoryInstallation(ctx, s.owner, s.repo)
+		if err != nil {
+			return nil, fmt.Errorf("find GitHub App installation on %s/%s: %w", s.owner, s.repo, err)
+		}
+		s.installationId = installation.GetID()
+	}
+
+	token, _, err := client.Apps.CreateInstallationToken(ctx, s.installationId, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create GitHub App installation token: %w", err)
+	}
+	logf("Created a GitHub App installation token expiring at %s\n", token.GetExpiresAt().Format(time.RFC3339))
+	return &oauth2.Token{
+		AccessToken: token.GetToken(),
+		Expiry:      token.GetExpiresAt().Add(-githubAppTokenRefresh),
+	}, nil
+}
+
+// jwt returns a JSON web token for the app signed with RS256, as GitHub requires to authenticate as the app
+func (s *githubAppTokenSource) jwt(now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-githubAppClockSkew).Unix(),
+		"exp": now.Add(githubAppJwtLifetime).Unix(),
+		"iss": s.appId,
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.privateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+	"golang.org/x/oauth2"
+)
+
+const githubTestAccessTokens = "/app/installations/42/access_tokens"
+
+var (
+	githubTestKeyOnce sync.Once
+	githubTestKey     *rsa.PrivateKey
+)
+
+// testAppKey is the private key of the test app, generated once as it takes a while
+func testAppKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	githubTestKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		githubTestKey = key
+	})
+	return githubTestKey
+}
+
+// newTestAppTokenSource is the token source for app 1234 calling the fake API
+func newTestAppTokenSource(t *testing.T, api *fakeApi, installationId int64) *githubAppTokenSource {
+	return &githubAppTokenSource{
+		appId:          "1234",
+		installationId: installationId,
+		privateKey:     testAppKey(t),
+		owner:          "team",
+		repo:           "infra",
+		newClient: func(tokenSource oauth2.TokenSource) (*github.Client, error) {
+			client := github.NewClient(newHttpClient(tokenSource))
+			client.BaseURL, _ = url.Parse(api.url() + "/")
+			return client, nil
+		},
+	}
+}
+
+// installationToken answers the request for an installation token with the token, expiring after the duration
+func installationToken(token string, expiresIn time.Duration) string {
+	return fmt.Sprintf(`{"token":%q,"expires_at":%q}`, token, time.Now().Add(expiresIn).UTC().Format(time.RFC3339))
+}
+
+// verifyAppJwt checks the JWT was signed with the app's key and returns its claims
+func verifyAppJwt(t *testing.T, jwt string) map[string]interface{} {
+	t.Helper()
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("JWT %q should have three parts", jwt)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&testAppKey(t).PublicKey, crypto.SHA256, hash[:], signature); err != nil {
+		t.Errorf("JWT signature: %v", err)
+	}
+
+	var header, claims map[string]interface{}
+	for i, v := range []*map[string]interface{}{&header, &claims} {
+		content, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(content, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if header["alg"] != "RS256" || header["typ"] != "JWT" {
+		t.Errorf("JWT header = %v", header)
+	}
+	return claims
+}
+
+func TestGithubAppJwt(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	jwt, err := newTestAppTokenSource(t, nil, 42).jwt(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := verifyAppJwt(t, jwt)
+	// the JWT is backdated a minute for clock skew and lasts less than the 10 minutes GitHub allows
+	if claims["iss"] != "1234" || claims["iat"] != float64(now.Unix()-60) || claims["exp"] != float64(now.Unix()+9*60) {
+		t.Errorf("JWT claims = %v", claims)
+	}
+}
+
+func TestGithubAppTokenFindsInstallation(t *testing.T) {
+	api := newFakeApi(t)
+	defer api.close()
+	api.reply(http.MethodGet, "/repos/team/infra/installation", `{"id":42,"app_id":1234}`)
+	api.reply(http.MethodPost, githubTestAccessTokens, installationToken("ghs_first", time.Hour))
+	source := newTestAppTokenSource(t, api, 0)
+
+	token, err := source.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "ghs_first" || source.installationId != 42 {
+		t.Errorf("token = %+v for installation %d", token, source.installationId)
+	}
+
+	// both requests are made as the app
+	for _, request := range []fakeRequest{api.sentOnce(http.MethodGet, "/repos/team/infra/installation"), api.sentOnce(http.MethodPost, githubTestAccessTokens)} {
+		auth := request.header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || verifyAppJwt(t, strings.TrimPrefix(auth, "Bearer "))["iss"] != "1234" {
+			t.Errorf("%s %s sent Authorization %q, want the app JWT", request.method, request.uri, auth)
+		}
+	}
+
+	// the installation is only looked up once
+	if _, err := source.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if installations := api.sent(http.MethodGet, "/repos/team/infra/installation"); len(installations) != 1 {
+		t.Errorf("looked up the installation %d times, want once", len(installations))
+	}
+}
+
+func TestGithubAppTokenWithInstallationId(t *testing.T) {
+	api := newFakeApi(t)
+	defer api.close()
+	api.reply(http.MethodPost, githubTestAccessTokens, installationToken("ghs_first", time.Hour))
+
+	// the fake API fails the test if the installation is looked up
+	if _, err := newTestAppTokenSource(t, api, 42).Token(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGithubAppTokenNotInstalled(t *testing.T) {
+	api := newFakeApi(t)
+	defer api.close()
+	api.handle(http.MethodGet, "/repos/team/infra/installation", fakeResponse{status: http.StatusNotFound, body: `{"message":"Not Found"}`})
+
+	if _, err := newTestAppTokenSource(t, api, 0).Token(); err == nil || !strings.Contains(err.Error(), "team/infra") {
+		t.Errorf("err = %v, want the installation not to be found", err)
+	}
+}
+
+func TestGithubAppTokenRefresh(t *testing.T) {
+	api := newFakeApi(t)
+	defer api.close()
+	// the first token is already within 5 minutes of expiring, the second lasts an hour
+	tokens := []string{installationToken("ghs_first", 4*time.Minute), installationToken("ghs_second", time.Hour)}
+	api.handleFunc(http.MethodPost, githubTestAccessTokens, func(fakeRequest) fakeResponse {
+		token := tokens[0]
+		if len(tokens) > 1 {
+			tokens = tokens[1:]
+		}
+		return fakeResponse{status: http.StatusCreated, body: token}
+	})
+	source := oauth2.ReuseTokenSource(nil, newTestAppTokenSource(t, api, 42))
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		token, err := source.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, token.AccessToken)
+
+		// the token is refreshed 5 minutes before GitHub expires it
+		if i == 2 {
+			if refreshIn := time.Until(token.Expiry); refreshIn < 54*time.Minute || refreshIn > 55*time.Minute {
+				t.Errorf("token is refreshed in %s, want 55 minutes", refreshIn)
+			}
+		}
+	}
+	if want := []string{"ghs_first", "ghs_second", "ghs_second"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("tokens = %v, want %v", got, want)
+	}
+	if requests := api.sent(http.MethodPost, githubTestAccessTokens); len(requests) != 2 {
+		t.Errorf("requested %d tokens, want 2", len(requests))
+	}
+}
+
+func TestParseRsaPrivateKey(t *testing.T) {
+	key := testAppKey(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string][]byte{
+		"PKCS #1": pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		"PKCS #8": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+	} {
+		parsed, err := parseRsaPrivateKey(content)
+		if err != nil || !parsed.Equal(key) {
+			t.Errorf("%s: parseRsaPrivateKey = %v", name, err)
+		}
+	}
+	if _, err := parseRsaPrivateKey([]byte("not a key")); err == nil {
+		t.Errorf("content without a PEM block should be rejected")
+	}
+}
+
+func TestExtractGithubAppTokenSource(t *testing.T) {
+	keyFile, err := ioutil.TempFile("", "app-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(keyFile.Name())
+	_ = pem.Encode(keyFile, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(testAppKey(t))})
+	_ = keyFile.Close()
+
+	defer os.Unsetenv("INPUT_GITHUB_APP_ID")
+	defer os.Unsetenv("INPUT_GITHUB_APP_PRIVATE_KEY_FILE")
+	defer os.Unsetenv("INPUT_GITHUB_APP_INSTALLATION_ID")
+
+	if source, err := extractGithubAppTokenSource("team", "infra"); source != nil || err != nil {
+		t.Errorf("no token source should be made without an app ID")
+	}
+
+	_ = os.Setenv("INPUT_GITHUB_APP_ID", "1234")
+	if _, err := extractGithubAppTokenSource("team", "infra"); err == nil {
+		t.Errorf("an app ID without a private key should be rejected")
+	}
+
+	_ = os.Setenv("INPUT_GITHUB_APP_PRIVATE_KEY_FILE", keyFile.Name())
+	_ = os.Setenv("INPUT_GITHUB_APP_INSTALLATION_ID", "abc")
+	if _, err := extractGithubAppTokenSource("team", "infra"); err == nil {
+		t.Errorf("an installation ID that isn't a number should be rejected")
+	}
+
+	_ = os.Setenv("INPUT_GITHUB_APP_INSTALLATION_ID", "42")
+	if source, err := extractGithubAppTokenSource("team", "infra"); source == nil || err != nil {
+		t.Errorf("extractGithubAppTokenSource = %v, %v", source, err)
+	}
+}
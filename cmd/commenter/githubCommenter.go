@@ -0,0 +1,434 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v32/github"
+	"golang.org/x/oauth2"
+)
+
+// findingComment is the comment generated for a finding in the current run, whether or not it is part of the diff
+type findingComment struct {
+	filename string
+	comment  string
+}
+
+// Commenter is the main commenter struct
+type Commenter struct {
+	ghConnector      *connector
+	existingComments []*existingComment
+	files            changedFiles
+	pendingComments  []*github.DraftReviewComment
+	commitID         string
+	summaryComment   *github.IssueComment
+	summaryLoaded    bool
+	// threads are the review threads keyed on the id of their first comment, only loaded when needed
+	threads map[int64]*reviewThread
+}
+
+var commitRefRegex = regexp.MustCompile(".+ref=(.+)")
+
+// NewCommenter creates a Commenter for updating PR with comments
+func NewCommenter(tokenSource oauth2.TokenSource, owner, repo string, prNumber int) (*Commenter, error) {
+
+	if tokenSource == nil {
+		return nil, errors.New("the GITHUB_TOKEN has not been set")
+	}
+
+	ghConnector, err := createConnector(tokenSource, owner, repo, prNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	commitFileInfos, existingComments, err := loadPr(ghConnector)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Commenter{
+		ghConnector:      ghConnector,
+		existingComments: existingComments,
+		files:            commitFileInfos,
+	}, nil
+}
+
+// NewEnterpriseCommenter creates a Commenter for updating PR with comments in an Enterprise Github Server
+func NewEnterpriseCommenter(tokenSource oauth2.TokenSource, baseUrl, uploadUrl, owner, repo string, prNumber int) (*Commenter, error) {
+
+	if tokenSource == nil {
+		return nil, errors.New("the GITHUB_TOKEN has not been set")
+	}
+
+	if len(baseUrl) == 0 {
+		return nil, errors.New("the baseUrl has not been set")
+	}
+
+	ghConnector, err := createEnterpriseConnector(tokenSource, baseUrl, uploadUrl, owner, repo, prNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	commitFileInfos, existingComments, err := loadPr(ghConnector)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Commenter{
+		ghConnector:      ghConnector,
+		existingComments: existingComments,
+		files:            commitFileInfos,
+	}, nil
+}
+
+func loadPr(ghConnector *connector) (changedFiles, []*existingComment, error) {
+
+	commitFileInfos, err := githubDiffProvider{ghConnector: ghConnector}.changedFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	existingComments, err := ghConnector.getExistingComments()
+	if err != nil {
+		return nil, nil, err
+	}
+	return commitFileInfos, existingComments, nil
+}
+
+// WriteMultiLineComment queues a multiline review comment on a file in the github PR
+func (c *Commenter) WriteMultiLineComment(file, comment string, startLine, endLine int) error {
+
+	if !c.checkCommentRelevant(file, startLine) || !c.checkCommentRelevant(file, endLine) {
+		return newCommentNotValidError(file, startLine)
+	}
+
+	info, err := c.getFileInfo(file, endLine)
+	if err != nil {
+		return err
+	}
+
+	// a multi-line comment has to sit within a single hunk, otherwise fall back to the last line
+	if startLine == endLine || !info.diff.containsRange(startLine, endLine) {
+		return c.WriteLineComment(file, comment, endLine)
+	}
+
+	prComment := buildComment(file, comment, endLine)
+	prComment.StartLine = &startLine
+	prComment.StartSide = github.String("RIGHT")
+	return c.writeCommentIfRequired(prComment, info.sha)
+}
+
+// WriteLineComment queues a single line review comment on a file of the github PR
+func (c *Commenter) WriteLineComment(file, comment string, line int) error {
+
+	if !c.checkCommentRelevant(file, line) {
+		return newCommentNotValidError(file, line)
+	}
+
+	info, err := c.getFileInfo(file, line)
+	if err != nil {
+		return err
+	}
+	prComment := buildComment(file, comment, line)
+	return c.writeCommentIfRequired(prComment, info.sha)
+}
+
+func (c *Commenter) WriteGeneralComment(comment string) error {
+
+	issueComment := &github.IssueComment{
+		Body: &comment,
+	}
+	return c.ghConnector.writeGeneralComment(issueComment)
+}
+
+// SubmitReview posts every queued comment to the PR as a single review using the given event
+func (c *Commenter) SubmitReview(event string) error {
+
+	if len(c.pendingComments) == 0 {
+		return nil
+	}
+
+	review := &github.PullRequestReviewRequest{
+		CommitID: &c.commitID,
+		Event:    &event,
+		Comments: c.pendingComments,
+	}
+	if err := c.ghConnector.writeReview(review); err != nil {
+		if !isValidationError(err) {
+			return fmt.Errorf("write review: %w", err)
+		}
+		// a single comment GitHub can't place fails the whole review, so fall back to one review per comment
+		logf("GitHub rejected the review, writing its %d comments one at a time\n", len(c.pendingComments))
+		if err := c.writeReviewPerComment(event); err != nil {
+			return err
+		}
+	}
+	c.pendingComments = nil
+	return nil
+}
+
+// writeReviewPerComment writes each queued comment as a review of its own, dropping the comments GitHub rejects
+// so the rest are still written
+func (c *Commenter) writeReviewPerComment(event string) error {
+
+	for _, comment := range c.pendingComments {
+		review := &github.PullRequestReviewRequest{
+			CommitID: &c.commitID,
+			Event:    &event,
+			Comments: []*github.DraftReviewComment{comment},
+		}
+		err := c.ghConnector.writeReview(review)
+		if isValidationError(err) {
+			logf("Dropping comment on %s:%d, GitHub rejected it: %v\n", comment.GetPath(), comment.GetLine(), err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("write review: %w", err)
+		}
+	}
+	return nil
+}
+
+// PendingComments returns the number of comments queued for the next review
+func (c *Commenter) PendingComments() int {
+	return len(c.pendingComments)
+}
+
+// PreviousSummary returns the body of the summary comment written on an earlier run, or empty if there isn't one
+func (c *Commenter) PreviousSummary() (string, error) {
+
+	if err := c.loadSummaryComment(); err != nil {
+		return "", err
+	}
+	return c.summaryComment.GetBody(), nil
+}
+
+// WriteSummaryComment writes the summary comment on the PR, updating the one from an earlier run in place
+func (c *Commenter) WriteSummaryComment(comment string) error {
+
+	if err := c.loadSummaryComment(); err != nil {
+		return err
+	}
+	if c.summaryComment == nil {
+		return c.WriteGeneralComment(comment)
+	}
+	return c.ghConnector.editGeneralComment(c.summaryComment.GetID(), &github.IssueComment{
+		Body: &comment,
+	})
+}
+
+func (c *Commenter) loadSummaryComment() error {
+
+	if c.summaryLoaded {
+		return nil
+	}
+	summaryComment, err := c.ghConnector.getSummaryComment()
+	if err != nil {
+		return fmt.Errorf("load summary comment: %w", err)
+	}
+	c.summaryComment = summaryComment
+	c.summaryLoaded = true
+	return nil
+}
+
+// CleanupStaleComments resolves or minimizes comments from earlier runs on files under the scope whose finding is
+// no longer reported, optionally replying to say which commit fixed it. The number of comments cleaned up is returned
+func (c *Commenter) CleanupStaleComments(current []findingComment, scope, action string, reply bool) (int, error) {
+
+	if action == staleActionNone {
+		return 0, nil
+	}
+
+	if err := c.loadReviewThreads(); err != nil {
+		return 0, err
+	}
+
+	var cleaned int
+	for _, existing := range c.existingComments {
+		if !existing.isStale(current, scope) {
+			continue
+		}
+		thread, ok := c.threads[*existing.commentId]
+		if !ok || existing.resolved {
+			continue
+		}
+
+		if reply {
+			if err := c.ghConnector.writeReplyComment(*existing.commentId, fixedCommentFor(c.ghConnector.headSHA)); err != nil {
+				return cleaned, fmt.Errorf("write reply comment: %w", err)
+			}
+		}
+
+		var err error
+		switch action {
+		case staleActionResolve:
+			err = c.ghConnector.resolveReviewThread(thread.id)
+		case staleActionMinimize:
+			err = c.ghConnector.minimizeComment(*existing.nodeId)
+		}
+		if err != nil {
+			return cleaned, fmt.Errorf("%s stale comment: %w", action, err)
+		}
+		existing.resolved, existing.fixed = true, reply
+		cleaned++
+	}
+	return cleaned, nil
+}
+
+// loadReviewThreads looks up the review threads of the PR once, marking the comments from earlier runs that were
+// resolved or minimized, and the ones the commenter marked as fixed
+func (c *Commenter) loadReviewThreads() error {
+
+	if c.threads != nil {
+		return nil
+	}
+	threads, err := c.ghConnector.getReviewThreads()
+	if err != nil {
+		return fmt.Errorf("load review threads: %w", err)
+	}
+	for _, existing := range c.existingComments {
+		if thread, ok := threads[*existing.commentId]; ok {
+			existing.resolved = thread.isResolved || thread.isMinimized
+			existing.fixed = existing.resolved && thread.fixedReply
+		}
+	}
+	c.threads = threads
+	return nil
+}
+
+// writeCommentIfRequired queues the comment unless an earlier run already wrote it. A comment the commenter marked
+// as fixed doesn't count, so an issue that comes back after it was fixed is commented on again, while a comment
+// resolved or minimized by hand is left alone
+func (c *Commenter) writeCommentIfRequired(prComment *github.DraftReviewComment, sha string) error {
+
+	for _, existing := range c.existingComments {
+		if !existing.matches(*prComment.Path, *prComment.Body) {
+			continue
+		}
+		if err := c.loadReviewThreads(); err != nil {
+			return err
+		}
+		if existing.fixed {
+			continue
+		}
+		if existing.resolved || *existing.comment == *prComment.Body {
+			return newCommentAlreadyWrittenError(*prComment.Path, *prComment.Body)
+		}
+		// the finding is the same but the wording has changed, so update the comment in place
+		if err := c.ghConnector.editReviewComment(*existing.commentId, *prComment.Body); err != nil {
+			return fmt.Errorf("edit review comment: %w", err)
+		}
+		existing.comment = prComment.Body
+		existing.fingerprint = extractFingerprint(*prComment.Body)
+		return nil
+	}
+
+	fingerprint := extractFingerprint(*prComment.Body)
+	for _, pending := range c.pendingComments {
+		if *pending.Path == *prComment.Path && (*pending.Body == *prComment.Body ||
+			fingerprint != "" && extractFingerprint(*pending.Body) == fingerprint) {
+			return newCommentAlreadyWrittenError(*prComment.Path, *prComment.Body)
+		}
+	}
+
+	c.commitID = sha
+	c.pendingComments = append(c.pendingComments, prComment)
+	return nil
+}
+
+// matches reports whether the existing comment was written for the same finding as the comment. Comments
+// are matched on their fingerprint, falling back to the file and body for comments written before
+// fingerprints were added
+func (ec *existingComment) matches(filename, comment string) bool {
+
+	if fingerprint := extractFingerprint(comment); fingerprint != "" && ec.fingerprint != "" {
+		return ec.fingerprint == fingerprint
+	}
+	return *ec.filename == filename && removeFingerprint(*ec.comment) == removeFingerprint(comment)
+}
+
+// isStale reports whether the commenter wrote the comment on a file under the scope, for a finding that is no longer
+// reported. An empty scope is the whole repository
+func (ec *existingComment) isStale(current []findingComment, scope string) bool {
+	return isCommenterComment(*ec.comment) && strings.HasPrefix(*ec.filename, scope) && !ec.matchesAny(current)
+}
+
+func (ec *existingComment) matchesAny(comments []findingComment) bool {
+
+	for _, fc := range comments {
+		if ec.matches(fc.filename, fc.comment) {
+			return true
+		}
+	}
+	return false
+}
+
+// HeadSHA returns the commit at the head of the PR
+func (c *Commenter) HeadSHA() string {
+	return c.ghConnector.headSHA
+}
+
+// InDiff reports whether both ends of the line range are part of the PR diff for the file
+func (c *Commenter) InDiff(file string, startLine, endLine int) bool {
+	return c.files.inDiff(file, startLine, endLine)
+}
+
+// InChangedLines reports whether any line in the range was added or modified in the PR
+func (c *Commenter) InChangedLines(file string, startLine, endLine int) bool {
+	return c.files.inChangedLines(file, startLine, endLine)
+}
+
+func (c *Commenter) checkCommentRelevant(filename string, line int) bool {
+
+	if c.files.find(filename) == nil {
+		return false
+	}
+	relevant := c.files.containsLine(filename, line)
+	logf("File changed in PR %v: issue at L%v, in diff: %v\n", filename, line, relevant)
+	return relevant
+}
+
+func (c *Commenter) getFileInfo(file string, line int) (*commitFileInfo, error) {
+
+	if c.files.containsLine(file, line) {
+		return c.files.find(file), nil
+	}
+	return nil, errors.New("file not found, shouldn't have got to here")
+}
+
+func buildComment(file, comment string, line int) *github.DraftReviewComment {
+
+	return &github.DraftReviewComment{
+		Line: &line,
+		Side: github.String("RIGHT"),
+		Path: &file,
+		Body: &comment,
+	}
+}
+
+func getCommitInfo(file *github.CommitFile) (cfi *commitFileInfo, err error) {
+	patch := file.GetPatch()
+
+	// GitHub leaves the patch out for binary files and for diffs that are too large to return
+	var diff *fileDiff
+	if patch != "" {
+		if diff, err = parsePatch(patch); err != nil {
+			return nil, fmt.Errorf("the patch details for [%s] could not be resolved: %w", *file.Filename, err)
+		}
+	}
+
+	shaGroups := commitRefRegex.FindAllStringSubmatch(file.GetContentsURL(), -1)
+	if len(shaGroups) < 1 {
+		return nil, fmt.Errorf("the sha details for [%s] could not be resolved", *file.Filename)
+	}
+	sha := shaGroups[0][1]
+
+	return &commitFileInfo{
+		FileName:     *file.Filename,
+		diff:         diff,
+		sha:          sha,
+		likelyBinary: patch == "",
+	}, nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	githubTestPr     = "/repos/team/infra/pulls/5"
+	githubTestCommit = "d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4"
+)
+
+// newGithubTestApi serves the pull request, its files and its review comments from testdata/github
+func newGithubTestApi(t *testing.T) *fakeApi {
+	api := newFakeApi(t)
+	api.replyFixture(http.MethodGet, githubTestPr, "github/pull_request.json")
+	api.replyFixture(http.MethodGet, githubTestPr+"/files?per_page=100", "github/files.json")
+	api.replyFixture(http.MethodGet, githubTestPr+"/comments?per_page=100", "github/comments.json")
+	return api
+}
+
+func newGithubTestCommenter(t *testing.T, api *fakeApi) *Commenter {
+	t.Helper()
+	client := github.NewClient(http.DefaultClient)
+	client.BaseURL, _ = url.Parse(api.url() + "/")
+
+	ghConnector, err := newConnector(client, http.DefaultClient, "team", "infra", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, existingComments, err := loadPr(ghConnector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Commenter{
+		ghConnector:      ghConnector,
+		existingComments: existingComments,
+		files:            files,
+	}
+}
+
+// queueGithubTestComments queues a comment on each line, all of them new findings
+func queueGithubTestComments(t *testing.T, c *Commenter, lines ...int) []string {
+	t.Helper()
+	var comments []string
+	for _, line := range lines {
+		comment := testComment(fmt.Sprintf("aws-line-%d", line), fmt.Sprintf("f0f0%04d", line))
+		if err := c.WriteMultiLineComment("infra/main.tf", comment, line, line); err != nil {
+			t.Fatal(err)
+		}
+		comments = append(comments, comment)
+	}
+	return comments
+}
+
+// sentReviews decodes the reviews written to the pull request, in order
+func sentReviews(t *testing.T, api *fakeApi) []github.PullRequestReviewRequest {
+	t.Helper()
+	var reviews []github.PullRequestReviewRequest
+	for _, request := range api.sent(http.MethodPost, githubTestPr+"/reviews") {
+		var review github.PullRequestReviewRequest
+		request.decode(t, &review)
+		reviews = append(reviews, review)
+	}
+	return reviews
+}
+
+// rejectReview answers a review with a 422, as GitHub does for a comment it can't place, when it has one of the
+// rejected comments
+func rejectReview(rejected ...string) func(request fakeRequest) fakeResponse {
+	return func(request fakeRequest) fakeResponse {
+		for _, comment := range rejected {
+			// the bodies are escaped in the request, their fingerprints aren't
+			if !strings.Contains(request.body, extractFingerprint(comment)) {
+				continue
+			}
+			return fakeResponse{
+				status: http.StatusUnprocessableEntity,
+				body:   `{"message":"Unprocessable Entity","errors":["Line could not be resolved"]}`,
+			}
+		}
+		return fakeResponse{body: `{"id":1}`}
+	}
+}
+
+func TestGithubCommenterSubmitsSingleReview(t *testing.T) {
+	api := newGithubTestApi(t)
+	defer api.close()
+	api.reply(http.MethodPost, githubTestPr+"/reviews", `{"id":1}`)
+	c := newGithubTestCommenter(t, api)
+
+	comments := queueGithubTestComments(t, c, 2, 12, 13)
+	if err := c.SubmitReview("REQUEST_CHANGES"); err != nil {
+		t.Fatal(err)
+	}
+
+	reviews := sentReviews(t, api)
+	if len(reviews) != 1 {
+		t.Fatalf("got %d reviews, want 1", len(reviews))
+	}
+	review := reviews[0]
+	if review.GetCommitID() != githubTestCommit || review.GetEvent() != "REQUEST_CHANGES" || len(review.Comments) != len(comments) {
+		t.Errorf("review = %+v", review)
+	}
+	for i, comment := range review.Comments {
+		if comment.GetBody() != comments[i] || comment.GetPath() != "infra/main.tf" || comment.GetSide() != "RIGHT" {
+			t.Errorf("review comment %d = %+v", i, comment)
+		}
+	}
+	if c.PendingComments() != 0 {
+		t.Errorf("PendingComments = %d after submitting", c.PendingComments())
+	}
+}
+
+func TestGithubCommenterFallsBackToReviewPerComment(t *testing.T) {
+	api := newGithubTestApi(t)
+	defer api.close()
+	c := newGithubTestCommenter(t, api)
+
+	comments := queueGithubTestComments(t, c, 2, 12, 13)
+	// GitHub can't place the comment on line 12, which fails the whole review
+	api.handleFunc(http.MethodPost, githubTestPr+"/reviews", rejectReview(comments[1]))
+	if err := c.SubmitReview("COMMENT"); err != nil {
+		t.Fatal(err)
+	}
+
+	reviews := sentReviews(t, api)
+	if len(reviews) != 4 || len(reviews[0].Comments) != 3 {
+		t.Fatalf("got %d reviews, want the review of all three comments then one per comment", len(reviews))
+	}
+	for i, review := range reviews[1:] {
+		if len(review.Comments) != 1 || review.Comments[0].GetBody() != comments[i] || review.GetEvent() != "COMMENT" {
+			t.Errorf("review %d = %+v, want only comment %d", i+1, review, i)
+		}
+	}
+	if c.PendingComments() != 0 {
+		t.Errorf("PendingComments = %d, the rejected comment should be dropped", c.PendingComments())
+	}
+}
+
+func TestGithubCommenterDropsEveryRejectedComment(t *testing.T) {
+	api := newGithubTestApi(t)
+	defer api.close()
+	c := newGithubTestCommenter(t, api)
+
+	comments := queueGithubTestComments(t, c, 2, 13)
+	api.handleFunc(http.MethodPost, githubTestPr+"/reviews", rejectReview(comments...))
+	if err := c.SubmitReview("COMMENT"); err != nil {
+		t.Errorf("rejected comments should be dropped rather than fail the run: %v", err)
+	}
+	if reviews := sentReviews(t, api); len(reviews) != 3 {
+		t.Errorf("got %d reviews, want the review of both comments then one per comment", len(reviews))
+	}
+}
+
+func TestGithubCommenterDoesNotRetryFailedReview(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// status of the review with every comment, and of the reviews with one
+		status, perCommentStatus int
+		reviews                  int
+	}{
+		{name: "server error", status: http.StatusInternalServerError, reviews: 1},
+		{name: "server error after rejection", status: http.StatusUnprocessableEntity, perCommentStatus: http.StatusBadGateway, reviews: 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newGithubTestApi(t)
+			defer api.close()
+			c := newGithubTestCommenter(t, api)
+
+			queueGithubTestComments(t, c, 2, 13)
+			api.handleFunc(http.MethodPost, githubTestPr+"/reviews", func(request fakeRequest) fakeResponse {
+				if len(api.sent(http.MethodPost, githubTestPr+"/reviews")) == 1 {
+					return fakeResponse{status: tt.status, body: `{"message":"failed"}`}
+				}
+				return fakeResponse{status: tt.perCommentStatus, body: `{"message":"failed"}`}
+			})
+			err := c.SubmitReview("COMMENT")
+			if err == nil || isValidationError(err) {
+				t.Errorf("err = %v, want the failure of the review", err)
+			}
+			if reviews := sentReviews(t, api); len(reviews) != tt.reviews {
+				t.Errorf("got %d reviews, want %d", len(reviews), tt.reviews)
+			}
+			if c.PendingComments() != 2 {
+				t.Errorf("PendingComments = %d, the comments should be kept after a failure", c.PendingComments())
+			}
+		})
+	}
+}
+
+// serveGithubTestThreads answers the review threads query from testdata/github and resolves any thread
+func serveGithubTestThreads(t *testing.T, api *fakeApi) {
+	threads := readFixture(t, "github/review_threads.json")
+	api.handleFunc(http.MethodPost, "/graphql", func(request fakeRequest) fakeResponse {
+		if strings.Contains(request.body, "resolveReviewThread") {
+			return fakeResponse{body: `{"data":{"resolveReviewThread":{"thread":{"id":"PRRT_204"}}}}`}
+		}
+		return fakeResponse{body: threads}
+	})
+}
+
+func TestGithubCommenterSkipsDismissedComments(t *testing.T) {
+	api := newGithubTestApi(t)
+	defer api.close()
+	serveGithubTestThreads(t, api)
+	c := newGithubTestCommenter(t, api)
+
+	for _, written := range []struct {
+		body string
+		line int
+	}{
+		{body: testComment("aws-already-written", "aaaa1111"), line: 2},
+		// a comment minimized by hand isn't updated or written again, even when its wording changes
+		{body: testComment("aws-new-wording", "bbbb2222"), line: 3},
+		// an issue a person dismissed by resolving its thread isn't commented on again
+		{body: testComment("aws-dismissed", "acac8888"), line: 2},
+	} {
+		if _, ok := c.WriteMultiLineComment("infra/main.tf", written.body, written.line, written.line).(CommentAlreadyWrittenError); !ok {
+			t.Errorf("%q from an earlier run should not be written again", written.body)
+		}
+	}
+
+	// the thread of cccc3333 was resolved by the commenter when the issue was fixed, so the issue is back
+	if err := c.WriteMultiLineComment("infra/main.tf", testComment("aws-resolved", "cccc3333"), 2, 2); err != nil {
+		t.Fatal(err)
+	}
+	if c.PendingComments() != 1 {
+		t.Errorf("PendingComments = %d, want the returning issue", c.PendingComments())
+	}
+	if threads := api.sent(http.MethodPost, "/graphql"); len(threads) != 1 {
+		t.Errorf("loaded the review threads %d times, want once", len(threads))
+	}
+	if len(api.sent(http.MethodPatch, "/repos/team/infra/pulls/comments/202")) != 0 {
+		t.Errorf("the minimized comment should not be edited")
+	}
+}
+
+func TestGithubCommenterResolvesStaleComments(t *testing.T) {
+	api := newGithubTestApi(t)
+	defer api.close()
+	serveGithubTestThreads(t, api)
+	api.reply(http.MethodPost, githubTestPr+"/comments", `{"id":213}`)
+	c := newGithubTestCommenter(t, api)
+
+	current := []findingComment{{filename: "infra/main.tf", comment: testComment("aws-already-written", "aaaa1111")}}
+	cleaned, err := c.CleanupStaleComments(current, "infra/", staleActionResolve, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 202 was minimized and 203 and 207 resolved, 205 is outside the working directory and 206 wasn't written by
+	// the commenter
+	if cleaned != 1 {
+		t.Errorf("cleaned = %d, want 1", cleaned)
+	}
+
+	reply := struct {
+		Body      string `json:"body"`
+		InReplyTo int64  `json:"in_reply_to"`
+	}{}
+	api.sentOnce(http.MethodPost, githubTestPr+"/comments").decode(t, &reply)
+	if reply.InReplyTo != 204 || reply.Body != fixedCommentFor(githubTestCommit) {
+		t.Errorf("reply = %+v", reply)
+	}
+	resolves := 0
+	for _, request := range api.sent(http.MethodPost, "/graphql") {
+		if strings.Contains(request.body, "resolveReviewThread") {
+			resolves++
+			if !strings.Contains(request.body, `"threadId":"PRRT_204"`) {
+				t.Errorf("resolved %s, want PRRT_204", request.body)
+			}
+		}
+	}
+	if resolves != 1 {
+		t.Errorf("resolved %d threads, want 1", resolves)
+	}
+
+	// the issue coming back is commented on again
+	if err := c.WriteMultiLineComment("infra/main.tf", testComment("aws-stale", "dddd4444"), 13, 13); err != nil {
+		t.Errorf("an issue that comes back after the commenter marked it fixed should be commented again: %v", err)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	gitlabDefaultApiUrl = "https://gitlab.com/api/v4"
+	gitlabPageSize      = 100
+)
+
+// gitlabPlatform comments on merge requests from GitLab CI, using the CI_* variables of a merge request pipeline
+type gitlabPlatform struct {
+	token   string
+	apiUrl  string
+	project string
+}
+
+func newGitlabPlatform() (*gitlabPlatform, error) {
+	token := os.Getenv("INPUT_GITLAB_TOKEN")
+	if token == "" {
+		token = os.Getenv("GITLAB_TOKEN")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("the INPUT_GITLAB_TOKEN has not been set")
+	}
+
+	project := os.Getenv("CI_PROJECT_ID")
+	if project == "" {
+		project = os.Getenv("CI_PROJECT_PATH")
+	}
+	if project == "" {
+		return nil, fmt.Errorf("the CI_PROJECT_ID has not been set")
+	}
+	logf("Working in project %s\n", project)
+
+	apiUrl := os.Getenv("CI_API_V4_URL")
+	if apiUrl == "" {
+		apiUrl = gitlabDefaultApiUrl
+	}
+
+	return &gitlabPlatform{
+		token:   token,
+		apiUrl:  apiUrl,
+		project: project,
+	}, nil
+}
+
+func (p *gitlabPlatform) name() string {
+	return "GitLab"
+}
+
+// pullRequestNumber is the merge request IID, which is only set for merge request pipelines
+func (p *gitlabPlatform) pullRequestNumber() (int, error) {
+	iid := os.Getenv("INPUT_PR_NUMBER")
+	if iid == "" {
+		iid = os.Getenv("CI_MERGE_REQUEST_IID")
+	}
+	if iid == "" {
+		return 0, fmt.Errorf("the CI_MERGE_REQUEST_IID has not been set")
+	}
+	return strconv.Atoi(iid)
+}
+
+func (p *gitlabPlatform) connect(prNo int) (prCommenter, error) {
+	return newGitlabCommenter(p.token, p.apiUrl, p.project, prNo)
+}
+
+func (p *gitlabPlatform) commentContext(prNo int, sha string) commentContext {
+	serverUrl := os.Getenv("CI_SERVER_URL")
+	if serverUrl == "" {
+		serverUrl = "https://gitlab.com"
+	}
+	return commentContext{
+		Owner:       os.Getenv("CI_PROJECT_NAMESPACE"),
+		Repo:        os.Getenv("CI_PROJECT_NAME"),
+		Repository:  os.Getenv("CI_PROJECT_PATH"),
+		PullRequest: prNo,
+		ServerURL:   serverUrl,
+		SHA:         sha,
+	}
+}
+
+// readOnly is never known up front on GitLab, a token that can't write is only found when writing fails
+func (p *gitlabPlatform) readOnly() bool {
+	return false
+}
+
+// gitlabDiffRefs are the commits a merge request diff is between, which every positioned discussion refers to
+type gitlabDiffRefs struct {
+	BaseSHA  string `json:"base_sha"`
+	HeadSHA  string `json:"head_sha"`
+	StartSHA string `json:"start_sha"`
+}
+
+type gitlabDiff struct {
+	OldPath     string `json:"old_path"`
+	NewPath     string `json:"new_path"`
+	Diff        string `json:"diff"`
+	DeletedFile bool   `json:"deleted_file"`
+}
+
+type gitlabPosition struct {
+	PositionType string `json:"position_type"`
+	BaseSHA      string `json:"base_sha"`
+	StartSHA     string `json:"start_sha"`
+	HeadSHA      string `json:"head_sha"`
+	OldPath      string `json:"old_path"`
+	NewPath      string `json:"new_path"`
+	NewLine      int    `json:"new_line,omitempty"`
+	OldLine      int    `json:"old_line,omitempty"`
+}
+
+type gitlabNote struct {
+	ID       int64           `json:"id"`
+	Body     string          `json:"body"`
+	System   bool            `json:"system"`
+	Resolved bool            `json:"resolved"`
+	Position *gitlabPosition `json:"position"`
+}
+
+type gitlabDiscussion struct {
+	ID    string       `json:"id"`
+	Notes []gitlabNote `json:"notes"`
+}
+
+// gitlabCommenter writes merge request discussions through the GitLab REST API
+type gitlabCommenter struct {
+	reviewState
+	client        *restClient
+	mrPath        string
+	diffRefs      gitlabDiffRefs
+	oldPaths      map[string]string
+	summaryNote   *gitlabNote
+	summaryLoaded bool
+}
+
+// newGitlabCommenter loads the merge request diff and the existing discussions
+func newGitlabCommenter(token, apiUrl, project string, mrIid int) (*gitlabCommenter, error) {
+	owner, repo := project, ""
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		owner, repo = project[:i], project[i+1:]
+	}
+
+	c := &gitlabCommenter{
+		client: &restClient{
+			httpClient: restHttpClient,
+			baseURL:    apiUrl,
+			header:     http.Header{"Private-Token": []string{token}},
+			owner:      owner,
+			repo:       repo,
+			prNumber:   mrIid,
+		},
+		mrPath:   fmt.Sprintf("/projects/%s/merge_requests/%d", url.PathEscape(project), mrIid),
+		oldPaths: make(map[string]string),
+	}
+
+	mr := struct {
+		DiffRefs gitlabDiffRefs `json:"diff_refs"`
+	}{}
+	if _, err := c.client.do(http.MethodGet, c.mrPath, nil, &mr); err != nil {
+		if _, ok := err.(restStatusError); ok {
+			return nil, newPrDoesNotExistError(owner, repo, mrIid)
+		}
+		return nil, err
+	}
+	c.diffRefs = mr.DiffRefs
+	c.headSHA = mr.DiffRefs.HeadSHA
+
+	if err := c.loadDiffs(); err != nil {
+		return nil, fmt.Errorf("load merge request diffs: %w", err)
+	}
+	if err := c.loadDiscussions(); err != nil {
+		return nil, fmt.Errorf("load merge request discussions: %w", err)
+	}
+	return c, nil
+}
+
+// getPages follows the X-Next-Page header, calling add with each page of results
+func (c *gitlabCommenter) getPages(path string, newPage func() interface{}, add func(interface{})) error {
+	page := "1"
+	for page != "" {
+		data := newPage()
+		header, err := c.client.do(http.MethodGet, fmt.Sprintf("%s?per_page=%d&page=%s", path, gitlabPageSize, page), nil, data)
+		if err != nil {
+			return err
+		}
+		add(data)
+		page = header.Get("X-Next-Page")
+	}
+	return nil
+}
+
+func (c *gitlabCommenter) loadDiffs() error {
+	return c.getPages(c.mrPath+"/diffs", func() interface{} { return &[]gitlabDiff{} }, func(data interface{}) {
+		for _, file := range *data.(*[]gitlabDiff) {
+			if file.DeletedFile {
+				continue
+			}
+			// GitLab leaves the diff out for binary files and for diffs that are too large to return
+			var diff *fileDiff
+			if file.Diff != "" {
+				var err error
+				if diff, err = parsePatch(file.Diff); err != nil {
+					logf("Warning: the diff of %s could not be parsed (%s)\n", file.NewPath, err.Error())
+				}
+			}
+			c.files = append(c.files, &commitFileInfo{
+				FileName:     file.NewPath,
+				diff:         diff,
+				sha:          c.diffRefs.HeadSHA,
+				likelyBinary: file.Diff == "",
+			})
+			c.oldPaths[file.NewPath] = file.OldPath
+		}
+	})
+}
+
+// loadDiscussions keeps the discussions on a line of a file. The first note of each is the comment, any
+// others are replies, the last of which says whether the commenter resolved it as fixed
+func (c *gitlabCommenter) loadDiscussions() error {
+	return c.getPages(c.mrPath+"/discussions", func() interface{} { return &[]gitlabDiscussion{} }, func(data interface{}) {
+		for _, discussion := range *data.(*[]gitlabDiscussion) {
+			if len(discussion.Notes) == 0 || discussion.Notes[0].Position == nil || discussion.Notes[0].System {
+				continue
+			}
+			note := discussion.Notes[0]
+			last := discussion.Notes[len(discussion.Notes)-1]
+			discussionId := discussion.ID
+			c.existingComments = append(c.existingComments, &existingComment{
+				filename:    &note.Position.NewPath,
+				comment:     &note.Body,
+				commentId:   &note.ID,
+				nodeId:      &discussionId,
+				fingerprint: extractFingerprint(note.Body),
+				resolved:    note.Resolved,
+				fixed:       note.Resolved && last.ID != note.ID && isFixedComment(last.Body),
+			})
+		}
+	})
+}
+
+// WriteMultiLineComment queues a discussion on the last line of the range, or edits the discussion from an
+// earlier run for the same finding
+func (c *gitlabCommenter) WriteMultiLineComment(file, comment string, startLine, endLine int) error {
+	existing, err := c.queueComment(file, comment, startLine, endLine)
+	if err != nil || existing == nil {
+		return err
+	}
+	path := fmt.Sprintf("%s/discussions/%s/notes/%d", c.mrPath, *existing.nodeId, *existing.commentId)
+	if _, err := c.client.do(http.MethodPut, path, map[string]string{"body": comment}, nil); err != nil {
+		return fmt.Errorf("edit discussion: %w", err)
+	}
+	c.edited(existing, comment)
+	return nil
+}
+
+// SubmitReview starts a discussion for every queued comment. GitLab has no review event, so a request for
+// changes is written as comments like any other
+func (c *gitlabCommenter) SubmitReview(string) error {
+	for len(c.pending) > 0 {
+		discussion := struct {
+			Body     string         `json:"body"`
+			Position gitlabPosition `json:"position"`
+		}{
+			Body:     c.pending[0].comment,
+			Position: c.position(c.pending[0].filename, c.pending[0].endLine),
+		}
+		if _, err := c.client.do(http.MethodPost, c.mrPath+"/discussions", discussion, nil); err != nil {
+			return fmt.Errorf("write discussion: %w", err)
+		}
+		c.pending = c.pending[1:]
+	}
+	return nil
+}
+
+// position places a discussion on a line of the diff. Context lines need their line in the old file as well,
+// or GitLab rejects the position
+func (c *gitlabCommenter) position(file string, line int) gitlabPosition {
+	position := gitlabPosition{
+		PositionType: "text",
+		BaseSHA:      c.diffRefs.BaseSHA,
+		StartSHA:     c.diffRefs.StartSHA,
+		HeadSHA:      c.diffRefs.HeadSHA,
+		OldPath:      c.oldPaths[file],
+		NewPath:      file,
+		NewLine:      line,
+	}
+	if oldLine, context := c.files.find(file).diff.newToOld(line); context {
+		position.OldLine = oldLine
+	}
+	return position
+}
+
+// CleanupStaleComments resolves the discussions from earlier runs whose finding is no longer reported. GitLab
+// can't minimize a note, so minimize resolves the discussion as well
+func (c *gitlabCommenter) CleanupStaleComments(current []findingComment, scope, action string, reply bool) (int, error) {
+	if action == staleActionNone {
+		return 0, nil
+	}
+
+	var cleaned int
+	for _, existing := range c.staleComments(current, scope) {
+		path := fmt.Sprintf("%s/discussions/%s", c.mrPath, *existing.nodeId)
+		if reply {
+			if _, err := c.client.do(http.MethodPost, path+"/notes", map[string]string{"body": c.fixedComment()}, nil); err != nil {
+				return cleaned, fmt.Errorf("write reply comment: %w", err)
+			}
+		}
+		if _, err := c.client.do(http.MethodPut, path+"?resolved=true", nil, nil); err != nil {
+			return cleaned, fmt.Errorf("%s stale comment: %w", action, err)
+		}
+		existing.resolved, existing.fixed = true, reply
+		cleaned++
+	}
+	return cleaned, nil
+}
+
+// PreviousSummary returns the body of the summary note written on an earlier run, or empty if there isn't one
+func (c *gitlabCommenter) PreviousSummary() (string, error) {
+	if err := c.loadSummaryNote(); err != nil {
+		return "", err
+	}
+	if c.summaryNote == nil {
+		return "", nil
+	}
+	return c.summaryNote.Body, nil
+}
+
+// WriteSummaryComment writes the summary note on the merge request, updating the one from an earlier run in place
+func (c *gitlabCommenter) WriteSummaryComment(comment string) error {
+	if err := c.loadSummaryNote(); err != nil {
+		return err
+	}
+	body := map[string]string{"body": comment}
+	if c.summaryNote == nil {
+		_, err := c.client.do(http.MethodPost, c.mrPath+"/notes", body, nil)
+		return err
+	}
+	_, err := c.client.do(http.MethodPut, fmt.Sprintf("%s/notes/%d", c.mrPath, c.summaryNote.ID), body, nil)
+	return err
+}
+
+func (c *gitlabCommenter) loadSummaryNote() error {
+	if c.summaryLoaded {
+		return nil
+	}
+	err := c.getPages(c.mrPath+"/notes", func() interface{} { return &[]gitlabNote{} }, func(data interface{}) {
+		for _, note := range *data.(*[]gitlabNote) {
+			if c.summaryNote == nil && !note.System && strings.Contains(note.Body, summaryMarker) {
+				summaryNote := note
+				c.summaryNote = &summaryNote
+			}
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("load summary comment: %w", err)
+	}
+	c.summaryLoaded = true
+	return nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+const gitlabTestMr = "/projects/42/merge_requests/7"
+
+// newGitlabTestApi serves the merge request, its diff over two pages and its discussions from testdata/gitlab
+func newGitlabTestApi(t *testing.T) *fakeApi {
+	api := newFakeApi(t)
+	api.replyFixture(http.MethodGet, gitlabTestMr, "gitlab/merge_request.json")
+	api.handle(http.MethodGet, gitlabTestMr+"/diffs?per_page=100&page=1", fakeResponse{
+		header: http.Header{"X-Next-Page": []string{"2"}},
+		body:   readFixture(t, "gitlab/diffs_page1.json"),
+	})
+	api.replyFixture(http.MethodGet, gitlabTestMr+"/diffs?per_page=100&page=2", "gitlab/diffs_page2.json")
+	api.replyFixture(http.MethodGet, gitlabTestMr+"/discussions?per_page=100&page=1", "gitlab/discussions.json")
+	return api
+}
+
+func newGitlabTestCommenter(t *testing.T, api *fakeApi) *gitlabCommenter {
+	t.Helper()
+	c, err := newGitlabCommenter("secret", api.url(), "42", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestGitlabCommenterLoadsMergeRequest(t *testing.T) {
+	api := newGitlabTestApi(t)
+	defer api.close()
+	c := newGitlabTestCommenter(t, api)
+
+	if token := api.sentOnce(http.MethodGet, gitlabTestMr).header.Get("Private-Token"); token != "secret" {
+		t.Errorf("Private-Token = %q", token)
+	}
+	if c.HeadSHA() != "d4d4d4d4" {
+		t.Errorf("HeadSHA = %s", c.HeadSHA())
+	}
+	if len(c.files) != 2 || c.files.find("infra/deleted.tf") != nil {
+		t.Errorf("deleted files should be left out, got %d files", len(c.files))
+	}
+	if !c.InChangedLines("infra/main.tf", 2, 2) || !c.InDiff("infra/main.tf", 11, 14) || c.InDiff("infra/main.tf", 8, 8) {
+		t.Errorf("infra/main.tf should have both hunks")
+	}
+	if !c.InChangedLines("infra/new/vpc.tf", 3, 3) {
+		t.Errorf("the second page of diffs should be loaded")
+	}
+
+	// system notes and discussions that aren't on a line are left out
+	var ids []int64
+	for _, existing := range c.existingComments {
+		ids = append(ids, *existing.commentId)
+	}
+	if len(ids) != 7 {
+		t.Fatalf("existing comments = %v, want the notes 101 to 105, 108 and 109", ids)
+	}
+	if !c.existingComments[2].resolved || c.existingComments[0].resolved || !c.existingComments[6].resolved {
+		t.Errorf("only discussions d3 and d10 are resolved")
+	}
+	// d3 ends with the reply of the commenter, d10 was resolved by hand
+	if !c.existingComments[2].fixed || c.existingComments[6].fixed {
+		t.Errorf("only discussion d3 was resolved as fixed by the commenter")
+	}
+}
+
+func TestGitlabCommenterMergeRequestNotFound(t *testing.T) {
+	api := newFakeApi(t)
+	defer api.close()
+	api.handle(http.MethodGet, gitlabTestMr, fakeResponse{status: http.StatusNotFound, body: `{"message":"404 Not found"}`})
+
+	_, err := newGitlabCommenter("secret", api.url(), "42", 7)
+	if _, ok := err.(PrDoesNotExistError); !ok {
+		t.Errorf("err = %v, want PrDoesNotExistError", err)
+	}
+}
+
+func TestGitlabCommenterWritesDiscussions(t *testing.T) {
+	api := newGitlabTestApi(t)
+	defer api.close()
+	api.reply(http.MethodPut, gitlabTestMr+"/discussions/d2/notes/102", `{"id":102}`)
+	api.reply(http.MethodPost, gitlabTestMr+"/discussions", `{"id":"d9"}`)
+	c := newGitlabTestCommenter(t, api)
+
+	added := testComment("aws-added", "f0f0f0f0")
+	context := testComment("aws-context", "f1f1f1f1")
+	renamed := testComment("aws-renamed", "f2f2f2f2")
+	reintroduced := testComment("aws-resolved", "cccc3333")
+	reworded := testComment("aws-new-wording", "bbbb2222")
+
+	if err := c.WriteMultiLineComment("infra/main.tf", testComment("aws-already-written", "aaaa1111"), 2, 2); err == nil {
+		t.Errorf("a comment from an earlier run should not be written again")
+	} else if _, ok := err.(CommentAlreadyWrittenError); !ok {
+		t.Errorf("err = %v, want CommentAlreadyWrittenError", err)
+	}
+	// an issue a person dismissed by resolving its comment isn't commented on again
+	if _, ok := c.WriteMultiLineComment("infra/main.tf", testComment("aws-dismissed", "acac8888"), 2, 2).(CommentAlreadyWrittenError); !ok {
+		t.Errorf("a comment resolved by hand should not be written again")
+	}
+	if err := c.WriteMultiLineComment("infra/main.tf", reworded, 3, 3); err != nil {
+		t.Fatal(err)
+	}
+	for _, comment := range []struct {
+		file       string
+		body       string
+		start, end int
+	}{
+		{file: "infra/main.tf", body: added, start: 2, end: 2},
+		{file: "infra/main.tf", body: context, start: 11, end: 12},
+		{file: "infra/new/vpc.tf", body: renamed, start: 3, end: 3},
+		{file: "infra/main.tf", body: reintroduced, start: 2, end: 2},
+	} {
+		if err := c.WriteMultiLineComment(comment.file, comment.body, comment.start, comment.end); err != nil {
+			t.Fatalf("%s: %v", comment.file, err)
+		}
+	}
+	if _, ok := c.WriteMultiLineComment("infra/main.tf", testComment("aws-added-again", "f0f0f0f0"), 2, 2).(CommentAlreadyWrittenError); !ok {
+		t.Errorf("a comment already queued should not be queued again")
+	}
+	if _, ok := c.WriteMultiLineComment("infra/main.tf", testComment("aws-outside", "f3f3f3f3"), 8, 8).(CommentNotValidError); !ok {
+		t.Errorf("a comment outside the diff should not be queued")
+	}
+
+	edit := struct {
+		Body string `json:"body"`
+	}{}
+	api.sentOnce(http.MethodPut, gitlabTestMr+"/discussions/d2/notes/102").decode(t, &edit)
+	if edit.Body != reworded {
+		t.Errorf("edited body = %q", edit.Body)
+	}
+
+	if c.PendingComments() != 4 {
+		t.Fatalf("PendingComments = %d, want 4", c.PendingComments())
+	}
+	if err := c.SubmitReview("REQUEST_CHANGES"); err != nil {
+		t.Fatal(err)
+	}
+	if c.PendingComments() != 0 {
+		t.Errorf("PendingComments = %d after submitting", c.PendingComments())
+	}
+
+	refs := gitlabPosition{PositionType: "text", BaseSHA: "b0b0b0b0", StartSHA: "5a5a5a5a", HeadSHA: "d4d4d4d4"}
+	want := []struct {
+		body     string
+		position gitlabPosition
+	}{
+		{body: added, position: gitlabPosition{OldPath: "infra/main.tf", NewPath: "infra/main.tf", NewLine: 2}},
+		// a context line needs its old line as well
+		{body: context, position: gitlabPosition{OldPath: "infra/main.tf", NewPath: "infra/main.tf", NewLine: 12, OldLine: 11}},
+		{body: renamed, position: gitlabPosition{OldPath: "infra/old/vpc.tf", NewPath: "infra/new/vpc.tf", NewLine: 3}},
+		{body: reintroduced, position: gitlabPosition{OldPath: "infra/main.tf", NewPath: "infra/main.tf", NewLine: 2}},
+	}
+	posts := api.sent(http.MethodPost, gitlabTestMr+"/discussions")
+	if len(posts) != len(want) {
+		t.Fatalf("got %d discussions, want %d", len(posts), len(want))
+	}
+	for i, post := range posts {
+		discussion := struct {
+			Body     string         `json:"body"`
+			Position gitlabPosition `json:"position"`
+		}{}
+		post.decode(t, &discussion)
+
+		position := want[i].position
+		position.PositionType, position.BaseSHA, position.StartSHA, position.HeadSHA = refs.PositionType, refs.BaseSHA, refs.StartSHA, refs.HeadSHA
+		if discussion.Body != want[i].body {
+			t.Errorf("discussion %d body = %q, want %q", i, discussion.Body, want[i].body)
+		}
+		if discussion.Position != position {
+			t.Errorf("discussion %d position = %+v, want %+v", i, discussion.Position, position)
+		}
+	}
+}
+
+func TestGitlabCommenterResolvesStaleDiscussions(t *testing.T) {
+	api := newGitlabTestApi(t)
+	defer api.close()
+	api.reply(http.MethodPost, gitlabTestMr+"/discussions/d4/notes", `{"id":114}`)
+	api.reply(http.MethodPut, gitlabTestMr+"/discussions/d4?resolved=true", `{"id":"d4"}`)
+	c := newGitlabTestCommenter(t, api)
+
+	current := []findingComment{
+		{filename: "infra/main.tf", comment: testComment("aws-already-written", "aaaa1111")},
+		{filename: "infra/main.tf", comment: testComment("aws-new-wording", "bbbb2222")},
+	}
+	cleaned, err := c.CleanupStaleComments(current, "infra/", staleActionResolve, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// d3 is already resolved, d5 is outside the working directory and d8 wasn't written by the commenter
+	if cleaned != 1 {
+		t.Errorf("cleaned = %d, want 1", cleaned)
+	}
+	writes := api.writes()
+	if len(writes) != 2 || writes[0].method != http.MethodPost || writes[1].method != http.MethodPut {
+		t.Fatalf("writes = %+v, want the reply then the resolve", writes)
+	}
+	reply := struct {
+		Body string `json:"body"`
+	}{}
+	writes[0].decode(t, &reply)
+	if reply.Body != fixedCommentFor("d4d4d4d4") {
+		t.Errorf("reply = %q", reply.Body)
+	}
+
+	// resolved discussions are not resolved again
+	if cleaned, err = c.CleanupStaleComments(current, "infra/", staleActionResolve, true); err != nil || cleaned != 0 {
+		t.Errorf("second cleanup = %d, %v, want nothing", cleaned, err)
+	}
+	if cleaned, err = c.CleanupStaleComments(nil, "", staleActionNone, true); err != nil || cleaned != 0 {
+		t.Errorf("cleanup with none = %d, %v, want nothing", cleaned, err)
+	}
+	if len(api.writes()) != 2 {
+		t.Errorf("got %d writes, want 2", len(api.writes()))
+	}
+}
+
+func TestGitlabCommenterUpdatesSummaryNote(t *testing.T) {
+	api := newGitlabTestApi(t)
+	defer api.close()
+	api.handle(http.MethodGet, gitlabTestMr+"/notes?per_page=100&page=1", fakeResponse{
+		header: http.Header{"X-Next-Page": []string{"2"}},
+		body:   readFixture(t, "gitlab/notes_page1.json"),
+	})
+	api.replyFixture(http.MethodGet, gitlabTestMr+"/notes?per_page=100&page=2", "gitlab/notes_page2.json")
+	api.reply(http.MethodPut, gitlabTestMr+"/notes/203", `{"id":203}`)
+	c := newGitlabTestCommenter(t, api)
+
+	previous, err := c.PreviousSummary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous != summaryMarker+"\n## tfsec summary\n\n:white_check_mark: tfsec found no issues.\n" {
+		t.Errorf("PreviousSummary = %q", previous)
+	}
+
+	if err := c.WriteSummaryComment(summaryMarker + "\nupdated"); err != nil {
+		t.Fatal(err)
+	}
+	update := struct {
+		Body string `json:"body"`
+	}{}
+	api.sentOnce(http.MethodPut, gitlabTestMr+"/notes/203").decode(t, &update)
+	if update.Body != summaryMarker+"\nupdated" {
+		t.Errorf("summary body = %q", update.Body)
+	}
+	if len(api.sent(http.MethodGet, gitlabTestMr+"/notes?per_page=100&page=1")) != 1 {
+		t.Errorf("the notes should only be loaded once")
+	}
+}
+
+func TestGitlabCommenterWritesNewSummaryNote(t *testing.T) {
+	api := newGitlabTestApi(t)
+	defer api.close()
+	api.replyFixture(http.MethodGet, gitlabTestMr+"/notes?per_page=100&page=1", "gitlab/notes_page1.json")
+	api.reply(http.MethodPost, gitlabTestMr+"/notes", `{"id":204}`)
+	c := newGitlabTestCommenter(t, api)
+
+	if previous, err := c.PreviousSummary(); err != nil || previous != "" {
+		t.Errorf("PreviousSummary = %q, %v, want none", previous, err)
+	}
+	if err := c.WriteSummaryComment(summaryMarker + "\nfirst"); err != nil {
+		t.Fatal(err)
+	}
+	api.sentOnce(http.MethodPost, gitlabTestMr+"/notes")
+}
+
+func TestGitlabCommenterPermissionDenied(t *testing.T) {
+	api := newGitlabTestApi(t)
+	defer api.close()
+	api.handle(http.MethodPost, gitlabTestMr+"/discussions", fakeResponse{status: http.StatusForbidden, body: `{"message":"403 Forbidden"}`})
+	c := newGitlabTestCommenter(t, api)
+
+	if err := c.WriteMultiLineComment("infra/main.tf", testComment("aws-added", "f0f0f0f0"), 2, 2); err != nil {
+		t.Fatal(err)
+	}
+	err := c.SubmitReview("COMMENT")
+	if !isPermissionDenied(err) {
+		t.Errorf("err = %v, want PermissionDeniedError", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type graphqlClient struct {
+	httpClient *http.Client
+	endpoint   string
+}
+
+type graphqlError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+// errGraphqlForbidden is wrapped in the error for a query the token isn't allowed to make
+var errGraphqlForbidden = errors.New("forbidden")
+
+// newGraphqlClient creates a client for the GraphQL API that sits alongside the given REST base url
+func newGraphqlClient(httpClient *http.Client, restBaseURL *url.URL) *graphqlClient {
+	endpoint := *restBaseURL
+	if strings.HasSuffix(endpoint.Path, "/api/v3/") {
+		endpoint.Path = strings.TrimSuffix(endpoint.Path, "v3/") + "graphql"
+	} else {
+		endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/graphql"
+	}
+	return &graphqlClient{
+		httpClient: httpClient,
+		endpoint:   endpoint.String(),
+	}
+}
+
+func (g *graphqlClient) query(ctx context.Context, query string, variables map[string]interface{}, data interface{}) error {
+
+	body, err := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, g.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("graphql request failed with status %d", resp.StatusCode)
+	}
+
+	response := struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []graphqlError  `json:"errors"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return err
+	}
+	if len(response.Errors) > 0 {
+		var messages []string
+		forbidden := false
+		for _, e := range response.Errors {
+			messages = append(messages, e.Message)
+			forbidden = forbidden || e.Type == "FORBIDDEN"
+		}
+		if forbidden {
+			return fmt.Errorf("graphql errors: %s: %w", strings.Join(messages, "; "), errGraphqlForbidden)
+		}
+		return fmt.Errorf("graphql errors: %s", strings.Join(messages, "; "))
+	}
+	if data == nil {
+		return nil
+	}
+	return json.Unmarshal(response.Data, data)
+}
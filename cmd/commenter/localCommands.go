@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runRender writes the comment the template renders for each result that passes the filters to out, for trying
+// out comment templates without a PR. As with a dry run the log goes to stderr
+func runRender(out io.Writer) {
+	logOutput = os.Stderr
+
+	config, err := extractRepositoryConfig()
+	if err != nil {
+		fail(err.Error())
+	}
+
+	settings, err := extractRunSettings(config)
+	if err != nil {
+		fail(err.Error())
+	}
+
+	var prNo int
+	if value := os.Getenv("INPUT_PR_NUMBER"); value != "" {
+		if prNo, err = strconv.Atoi(value); err != nil {
+			fail(fmt.Sprintf("unexpected value for INPUT_PR_NUMBER. Expected a PR number, found %s", value))
+		}
+	}
+
+	settings.renderer, err = newCommentRenderer(os.Getenv("INPUT_COMMENT_TEMPLATE"), extractCommentContext(prNo, gitRevParse("HEAD")))
+	if err != nil {
+		fail(err.Error())
+	}
+
+	results, err := loadResultsFiles(splitList(os.Getenv("INPUT_RESULTS_FILE")), os.Getenv("INPUT_RESULTS_FORMAT"))
+	if err != nil {
+		fail(fmt.Sprintf("failed to load results. %s", err.Error()))
+	}
+
+	for _, result := range results {
+		result.Range.Filename = settings.relativeFilename(result.Range.Filename)
+		if settings.filter.decide(result) == filterDrop {
+			continue
+		}
+		message, err := settings.renderer.render(result)
+		if err != nil {
+			fail(err.Error())
+		}
+		fmt.Fprintf(out, "### %s:%d-%d\n\n%s\n\n", result.Range.Filename, result.Range.StartLine, result.Range.EndLine, message)
+	}
+}
+
+// runValidateConfig checks the config file and every setting that can be checked without GitHub, reporting all
+// of the problems rather than stopping at the first. Unknown keys in the config file are reported with their line
+func runValidateConfig() {
+	var errs []string
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	config, err := extractRepositoryConfig()
+	if err != nil {
+		// the settings from the inputs can still be checked without the file
+		check(err)
+		config = &repositoryConfig{}
+	}
+
+	_, err = extractReviewEvent()
+	check(err)
+	_, err = extractStaleCommentAction()
+	check(err)
+	_, err = extractWorkflowAnnotations()
+	check(err)
+	_, err = extractResultFilter(config.Overrides)
+	check(err)
+	_, err = extractFailurePolicy()
+	check(err)
+	_, err = newCommentRenderer(os.Getenv("INPUT_COMMENT_TEMPLATE"), commentContext{})
+	check(err)
+	if format := strings.ToLower(os.Getenv("INPUT_RESULTS_FORMAT")); format != "" && format != "auto" {
+		_, err = selectResultsAdapter(nil, format)
+		check(err)
+	}
+
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Printf("Error: %s\n", err)
+		}
+		os.Exit(-1)
+	}
+	fmt.Println("Configuration is valid")
+}
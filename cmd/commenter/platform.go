@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// Platforms that can be set in INPUT_PLATFORM
+const (
+	platformGithub          = "github"
+	platformGitlab          = "gitlab"
+	platformBitbucket       = "bitbucket"
+	platformBitbucketServer = "bitbucket-server"
+	platformAzureDevops     = "azure-devops"
+	platformGitea           = "gitea"
+)
+
+// platform is the code host the PR lives on. Everything that renders, filters and decides what to write is
+// shared, each platform only finds the PR and connects a prCommenter to it
+type platform interface {
+	name() string
+	// pullRequestNumber works out which PR to comment on, returning an error when the run isn't for a PR
+	pullRequestNumber() (int, error)
+	// connect loads the PR, its diff and the existing comments
+	connect(prNo int) (prCommenter, error)
+	// commentContext describes the PR for comment templates
+	commentContext(prNo int, sha string) commentContext
+	// readOnly reports whether the token is known to be read-only before anything is written
+	readOnly() bool
+}
+
+// extractPlatform reads INPUT_PLATFORM, otherwise detecting Gitea Actions, GitLab CI, Bitbucket Pipelines and
+// Azure Pipelines and defaulting to GitHub
+func extractPlatform() (platform, error) {
+	name := strings.ToLower(os.Getenv("INPUT_PLATFORM"))
+	if name == "" {
+		name = platformGithub
+		// Gitea Actions sets the GITHUB_* variables as well, so it has to be checked first
+		if os.Getenv("GITEA_ACTIONS") == "true" {
+			name = platformGitea
+		} else if os.Getenv("GITLAB_CI") == "true" {
+			name = platformGitlab
+		} else if os.Getenv("BITBUCKET_BUILD_NUMBER") != "" {
+			name = platformBitbucket
+		} else if strings.EqualFold(os.Getenv("TF_BUILD"), "true") {
+			name = platformAzureDevops
+		}
+	}
+
+	switch name {
+	case platformGithub:
+		return newGithubPlatform()
+	case platformGitlab:
+		return newGitlabPlatform()
+	case platformBitbucket:
+		return newBitbucketPlatform()
+	case platformBitbucketServer:
+		return newBitbucketServerPlatform()
+	case platformAzureDevops:
+		return newAzureDevopsPlatform()
+	case platformGitea:
+		return newGiteaPlatform()
+	}
+	return nil, fmt.Errorf("unexpected value for INPUT_PLATFORM. Expected github, gitlab, bitbucket, bitbucket-server, azure-devops or gitea, found %s", name)
+}
+
+type githubPlatform struct {
+	tokenSource oauth2.TokenSource
+	// app is set when authenticating as a GitHub App rather than with INPUT_GITHUB_TOKEN
+	app   bool
+	owner string
+	repo  string
+}
+
+func newGithubPlatform() (*githubPlatform, error) {
+	githubRepository := os.Getenv("GITHUB_REPOSITORY")
+	split := strings.Split(githubRepository, "/")
+	if len(split) != 2 {
+		return nil, fmt.Errorf("unexpected value for GITHUB_REPOSITORY. Expected <organisation/name>, found %v", split)
+	}
+	logf("Working in repository %s\n", split[1])
+
+	platform := &githubPlatform{
+		owner: split[0],
+		repo:  split[1],
+	}
+	appTokenSource, err := extractGithubAppTokenSource(platform.owner, platform.repo)
+	if err != nil {
+		return nil, err
+	}
+	if appTokenSource != nil {
+		logln("Authenticating as a GitHub App")
+		platform.tokenSource = appTokenSource
+		platform.app = true
+		return platform, nil
+	}
+
+	token := os.Getenv("INPUT_GITHUB_TOKEN")
+	if len(token) == 0 {
+		return nil, fmt.Errorf("the INPUT_GITHUB_TOKEN has not been set, or INPUT_GITHUB_APP_ID to authenticate as a GitHub App")
+	}
+	platform.tokenSource = staticTokenSource(token)
+	return platform, nil
+}
+
+func (p *githubPlatform) name() string {
+	return "GitHub"
+}
+
+func (p *githubPlatform) pullRequestNumber() (int, error) {
+	return extractPullRequestNumber(p.tokenSource, p.owner, p.repo)
+}
+
+func (p *githubPlatform) connect(prNo int) (prCommenter, error) {
+	return createCommenter(p.tokenSource, p.owner, p.repo, prNo)
+}
+
+func (p *githubPlatform) commentContext(prNo int, sha string) commentContext {
+	return extractCommentContext(prNo, sha)
+}
+
+// readOnly is only true for the workflow token, a GitHub App installed on the repository can write to PRs from forks
+func (p *githubPlatform) readOnly() bool {
+	return !p.app && isForkPullRequest()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Exit codes for each outcome of the failure policy. Configuration errors exit with 255 through fail
+const (
+	exitCodeSuccess      = 0
+	exitCodeErrors       = 1
+	exitCodeSeverity     = 2
+	exitCodeDiff         = 3
+	exitCodeChangedLines = 4
+	exitCodeNewFindings  = 5
+)
+
+// Conditions that can be listed in INPUT_FAIL_ON
+const (
+	failOnAny          = "any"
+	failOnDiff         = "diff"
+	failOnChangedLines = "changed_lines"
+	failOnNew          = "new"
+	failOnErrors       = "errors"
+	failOnNone         = "none"
+)
+
+const defaultFailOn = failOnDiff + "," + failOnErrors
+
+type failurePolicy struct {
+	conditions map[string]bool
+	severity   int
+}
+
+type policyDecision struct {
+	result   string
+	exitCode int
+	reason   string
+}
+
+// extractFailurePolicy builds the policy from INPUT_FAIL_ON and INPUT_FAIL_SEVERITY. INPUT_SOFT_FAIL_COMMENTER
+// is still honoured and means only errors fail the build
+func extractFailurePolicy() (*failurePolicy, error) {
+	failOn := os.Getenv("INPUT_FAIL_ON")
+	if failOn == "" {
+		failOn = defaultFailOn
+	}
+
+	conditions := make(map[string]bool)
+	for _, condition := range splitList(strings.ToLower(failOn)) {
+		switch condition {
+		case failOnAny, failOnDiff, failOnChangedLines, failOnNew, failOnErrors:
+			conditions[condition] = true
+		case failOnNone:
+		default:
+			return nil, fmt.Errorf("unexpected value for INPUT_FAIL_ON. Expected any of %s, found %s",
+				strings.Join([]string{failOnAny, failOnDiff, failOnChangedLines, failOnNew, failOnErrors, failOnNone}, ", "), condition)
+		}
+	}
+
+	if softFail, ok := os.LookupEnv("INPUT_SOFT_FAIL_COMMENTER"); ok && strings.ToLower(softFail) == "true" {
+		conditions = map[string]bool{failOnErrors: true}
+	}
+
+	severity, err := parseSeverityInput("INPUT_FAIL_SEVERITY")
+	if err != nil {
+		return nil, err
+	}
+
+	return &failurePolicy{
+		conditions: conditions,
+		severity:   severity,
+	}, nil
+}
+
+// decide works out whether the run should fail. Errors take precedence, then each finding condition from the
+// broadest to the narrowest
+func (p *failurePolicy) decide(findings []summaryFinding, previousSummary string, errCount int) policyDecision {
+	if p.conditions[failOnErrors] && errCount > 0 {
+		return policyDecision{result: "errors", exitCode: exitCodeErrors,
+			reason: fmt.Sprintf("Failing - there were %d errors writing to the PR", errCount)}
+	}
+
+	previous := parseSummaryState(previousSummary)
+
+	var matching, inDiff, changed, added int
+	for _, finding := range findings {
+		if severityRank(finding.Severity) < p.severity {
+			continue
+		}
+		matching++
+		if finding.InDiff {
+			inDiff++
+		}
+		if finding.Changed {
+			changed++
+		}
+		if previous.isNew(finding.key()) {
+			added++
+		}
+	}
+
+	switch {
+	case p.conditions[failOnAny] && matching > 0:
+		return policyDecision{result: "severity", exitCode: exitCodeSeverity,
+			reason: fmt.Sprintf("Failing - %d issues found at or above the fail severity", matching)}
+	case p.conditions[failOnDiff] && inDiff > 0:
+		return policyDecision{result: "diff", exitCode: exitCodeDiff,
+			reason: fmt.Sprintf("Failing - %d issues found in the PR diff", inDiff)}
+	case p.conditions[failOnChangedLines] && changed > 0:
+		return policyDecision{result: "changed_lines", exitCode: exitCodeChangedLines,
+			reason: fmt.Sprintf("Failing - %d issues found on lines changed in the PR", changed)}
+	case p.conditions[failOnNew] && added > 0:
+		return policyDecision{result: "new", exitCode: exitCodeNewFindings,
+			reason: fmt.Sprintf("Failing - %d new issues found since the last run", added)}
+	}
+	return passDecision()
+}
+
+// holdsBack reports whether the finding is new and fails the new condition. Such findings are left out of the
+// state kept in the summary, so the run keeps failing until they are fixed rather than passing when re-run
+func (p *failurePolicy) holdsBack(finding summaryFinding, previous summaryState) bool {
+	return p != nil && p.conditions[failOnNew] && severityRank(finding.Severity) >= p.severity && previous.isNew(finding.key())
+}
+
+// checkSummaryComment rejects the new condition when there is no summary comment to keep the previous findings in
+func (p *failurePolicy) checkSummaryComment(summaryComment bool) error {
+	if p.conditions[failOnNew] && !summaryComment {
+		return fmt.Errorf("unexpected value for INPUT_FAIL_ON. The %s condition compares with the findings kept in the summary comment, which needs INPUT_SUMMARY_COMMENT to be true", failOnNew)
+	}
+	return nil
+}
+
+func passDecision() policyDecision {
+	return policyDecision{result: "pass", exitCode: exitCodeSuccess, reason: "Passing - no issues matched the failure policy"}
+}
+
+// writeActionOutputs exposes the decision as the commenter-result and commenter-exit-code action outputs
+func writeActionOutputs(decision policyDecision) error {
+	outputPath := os.Getenv("GITHUB_OUTPUT")
+	if outputPath == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(outputPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	_, err = fmt.Fprintf(file, "commenter-result=%s\ncommenter-exit-code=%d\n", decision.result, decision.exitCode)
+	return err
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// testPolicy builds the policy for the fail_on conditions and fail_severity inputs
+func testPolicy(t *testing.T, failOn, failSeverity string) *failurePolicy {
+	t.Helper()
+	_ = os.Setenv("INPUT_FAIL_ON", failOn)
+	_ = os.Setenv("INPUT_FAIL_SEVERITY", failSeverity)
+	defer os.Unsetenv("INPUT_FAIL_ON")
+	defer os.Unsetenv("INPUT_FAIL_SEVERITY")
+
+	policy, err := extractFailurePolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func testPolicyFinding(fingerprint, severity string, inDiff, changed bool) summaryFinding {
+	return summaryFinding{
+		Fingerprint: fingerprint,
+		RuleID:      "aws-s3-enable-bucket-encryption",
+		Scanner:     "tfsec",
+		Severity:    severity,
+		Filename:    "infra/main.tf",
+		StartLine:   2,
+		EndLine:     2,
+		InDiff:      inDiff,
+		Changed:     changed,
+	}
+}
+
+func TestFailurePolicyDecide(t *testing.T) {
+	outside := testPolicyFinding("aaaa1111aaaa1111", "HIGH", false, false)
+	inDiff := testPolicyFinding("bbbb2222bbbb2222", "HIGH", true, false)
+	changed := testPolicyFinding("cccc3333cccc3333", "HIGH", true, true)
+	lowChanged := testPolicyFinding("dddd4444dddd4444", "LOW", true, true)
+	// the previous run reported every finding but lowChanged
+	previous := generateSummary([]summaryFinding{outside, inDiff, changed}, "", nil)
+
+	tests := []struct {
+		name         string
+		failOn       string
+		failSeverity string
+		findings     []summaryFinding
+		previous     string
+		errCount     int
+		exitCode     int
+		result       string
+	}{
+		{name: "default without findings", findings: nil, exitCode: exitCodeSuccess, result: "pass"},
+		{name: "default with findings outside the diff", findings: []summaryFinding{outside}, exitCode: exitCodeSuccess, result: "pass"},
+		{name: "default with findings in the diff", findings: []summaryFinding{outside, inDiff}, exitCode: exitCodeDiff, result: "diff"},
+		{name: "default with errors", findings: []summaryFinding{inDiff}, errCount: 1, exitCode: exitCodeErrors, result: "errors"},
+		{name: "errors not listed", failOn: "diff", errCount: 2, exitCode: exitCodeSuccess, result: "pass"},
+		{name: "any", failOn: "any", findings: []summaryFinding{outside}, exitCode: exitCodeSeverity, result: "severity"},
+		{name: "any before diff", failOn: "diff,any", findings: []summaryFinding{inDiff}, exitCode: exitCodeSeverity, result: "severity"},
+		{name: "any below the severity", failOn: "any", failSeverity: "CRITICAL", findings: []summaryFinding{outside, changed}, exitCode: exitCodeSuccess, result: "pass"},
+		{name: "changed lines without changes", failOn: "changed_lines", findings: []summaryFinding{outside, inDiff}, exitCode: exitCodeSuccess, result: "pass"},
+		{name: "changed lines", failOn: "changed_lines", findings: []summaryFinding{inDiff, changed}, exitCode: exitCodeChangedLines, result: "changed_lines"},
+		{name: "changed lines below the severity", failOn: "changed_lines", failSeverity: "medium", findings: []summaryFinding{lowChanged}, exitCode: exitCodeSuccess, result: "pass"},
+		{name: "new on the first run", failOn: "new", findings: []summaryFinding{outside}, exitCode: exitCodeNewFindings, result: "new"},
+		{name: "nothing new", failOn: "new", findings: []summaryFinding{outside, changed}, previous: previous, exitCode: exitCodeSuccess, result: "pass"},
+		{name: "new since the previous run", failOn: "new", findings: []summaryFinding{outside, lowChanged}, previous: previous, exitCode: exitCodeNewFindings, result: "new"},
+		{name: "none", failOn: "none", findings: []summaryFinding{changed}, errCount: 1, exitCode: exitCodeSuccess, result: "pass"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := testPolicy(t, tt.failOn, tt.failSeverity).decide(tt.findings, tt.previous, tt.errCount)
+			if decision.exitCode != tt.exitCode || decision.result != tt.result || decision.reason == "" {
+				t.Errorf("decision = %+v, want %s with exit code %d", decision, tt.result, tt.exitCode)
+			}
+		})
+	}
+}
+
+func TestSoftFailOnlyFailsOnErrors(t *testing.T) {
+	_ = os.Setenv("INPUT_SOFT_FAIL_COMMENTER", "true")
+	defer os.Unsetenv("INPUT_SOFT_FAIL_COMMENTER")
+
+	policy := testPolicy(t, "any,new", "")
+	if decision := policy.decide([]summaryFinding{testPolicyFinding("aaaa1111aaaa1111", "CRITICAL", true, true)}, "", 0); decision.exitCode != exitCodeSuccess {
+		t.Errorf("decision = %+v, findings should not fail the build", decision)
+	}
+	if decision := policy.decide(nil, "", 1); decision.exitCode != exitCodeErrors {
+		t.Errorf("decision = %+v, errors should fail the build", decision)
+	}
+}
+
+func TestExtractFailurePolicyRejectsUnknownCondition(t *testing.T) {
+	_ = os.Setenv("INPUT_FAIL_ON", "diff,everything")
+	defer os.Unsetenv("INPUT_FAIL_ON")
+	if _, err := extractFailurePolicy(); err == nil {
+		t.Errorf("an unknown condition should be rejected")
+	}
+}
+
+func TestFailurePolicyHoldsBack(t *testing.T) {
+	known := testPolicyFinding("aaaa1111aaaa1111", "HIGH", true, true)
+	added := testPolicyFinding("bbbb2222bbbb2222", "HIGH", true, true)
+	addedLow := testPolicyFinding("cccc3333cccc3333", "LOW", true, true)
+	previous := parseSummaryState(generateSummary([]summaryFinding{known}, "", nil))
+
+	tests := []struct {
+		name     string
+		policy   *failurePolicy
+		finding  summaryFinding
+		previous summaryState
+		want     bool
+	}{
+		{name: "new finding", policy: testPolicy(t, "new", "MEDIUM"), finding: added, previous: previous, want: true},
+		{name: "known finding", policy: testPolicy(t, "new", "MEDIUM"), finding: known, previous: previous},
+		{name: "below the severity", policy: testPolicy(t, "new", "MEDIUM"), finding: addedLow, previous: previous},
+		{name: "new not listed", policy: testPolicy(t, "diff,errors", ""), finding: added, previous: previous},
+		// nothing is new after a state that left findings out
+		{name: "truncated state", policy: testPolicy(t, "new", ""), finding: added, previous: summaryState{keys: previous.keys, truncated: true}},
+		{name: "no policy", finding: added, previous: previous},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.holdsBack(tt.finding, tt.previous); got != tt.want {
+				t.Errorf("holdsBack = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailurePolicyCheckSummaryComment(t *testing.T) {
+	tests := []struct {
+		failOn         string
+		summaryComment bool
+		valid          bool
+	}{
+		{failOn: "", summaryComment: false, valid: true},
+		{failOn: "any,changed_lines,errors", summaryComment: false, valid: true},
+		{failOn: "new", summaryComment: true, valid: true},
+		{failOn: "new", summaryComment: false, valid: false},
+		{failOn: "diff,new", summaryComment: false, valid: false},
+	}
+	for _, tt := range tests {
+		err := testPolicy(t, tt.failOn, "").checkSummaryComment(tt.summaryComment)
+		if (err == nil) != tt.valid {
+			t.Errorf("checkSummaryComment for %q with summary %t = %v, want valid %t", tt.failOn, tt.summaryComment, err, tt.valid)
+		}
+	}
+}
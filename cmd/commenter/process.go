@@ -0,0 +1,261 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// prCommenter is what processResults needs from the PR, implemented by the GitHub Commenter, by a commenter for
+// each of the other platforms and by the local dryRunCommenter
+type prCommenter interface {
+	HeadSHA() string
+	InDiff(file string, startLine, endLine int) bool
+	InChangedLines(file string, startLine, endLine int) bool
+	WriteMultiLineComment(file, comment string, startLine, endLine int) error
+	PendingComments() int
+	SubmitReview(event string) error
+	CleanupStaleComments(current []findingComment, scope, action string, reply bool) (int, error)
+	PreviousSummary() (string, error)
+	WriteSummaryComment(comment string) error
+}
+
+// runSettings holds the inputs that control how results are turned into comments
+type runSettings struct {
+	workspacePath  string
+	workingDir     string
+	reviewEvent    string
+	staleAction    string
+	replyOnFix     bool
+	summaryComment bool
+	checkRun       bool
+	// workflowAnnotations is when to print workflow commands for each result, see extractWorkflowAnnotations
+	workflowAnnotations string
+	// readOnly is set when the token is known to be read-only before anything is written
+	readOnly bool
+	// inlineComments and cleanupComments are only turned off by the summary and cleanup commands
+	inlineComments  bool
+	cleanupComments bool
+	config          *repositoryConfig
+	filter          *resultFilter
+	renderer        *commentRenderer
+	// policy decides which new findings are kept out of the summary state, it is nil when nothing is held back
+	policy *failurePolicy
+}
+
+// skippedResult records a result that wasn't written as a comment and why
+type skippedResult struct {
+	RuleID    string `json:"rule_id"`
+	Filename  string `json:"filename"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Reason    string `json:"reason"`
+}
+
+// runReport is everything processResults did, for deciding the outcome and for the dry run output
+type runReport struct {
+	// results are those that passed the filters, with their filenames relative to the repository root
+	results         []result
+	findings        []summaryFinding
+	skipped         []skippedResult
+	errMessages     []string
+	previousSummary string
+	summary         string
+	// readOnly is set when writing to the PR was refused, after which nothing else is written
+	readOnly bool
+}
+
+// extractRunSettings reads every input used by processResults apart from the comment template, which needs
+// the PR to render
+func extractRunSettings(config *repositoryConfig) (*runSettings, error) {
+	reviewEvent, err := extractReviewEvent()
+	if err != nil {
+		return nil, err
+	}
+	staleAction, err := extractStaleCommentAction()
+	if err != nil {
+		return nil, err
+	}
+	workflowAnnotations, err := extractWorkflowAnnotations()
+	if err != nil {
+		return nil, err
+	}
+	filter, err := extractResultFilter(config.Overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace := os.Getenv("GITHUB_WORKSPACE")
+	if workspace == "" {
+		if workspace, err = os.Getwd(); err != nil {
+			return nil, err
+		}
+	}
+	workspacePath := strings.TrimSuffix(workspace, "/") + "/"
+	logf("Working in GITHUB_WORKSPACE %s\n", workspacePath)
+
+	workingDir := os.Getenv("INPUT_WORKING_DIRECTORY")
+	if workingDir != "" {
+		workingDir = strings.TrimPrefix(workingDir, "./")
+		workingDir = strings.TrimSuffix(workingDir, "/") + "/"
+	}
+
+	return &runSettings{
+		workspacePath:       workspacePath,
+		workingDir:          workingDir,
+		reviewEvent:         reviewEvent,
+		staleAction:         staleAction,
+		replyOnFix:          strings.ToLower(os.Getenv("INPUT_REPLY_ON_FIX")) == "true",
+		summaryComment:      strings.ToLower(os.Getenv("INPUT_SUMMARY_COMMENT")) == "true",
+		checkRun:            strings.ToLower(os.Getenv("INPUT_CHECK_RUN")) == "true",
+		workflowAnnotations: workflowAnnotations,
+		inlineComments:      true,
+		cleanupComments:     true,
+		config:              config,
+		filter:              filter,
+	}, nil
+}
+
+// relativeFilename makes a filename from the results relative to the repository root, using the path mappings
+// from the config file before the workspace
+func (s *runSettings) relativeFilename(filename string) string {
+	if mapped, ok := s.config.mapPath(filename); ok {
+		return mapped
+	}
+	return s.workingDir + strings.ReplaceAll(filename, s.workspacePath, "")
+}
+
+// extractCommentContext describes the PR for the comment template from the GITHUB_* variables
+func extractCommentContext(prNo int, sha string) commentContext {
+	repository := os.Getenv("GITHUB_REPOSITORY")
+	var owner, repo string
+	if split := strings.Split(repository, "/"); len(split) == 2 {
+		owner, repo = split[0], split[1]
+	}
+
+	serverUrl := os.Getenv("GITHUB_SERVER_URL")
+	if serverUrl == "" {
+		serverUrl = "https://github.com"
+	}
+
+	return commentContext{
+		Owner:       owner,
+		Repo:        repo,
+		Repository:  repository,
+		PullRequest: prNo,
+		ServerURL:   serverUrl,
+		SHA:         sha,
+	}
+}
+
+// processResults comments on each result that passes the filters, submits the review, cleans up the comments
+// for fixed issues and writes the summary
+func processResults(c prCommenter, results []result, settings *runSettings) runReport {
+	report := runReport{readOnly: settings.readOnly}
+	var currentComments []findingComment
+	for _, result := range results {
+		result.Range.Filename = settings.relativeFilename(result.Range.Filename)
+		result.Fingerprint = generateFingerprint(result)
+
+		// results dropped by the filters are never rendered, so a template they break doesn't fail the run
+		outcome := settings.filter.decide(result)
+		if outcome == filterDrop {
+			logf("Ignoring - rule %v in %v excluded by filters\n", result.RuleID, result.Range.Filename)
+			report.skip(result, "excluded by filters")
+			continue
+		}
+
+		message, err := settings.renderer.render(result)
+		if err != nil {
+			report.errMessages = append(report.errMessages, err.Error())
+			continue
+		}
+		comment := appendFingerprint(message, result.Fingerprint)
+		currentComments = append(currentComments, findingComment{filename: result.Range.Filename, comment: comment})
+		report.results = append(report.results, result)
+
+		inDiff := c.InDiff(result.Range.Filename, result.Range.StartLine, result.Range.EndLine)
+		if !settings.inlineComments {
+			report.skip(result, "inline comments are turned off")
+		} else if report.readOnly {
+			report.skip(result, "the token can't write to the PR")
+		} else if outcome == filterSummary {
+			logf("Summarising - rule %v in %v is below the comment severity\n", result.RuleID, result.Range.Filename)
+			report.skip(result, "below the comment severity")
+		} else {
+			logf("Preparing comment for violation of rule %v in %v\n", result.RuleID, result.Range.Filename)
+			err := c.WriteMultiLineComment(result.Range.Filename, comment, result.Range.StartLine, result.Range.EndLine)
+			if err != nil {
+				// don't error if its simply that the comments aren't valid for the PR
+				switch err.(type) {
+				case CommentAlreadyWrittenError:
+					logln("Ignoring - comment already written")
+					report.skip(result, "comment already written")
+				case CommentNotValidError:
+					logln("Ignoring - change not part of the current PR")
+					report.skip(result, "change not part of the current PR")
+				default:
+					report.errMessages = append(report.errMessages, err.Error())
+				}
+			} else {
+				logf("Commenting for %s to %s:%d:%d\n", result.Description, result.Range.Filename, result.Range.StartLine, result.Range.EndLine)
+			}
+		}
+
+		finding := newSummaryFinding(result, inDiff)
+		finding.Changed = c.InChangedLines(result.Range.Filename, result.Range.StartLine, result.Range.EndLine)
+		report.findings = append(report.findings, finding)
+	}
+
+	if c.PendingComments() > 0 {
+		logf("Submitting review with %d comments\n", c.PendingComments())
+		report.writeFailed(settings, c.SubmitReview(settings.reviewEvent))
+	}
+
+	if settings.cleanupComments && !report.readOnly {
+		// only the comments under the working directory are covered by this run, others may belong to another job
+		cleaned, err := c.CleanupStaleComments(currentComments, settings.workingDir, settings.staleAction, settings.replyOnFix)
+		report.writeFailed(settings, err)
+		if cleaned > 0 {
+			logf("Cleaned up %d comments for issues that have been fixed\n", cleaned)
+		}
+	}
+
+	var err error
+	report.previousSummary, err = c.PreviousSummary()
+	if err != nil {
+		report.errMessages = append(report.errMessages, err.Error())
+	}
+
+	if settings.summaryComment && !report.readOnly {
+		logln("Writing summary comment")
+		report.summary = generateSummary(report.findings, report.previousSummary, settings.policy)
+		report.writeFailed(settings, c.WriteSummaryComment(report.summary))
+	}
+	return report
+}
+
+// writeFailed records an error writing to the PR. A read-only token isn't an error unless workflow annotations
+// are turned off, instead the run stops writing and falls back to them
+func (r *runReport) writeFailed(settings *runSettings, err error) {
+	if err == nil {
+		return
+	}
+	if settings.workflowAnnotations != workflowAnnotationsNever && isPermissionDenied(err) {
+		if !r.readOnly {
+			logln("The token can't write to the PR, falling back to workflow annotations")
+		}
+		r.readOnly = true
+		return
+	}
+	r.errMessages = append(r.errMessages, err.Error())
+}
+
+func (r *runReport) skip(result result, reason string) {
+	r.skipped = append(r.skipped, skippedResult{
+		RuleID:    result.RuleID,
+		Filename:  result.Range.Filename,
+		StartLine: result.Range.StartLine,
+		EndLine:   result.Range.EndLine,
+		Reason:    reason,
+	})
+}
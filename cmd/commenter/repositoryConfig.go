@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultConfigFile = ".tfsec-commenter.yml"
+
+var (
+	unknownFieldRegex = regexp.MustCompile(`field (\S+) not found in type \S+`)
+	errorLineRegex    = regexp.MustCompile(`^line (\d+):`)
+)
+
+// repositoryConfig is the .tfsec-commenter.yml file in the root of the repository. Every setting in it is
+// overridden by the matching input
+type repositoryConfig struct {
+	MinimumSeverity     configString        `yaml:"minimum_severity"`
+	CommentSeverity     configString        `yaml:"comment_severity"`
+	IncludeRules        configList          `yaml:"include_rules"`
+	ExcludeRules        configList          `yaml:"exclude_rules"`
+	IncludePaths        configList          `yaml:"include_paths"`
+	ExcludePaths        configList          `yaml:"exclude_paths"`
+	CommentTemplate     configString        `yaml:"comment_template"`
+	ReviewEvent         configString        `yaml:"review_event"`
+	StaleComments       configString        `yaml:"stale_comments"`
+	ReplyOnFix          configString        `yaml:"reply_on_fix"`
+	SummaryComment      configString        `yaml:"summary_comment"`
+	CheckRun            configString        `yaml:"check_run"`
+	WorkflowAnnotations configString        `yaml:"workflow_annotations"`
+	FailOn              configList          `yaml:"fail_on"`
+	FailSeverity        configString        `yaml:"fail_severity"`
+	PathMappings        []pathMapping       `yaml:"path_mappings"`
+	Overrides           []directoryOverride `yaml:"overrides"`
+
+	path string
+}
+
+// pathMapping rewrites the start of filenames in the results, for scanners that ran somewhere the repository
+// was checked out at a different path. To is relative to the repository root
+type pathMapping struct {
+	From configString `yaml:"from"`
+	To   configString `yaml:"to"`
+}
+
+// directoryOverride changes the filters for results in files matching the path glob
+type directoryOverride struct {
+	Path            configString `yaml:"path"`
+	MinimumSeverity configString `yaml:"minimum_severity"`
+	CommentSeverity configString `yaml:"comment_severity"`
+	IncludeRules    configList   `yaml:"include_rules"`
+	ExcludeRules    configList   `yaml:"exclude_rules"`
+}
+
+// configString is a scalar from the config file that remembers its line, for reporting invalid values
+type configString struct {
+	value string
+	line  int
+}
+
+// configList is a list from the config file, written either as a YAML sequence or as a comma separated string
+type configList struct {
+	values []string
+	line   int
+}
+
+func (s *configString) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return configTypeError(node, "expected a single value")
+	}
+	s.value, s.line = node.Value, node.Line
+	return nil
+}
+
+func (l *configList) UnmarshalYAML(node *yaml.Node) error {
+	l.line = node.Line
+	switch node.Kind {
+	case yaml.ScalarNode:
+		l.values = splitList(node.Value)
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return configTypeError(item, "expected a list of values")
+			}
+			l.values = append(l.values, item.Value)
+		}
+	default:
+		return configTypeError(node, "expected a list of values")
+	}
+	return nil
+}
+
+// configTypeError is collected by the decoder along with any unknown keys, so every problem is reported at once
+func configTypeError(node *yaml.Node, message string) error {
+	return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: %s", node.Line, message)}}
+}
+
+// configFilePath is INPUT_CONFIG_FILE, or .tfsec-commenter.yml in the root of the workspace
+func configFilePath() string {
+	if path := os.Getenv("INPUT_CONFIG_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(os.Getenv("GITHUB_WORKSPACE"), defaultConfigFile)
+}
+
+// loadRepositoryConfig reads the config file. A missing file is only an error when it was asked for by name
+func loadRepositoryConfig() (*repositoryConfig, error) {
+	path := configFilePath()
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && os.Getenv("INPUT_CONFIG_FILE") == "" {
+		return &repositoryConfig{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	logf("Using config file %s\n", path)
+	return parseRepositoryConfig(path, content)
+}
+
+// extractRepositoryConfig loads the config file and sets the inputs it has values for. It has to be called
+// before the settings are read from the inputs
+func extractRepositoryConfig() (*repositoryConfig, error) {
+	config, err := loadRepositoryConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.applyToEnv()
+	return config, nil
+}
+
+// parseRepositoryConfig decodes the file, rejecting unknown keys, and checks every value it can
+func parseRepositoryConfig(path string, content []byte) (*repositoryConfig, error) {
+	config := &repositoryConfig{path: path}
+
+	var errs []string
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && err != io.EOF {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, typeErr := range typeErr.Errors {
+			errs = append(errs, unknownFieldRegex.ReplaceAllString(typeErr, "unknown key $1"))
+		}
+	}
+	errs = append(errs, config.validate()...)
+
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errorLine(errs[i]) < errorLine(errs[j]) })
+		for i := range errs {
+			errs[i] = fmt.Sprintf("%s: %s", path, errs[i])
+		}
+		return nil, fmt.Errorf("invalid config file\n%s", strings.Join(errs, "\n"))
+	}
+	return config, nil
+}
+
+func (c *repositoryConfig) validate() []string {
+	var errs []string
+	invalid := func(line int, format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf("line %d: %s", line, fmt.Sprintf(format, args...)))
+	}
+	checkSeverity := func(key string, s configString) {
+		if s.value != "" && severityRanks[strings.ToUpper(s.value)] == 0 {
+			invalid(s.line, "unexpected value for %s. Expected one of LOW, MEDIUM, HIGH or CRITICAL, found %s", key, s.value)
+		}
+	}
+	checkChoice := func(key string, s configString, choices ...string) {
+		if s.value != "" && !containsString(choices, strings.ToLower(s.value)) {
+			invalid(s.line, "unexpected value for %s. Expected one of %s, found %s", key, strings.Join(choices, ", "), s.value)
+		}
+	}
+	checkGlobs := func(key string, l configList) {
+		if _, err := compileGlobs(l.values); err != nil {
+			invalid(l.line, "%s: %s", key, err.Error())
+		}
+	}
+
+	checkSeverity("minimum_severity", c.MinimumSeverity)
+	checkSeverity("comment_severity", c.CommentSeverity)
+	checkSeverity("fail_severity", c.FailSeverity)
+	checkGlobs("include_paths", c.IncludePaths)
+	checkGlobs("exclude_paths", c.ExcludePaths)
+	checkChoice("review_event", c.ReviewEvent, "comment", "request_changes")
+	checkChoice("stale_comments", c.StaleComments, staleActionResolve, staleActionMinimize, staleActionNone)
+	checkChoice("reply_on_fix", c.ReplyOnFix, "true", "false")
+	checkChoice("summary_comment", c.SummaryComment, "true", "false")
+	checkChoice("check_run", c.CheckRun, "true", "false")
+	checkChoice("workflow_annotations", c.WorkflowAnnotations, workflowAnnotationsAuto, workflowAnnotationsAlways, workflowAnnotationsNever)
+	for _, condition := range c.FailOn.values {
+		checkChoice("fail_on", configString{value: condition, line: c.FailOn.line},
+			failOnAny, failOnDiff, failOnChangedLines, failOnNew, failOnErrors, failOnNone)
+	}
+
+	for _, mapping := range c.PathMappings {
+		if mapping.From.value == "" {
+			invalid(firstLine(mapping.From.line, mapping.To.line), "path_mappings need a from path")
+		}
+	}
+
+	for _, override := range c.Overrides {
+		if override.Path.value == "" {
+			invalid(firstLine(override.MinimumSeverity.line, override.CommentSeverity.line,
+				override.IncludeRules.line, override.ExcludeRules.line), "overrides need a path")
+		} else if _, err := compileGlob(override.Path.value); err != nil {
+			invalid(override.Path.line, "invalid override path %s: %s", override.Path.value, err.Error())
+		}
+		checkSeverity("minimum_severity", override.MinimumSeverity)
+		checkSeverity("comment_severity", override.CommentSeverity)
+	}
+	return errs
+}
+
+// errorLine is the line an error from the config file is about, so they can be reported in order
+func errorLine(err string) int {
+	groups := errorLineRegex.FindStringSubmatch(err)
+	if len(groups) < 2 {
+		return 0
+	}
+	line, _ := strconv.Atoi(groups[1])
+	return line
+}
+
+// firstLine is the earliest of the lines a value was found on, for reporting a missing key
+func firstLine(lines ...int) int {
+	first := 0
+	for _, line := range lines {
+		if line > 0 && (first == 0 || line < first) {
+			first = line
+		}
+	}
+	return first
+}
+
+// applyToEnv sets the input for every setting in the file, unless the input was given
+func (c *repositoryConfig) applyToEnv() {
+	settings := []struct {
+		env   string
+		value string
+	}{
+		{"INPUT_MINIMUM_SEVERITY", c.MinimumSeverity.value},
+		{"INPUT_COMMENT_SEVERITY", c.CommentSeverity.value},
+		{"INPUT_INCLUDE_RULES", strings.Join(c.IncludeRules.values, ",")},
+		{"INPUT_EXCLUDE_RULES", strings.Join(c.ExcludeRules.values, ",")},
+		{"INPUT_INCLUDE_PATHS", strings.Join(c.IncludePaths.values, ",")},
+		{"INPUT_EXCLUDE_PATHS", strings.Join(c.ExcludePaths.values, ",")},
+		{"INPUT_COMMENT_TEMPLATE", c.commentTemplatePath()},
+		{"INPUT_REVIEW_EVENT", c.ReviewEvent.value},
+		{"INPUT_STALE_COMMENTS", c.StaleComments.value},
+		{"INPUT_REPLY_ON_FIX", c.ReplyOnFix.value},
+		{"INPUT_SUMMARY_COMMENT", c.SummaryComment.value},
+		{"INPUT_CHECK_RUN", c.CheckRun.value},
+		{"INPUT_WORKFLOW_ANNOTATIONS", c.WorkflowAnnotations.value},
+		{"INPUT_FAIL_ON", strings.Join(c.FailOn.values, ",")},
+		{"INPUT_FAIL_SEVERITY", c.FailSeverity.value},
+	}
+	for _, setting := range settings {
+		if setting.value != "" && os.Getenv(setting.env) == "" {
+			_ = os.Setenv(setting.env, setting.value)
+		}
+	}
+}
+
+// commentTemplatePath resolves the template relative to the config file, which is in the repository root
+func (c *repositoryConfig) commentTemplatePath() string {
+	template := c.CommentTemplate.value
+	if template == "" || filepath.IsAbs(template) {
+		return template
+	}
+	return filepath.Join(filepath.Dir(c.path), template)
+}
+
+// mapPath rewrites the filename with the first path mapping that matches it. From has to match whole directories,
+// so /src doesn't match /src-old/main.tf
+func (c *repositoryConfig) mapPath(filename string) (string, bool) {
+	for _, mapping := range c.PathMappings {
+		from := strings.TrimSuffix(mapping.From.value, "/")
+		if filename == from || strings.HasPrefix(filename, from+"/") {
+			mapped := strings.TrimPrefix(strings.TrimPrefix(filename, from), "/")
+			if to := strings.TrimSuffix(strings.TrimPrefix(mapping.To.value, "./"), "/"); to != "" && to != "." {
+				mapped = to + "/" + mapped
+			}
+			return mapped, true
+		}
+	}
+	return filename, false
+}
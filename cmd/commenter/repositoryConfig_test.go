@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestMapPath(t *testing.T) {
+	config := &repositoryConfig{PathMappings: []pathMapping{
+		{From: configString{value: "/src/"}, To: configString{value: "infra"}},
+		{From: configString{value: "/build"}, To: configString{value: "./"}},
+		{From: configString{value: "/work/app"}, To: configString{value: "./app/"}},
+	}}
+
+	tests := []struct {
+		filename string
+		mapped   string
+		ok       bool
+	}{
+		{filename: "/src/main.tf", mapped: "infra/main.tf", ok: true},
+		{filename: "/src/modules/vpc/main.tf", mapped: "infra/modules/vpc/main.tf", ok: true},
+		{filename: "/src-old/main.tf", mapped: "/src-old/main.tf", ok: false},
+		{filename: "/build/main.tf", mapped: "main.tf", ok: true},
+		{filename: "/builder/main.tf", mapped: "/builder/main.tf", ok: false},
+		{filename: "/work/app/main.tf", mapped: "app/main.tf", ok: true},
+		{filename: "/work/application/main.tf", mapped: "/work/application/main.tf", ok: false},
+		{filename: "main.tf", mapped: "main.tf", ok: false},
+	}
+	for _, test := range tests {
+		mapped, ok := config.mapPath(test.filename)
+		if mapped != test.mapped || ok != test.ok {
+			t.Errorf("mapPath(%q) = %q, %v, want %q, %v", test.filename, mapped, ok, test.mapped, test.ok)
+		}
+	}
+}
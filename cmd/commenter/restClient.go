@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// restTimeout is how long a single API call can take, so a hung platform fails the run rather than stalling the job
+const restTimeout = 60 * time.Second
+
+// restHttpClient is shared by every platform without a vendored client
+var restHttpClient = &http.Client{Timeout: restTimeout}
+
+// restClient calls the JSON REST APIs of the platforms without a vendored client, such as GitLab
+type restClient struct {
+	httpClient *http.Client
+	baseURL    string
+	header     http.Header
+	// owner, repo and prNumber describe the PR in errors
+	owner    string
+	repo     string
+	prNumber int
+}
+
+// restStatusError is returned for any response outside 2xx
+type restStatusError struct {
+	method     string
+	path       string
+	statusCode int
+	message    string
+}
+
+func (e restStatusError) Error() string {
+	return fmt.Sprintf("%s %s failed with status %d: %s", e.method, e.path, e.statusCode, e.message)
+}
+
+// do sends the body as JSON and decodes the response into data, when either is given
+func (c *restClient) do(method, path string, body, data interface{}) (http.Header, error) {
+
+	resp, err := c.send(method, path, body, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if data == nil {
+		return resp.Header, nil
+	}
+	return resp.Header, json.NewDecoder(resp.Body).Decode(data)
+}
+
+// getText returns the response as text, for APIs that return a raw diff
+func (c *restClient) getText(path string) (string, error) {
+
+	resp, err := c.send(http.MethodGet, path, nil, "text/plain")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	return string(content), err
+}
+
+// send makes the request, where the path is either relative to the base url or a full url given by the API
+// for the next page. A 403 is returned as a PermissionDeniedError so read-only tokens are handled the same way on
+// every platform
+func (c *restClient) send(method, path string, body interface{}, accept string) (*http.Response, error) {
+
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(content)
+	}
+
+	endpoint := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		endpoint = strings.TrimSuffix(c.baseURL, "/") + path
+	}
+	req, err := http.NewRequest(method, endpoint, reader)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", accept)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return resp, nil
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusForbidden {
+		return nil, newPermissionDeniedError(c.owner, c.repo, c.prNumber)
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, restStatusError{method: method, path: path, statusCode: resp.StatusCode, message: strings.TrimSpace(string(message))}
+}
+
+// isNotFound reports whether the error is a 404 from a REST API
+func isNotFound(err error) bool {
+	statusErr, ok := err.(restStatusError)
+	return ok && statusErr.statusCode == http.StatusNotFound
+}
@@ -2,7 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 type checkRange struct {
@@ -13,25 +17,240 @@ type checkRange struct {
 
 type result struct {
 	RuleID          string      `json:"long_id"`
+	RuleShortID     string      `json:"rule_id"`
 	RuleDescription string      `json:"rule_description"`
 	RuleProvider    string      `json:"rule_provider"`
+	RuleService     string      `json:"rule_service"`
+	Impact          string      `json:"impact"`
+	Resolution      string      `json:"resolution"`
 	Links           []string    `json:"links"`
 	Range           *checkRange `json:"location"`
 	Description     string      `json:"description"`
 	RangeAnnotation string      `json:"-"`
 	Severity        string      `json:"severity"`
+	Resource        string      `json:"resource"`
+	Scanner         string      `json:"-"`
+	Sources         []string    `json:"-"`
+	Fingerprint     string      `json:"-"`
 }
 
-const resultsFile = "results.json"
+const defaultResultsFile = "results.json"
 
-func loadResultsFile() ([]result, error) {
-	results := struct{ Results []result }{}
+// resultsAdapter turns the output of a scanner into results
+type resultsAdapter interface {
+	// format is the name used to select the adapter with INPUT_RESULTS_FORMAT
+	format() string
+	// detect reports whether the content looks like the output this adapter understands
+	detect(content []byte) bool
+	parse(content []byte) ([]result, error)
+}
+
+// resultsAdapters are tried in order when detecting the format, tfsec is last as it is the most lenient
+var resultsAdapters = []resultsAdapter{
+	sarifAdapter{},
+	trivyAdapter{},
+	checkovAdapter{},
+	tflintAdapter{},
+	tfsecAdapter{},
+}
+
+// loadResultsFiles reads and merges the results from every file matching the paths and globs, or results.json
+// if there are none. An empty format detects the format of each file
+func loadResultsFiles(patterns []string, format string) ([]result, error) {
+	if len(patterns) == 0 {
+		patterns = []string{defaultResultsFile}
+	}
+
+	var resultsFiles []string
+	for _, pattern := range patterns {
+		matches, err := expandGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			logf("Warning: no results files match %s\n", pattern)
+		}
+		resultsFiles = append(resultsFiles, matches...)
+	}
+	if len(resultsFiles) == 0 {
+		return nil, fmt.Errorf("no results files found")
+	}
+
+	var results []result
+	for _, resultsFile := range resultsFiles {
+		file, err := ioutil.ReadFile(resultsFile)
+		if err != nil {
+			return nil, err
+		}
+		fileResults, err := parseResults(file, format)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", resultsFile, err)
+		}
+		logf("Loaded %d results from %s\n", len(fileResults), resultsFile)
+		warned := make(map[string]bool)
+		for i := range fileResults {
+			fileResults[i].Sources = []string{resultsFile}
+			severity, known := normaliseSeverity(fileResults[i].Severity)
+			if !known && !warned[fileResults[i].Severity] {
+				warned[fileResults[i].Severity] = true
+				logf("Warning: unknown severity [%s] in %s, treating it as %s\n", fileResults[i].Severity, resultsFile, severity)
+			}
+			fileResults[i].Severity = severity
+		}
+		results = append(results, fileResults...)
+	}
+	return mergeResults(results), nil
+}
+
+// mergeResults removes duplicate results for the same rule, file and range, keeping track of every results file
+// each was reported in
+func mergeResults(results []result) []result {
+	var merged []result
+	seen := make(map[string]int)
+	for _, r := range results {
+		key := fmt.Sprintf("%s:%s:%d:%d", r.RuleID, r.Range.Filename, r.Range.StartLine, r.Range.EndLine)
+		if i, ok := seen[key]; ok {
+			for _, source := range r.Sources {
+				if !containsString(merged[i].Sources, source) {
+					merged[i].Sources = append(merged[i].Sources, source)
+				}
+			}
+			continue
+		}
+		seen[key] = len(merged)
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// expandGlob returns the files matching the pattern. A pattern without wildcards is returned as is, one without
+// ** is matched a directory at a time, and one with ** walks from the deepest directory without a wildcard
+func expandGlob(pattern string) ([]string, error) {
+	wildcard := strings.IndexAny(pattern, "*?")
+	if wildcard == -1 {
+		return []string{pattern}, nil
+	}
+	if !strings.Contains(pattern, "**") {
+		return globFiles(pattern)
+	}
+
+	root := "."
+	if slash := strings.LastIndex(pattern[:wildcard], "/"); slash != -1 {
+		root = pattern[:slash]
+		if root == "" {
+			root = "/"
+		}
+	}
+
+	glob, err := compileGlob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid results file pattern %s: %w", pattern, err)
+	}
+
+	var matches []string
+	_ = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// a directory that can't be read shouldn't hide the results in the others
+			if !os.IsNotExist(err) {
+				logf("Warning: skipping %s: %v\n", path, err)
+			}
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && glob.MatchString(strings.TrimPrefix(filepath.ToSlash(path), "./")) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	return matches, nil
+}
+
+// globFiles returns the files matching a pattern with only * and ? wildcards. Brackets and backslashes are
+// escaped so they match themselves, as they do in the other path patterns
+func globFiles(pattern string) ([]string, error) {
+	paths, err := filepath.Glob(strings.NewReplacer(`\`, `\\`, "[", `\[`).Replace(pattern))
+	if err != nil {
+		return nil, fmt.Errorf("invalid results file pattern %s: %w", pattern, err)
+	}
 
-	file, err := ioutil.ReadFile(resultsFile)
+	var matches []string
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			matches = append(matches, path)
+		}
+	}
+	return matches, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseResults parses the content with the adapter for the format, detecting the format if it is empty or auto
+func parseResults(content []byte, format string) ([]result, error) {
+	adapter, err := selectResultsAdapter(content, strings.ToLower(format))
 	if err != nil {
 		return nil, err
 	}
-	err = json.Unmarshal(file, &results)
+
+	results, err := adapter.parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s results: %w", adapter.format(), err)
+	}
+	for i := range results {
+		if results[i].Scanner == "" {
+			results[i].Scanner = adapter.format()
+		}
+	}
+	return results, nil
+}
+
+func selectResultsAdapter(content []byte, format string) (resultsAdapter, error) {
+	var formats []string
+	for _, adapter := range resultsAdapters {
+		if format == "" || format == "auto" {
+			if adapter.detect(content) {
+				return adapter, nil
+			}
+		} else if adapter.format() == format {
+			return adapter, nil
+		}
+		formats = append(formats, adapter.format())
+	}
+	if format == "" || format == "auto" {
+		return nil, fmt.Errorf("could not detect the format of the results")
+	}
+	return nil, fmt.Errorf("unexpected results format %s. Expected auto or one of %s", format, strings.Join(formats, ", "))
+}
+
+type tfsecAdapter struct{}
+
+func (tfsecAdapter) format() string {
+	return "tfsec"
+}
+
+// detect reports whether the content has a results key. tfsec writes null rather than an empty list when it finds
+// nothing, so only the key is checked
+func (tfsecAdapter) detect(content []byte) bool {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(content, &probe); err != nil {
+		return false
+	}
+	_, ok := probe["results"]
+	return ok
+}
+
+func (tfsecAdapter) parse(content []byte) ([]result, error) {
+	results := struct{ Results []result }{}
+
+	err := json.Unmarshal(content, &results)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTfsecDetect(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		detect  bool
+	}{
+		{name: "results", content: `{"results": [{"rule_id": "AWS001"}]}`, detect: true},
+		{name: "no results", content: `{"results": []}`, detect: true},
+		// tfsec writes null when nothing was found
+		{name: "null results", content: `{"results": null}`, detect: true},
+		{name: "no results key", content: `{"issues": []}`, detect: false},
+		{name: "list", content: `[]`, detect: false},
+		{name: "not json", content: `tfsec`, detect: false},
+	}
+	for _, tt := range tests {
+		if got := (tfsecAdapter{}).detect([]byte(tt.content)); got != tt.detect {
+			t.Errorf("%s: detect = %v, want %v", tt.name, got, tt.detect)
+		}
+	}
+}
+
+func TestParseNullTfsecResults(t *testing.T) {
+	results, err := parseResults([]byte(`{"results": null}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("parsed %d results, want none", len(results))
+	}
+}
+
+// writeResultsTree creates the files under a temporary directory and returns it
+func writeResultsTree(t *testing.T, files ...string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(`{"results": []}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestExpandGlob(t *testing.T) {
+	dir := writeResultsTree(t,
+		"artifacts/results.json",
+		"artifacts/network/results.json",
+		"artifacts/storage/results.json",
+		"artifacts/storage/old/results.json",
+		"artifacts/storage/results.sarif",
+		"artifacts/[v1]/results.json",
+		// a directory matching the pattern isn't a results file
+		"artifacts/report.json/results.json",
+	)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{pattern: "artifacts/missing.json", want: []string{"artifacts/missing.json"}},
+		{pattern: "artifacts/*.json", want: []string{"artifacts/results.json"}},
+		{pattern: "artifacts/*/results.json", want: []string{"artifacts/[v1]/results.json", "artifacts/network/results.json", "artifacts/report.json/results.json", "artifacts/storage/results.json"}},
+		{pattern: "artifacts/storage/results.?????", want: []string{"artifacts/storage/results.sarif"}},
+		{pattern: "artifacts/[v1]/*.json", want: []string{"artifacts/[v1]/results.json"}},
+		{pattern: "artifacts/storage/**/results.json", want: []string{"artifacts/storage/old/results.json", "artifacts/storage/results.json"}},
+		{pattern: "artifacts/**/results.sarif", want: []string{"artifacts/storage/results.sarif"}},
+		{pattern: "missing/*.json"},
+		{pattern: "missing/**/results.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			var want []string
+			for _, file := range tt.want {
+				want = append(want, filepath.Join(dir, filepath.FromSlash(file)))
+			}
+			got, err := expandGlob(filepath.Join(dir, filepath.FromSlash(tt.pattern)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("expandGlob = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestExpandGlobSkipsUnreadableDirectories(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read every directory")
+	}
+	dir := writeResultsTree(t, "artifacts/network/results.json", "artifacts/private/results.json")
+	defer os.RemoveAll(dir)
+	private := filepath.Join(dir, "artifacts", "private")
+	if err := os.Chmod(private, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(private, 0755)
+
+	got, err := expandGlob(filepath.Join(dir, "artifacts", "**", "results.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "artifacts", "network", "results.json")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expandGlob = %v, want %v", got, want)
+	}
+}
+
+func TestMergeResults(t *testing.T) {
+	testResult := func(ruleID, filename string, start, end int, source string) result {
+		return result{
+			RuleID:   ruleID,
+			Range:    &checkRange{Filename: filename, StartLine: start, EndLine: end},
+			Severity: "HIGH",
+			Sources:  []string{source},
+		}
+	}
+	results := []result{
+		testResult("aws-s3-enable-bucket-encryption", "infra/main.tf", 2, 4, "network/results.json"),
+		testResult("aws-s3-enable-bucket-encryption", "infra/main.tf", 2, 4, "storage/results.json"),
+		// the same rule on another range, in another file or another rule on the same range are kept
+		testResult("aws-s3-enable-bucket-encryption", "infra/main.tf", 2, 5, "network/results.json"),
+		testResult("aws-s3-enable-bucket-encryption", "infra/vpc.tf", 2, 4, "network/results.json"),
+		testResult("aws-s3-enable-versioning", "infra/main.tf", 2, 4, "network/results.json"),
+		testResult("aws-s3-enable-bucket-encryption", "infra/main.tf", 2, 4, "storage/results.json"),
+		testResult("aws-s3-enable-bucket-encryption", "infra/main.tf", 2, 4, "compute/results.json"),
+	}
+
+	merged := mergeResults(results)
+	if len(merged) != 4 {
+		t.Fatalf("merged into %d results, want 4", len(merged))
+	}
+	// the first result is kept, with every results file it was reported in once
+	if want := []string{"network/results.json", "storage/results.json", "compute/results.json"}; !reflect.DeepEqual(merged[0].Sources, want) {
+		t.Errorf("sources = %v, want %v", merged[0].Sources, want)
+	}
+	for i, r := range merged[1:] {
+		if !reflect.DeepEqual(r, results[i+2]) {
+			t.Errorf("result %d = %+v, want %+v", i+1, r, results[i+2])
+		}
+	}
+}
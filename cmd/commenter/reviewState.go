@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fixedCommentPrefix starts the reply, or the edit on platforms that can't reply, for a finding that was fixed
+const fixedCommentPrefix = ":white_check_mark: This issue was fixed in"
+
+// pendingComment is a comment queued to be written when the review is submitted
+type pendingComment struct {
+	filename  string
+	comment   string
+	startLine int
+	endLine   int
+}
+
+// reviewState is what the platforms without a vendored client share: the diff of the PR, the comments from
+// earlier runs and the comments queued for the review. Each platform only has to turn them into API calls
+type reviewState struct {
+	headSHA          string
+	files            changedFiles
+	existingComments []*existingComment
+	pending          []pendingComment
+}
+
+// HeadSHA returns the commit at the head of the PR
+func (s *reviewState) HeadSHA() string {
+	return s.headSHA
+}
+
+// InDiff reports whether both ends of the line range are part of the PR diff for the file
+func (s *reviewState) InDiff(file string, startLine, endLine int) bool {
+	return s.files.inDiff(file, startLine, endLine)
+}
+
+// InChangedLines reports whether any line in the range was added or modified in the PR
+func (s *reviewState) InChangedLines(file string, startLine, endLine int) bool {
+	return s.files.inChangedLines(file, startLine, endLine)
+}
+
+// PendingComments returns the number of comments queued for the next review
+func (s *reviewState) PendingComments() int {
+	return len(s.pending)
+}
+
+// queueComment queues the comment unless it is outside the diff or already written. When an earlier run wrote
+// a comment for the same finding with different wording, that comment is returned to be edited instead. A
+// comment the commenter marked as fixed doesn't count, so an issue that comes back after it was fixed is commented
+// on again, while a comment resolved by hand is left alone
+func (s *reviewState) queueComment(file, comment string, startLine, endLine int) (*existingComment, error) {
+	if !s.InDiff(file, startLine, endLine) {
+		return nil, newCommentNotValidError(file, startLine)
+	}
+
+	for _, existing := range s.existingComments {
+		if !existing.matches(file, comment) || existing.fixed {
+			continue
+		}
+		if existing.resolved || *existing.comment == comment {
+			return nil, newCommentAlreadyWrittenError(file, comment)
+		}
+		return existing, nil
+	}
+
+	fingerprint := extractFingerprint(comment)
+	for _, pending := range s.pending {
+		if pending.filename == file && (pending.comment == comment ||
+			fingerprint != "" && extractFingerprint(pending.comment) == fingerprint) {
+			return nil, newCommentAlreadyWrittenError(file, comment)
+		}
+	}
+
+	// a multi-line comment has to sit within a single hunk, otherwise fall back to the last line
+	if !s.files.find(file).diff.containsRange(startLine, endLine) {
+		startLine = endLine
+	}
+	s.pending = append(s.pending, pendingComment{
+		filename:  file,
+		comment:   comment,
+		startLine: startLine,
+		endLine:   endLine,
+	})
+	return nil, nil
+}
+
+// edited records the new wording of a comment that was edited in place
+func (s *reviewState) edited(existing *existingComment, comment string) {
+	existing.comment = &comment
+	existing.fingerprint = extractFingerprint(comment)
+}
+
+// staleComments returns the unresolved comments from earlier runs under the scope whose finding is no longer reported
+func (s *reviewState) staleComments(current []findingComment, scope string) []*existingComment {
+	var stale []*existingComment
+	for _, existing := range s.existingComments {
+		if !existing.resolved && existing.isStale(current, scope) {
+			stale = append(stale, existing)
+		}
+	}
+	return stale
+}
+
+// fixedComment is the reply to a stale comment saying which commit fixed the issue
+func (s *reviewState) fixedComment() string {
+	return fixedCommentFor(s.headSHA)
+}
+
+// isFixedComment reports whether the comment is the reply, or the edit on platforms that can't reply, written
+// when a finding was fixed
+func isFixedComment(comment string) bool {
+	return strings.HasPrefix(comment, fixedCommentPrefix)
+}
+
+// fixedCommentFor is the reply to a stale comment on every platform, saying the issue was fixed in the commit
+func fixedCommentFor(sha string) string {
+	return fmt.Sprintf("%s %s", fixedCommentPrefix, sha)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+)
+
+const reviewThreadsQuery = `query($owner: String!, $repo: String!, $number: Int!, $cursor: String) {
+  repository(owner: $owner, name: $repo) {
+    pullRequest(number: $number) {
+      reviewThreads(first: 100, after: $cursor) {
+        pageInfo { hasNextPage endCursor }
+        nodes {
+          id
+          isResolved
+          comments(first: 1) { nodes { databaseId isMinimized } }
+          lastReply: comments(last: 1) { nodes { body } }
+        }
+      }
+    }
+  }
+}`
+
+const resolveReviewThreadMutation = `mutation($threadId: ID!) {
+  resolveReviewThread(input: {threadId: $threadId}) { thread { id } }
+}`
+
+const minimizeCommentMutation = `mutation($subjectId: ID!) {
+  minimizeComment(input: {subjectId: $subjectId, classifier: OUTDATED}) { minimizedComment { isMinimized } }
+}`
+
+type reviewThread struct {
+	id          string
+	isResolved  bool
+	isMinimized bool
+	// fixedReply is set when the last comment of the thread is the reply saying the issue was fixed
+	fixedReply bool
+}
+
+// getReviewThreads returns the review threads of the PR keyed on the id of the comment that started them
+func (c *connector) getReviewThreads() (map[int64]*reviewThread, error) {
+
+	ctx := context.Background()
+	threads := make(map[int64]*reviewThread)
+
+	var cursor *string
+	for {
+		data := struct {
+			Repository struct {
+				PullRequest struct {
+					ReviewThreads struct {
+						PageInfo struct {
+							HasNextPage bool   `json:"hasNextPage"`
+							EndCursor   string `json:"endCursor"`
+						} `json:"pageInfo"`
+						Nodes []struct {
+							ID         string `json:"id"`
+							IsResolved bool   `json:"isResolved"`
+							Comments   struct {
+								Nodes []struct {
+									DatabaseID  int64 `json:"databaseId"`
+									IsMinimized bool  `json:"isMinimized"`
+								} `json:"nodes"`
+							} `json:"comments"`
+							LastReply struct {
+								Nodes []struct {
+									Body string `json:"body"`
+								} `json:"nodes"`
+							} `json:"lastReply"`
+						} `json:"nodes"`
+					} `json:"reviewThreads"`
+				} `json:"pullRequest"`
+			} `json:"repository"`
+		}{}
+
+		err := c.graphql.query(ctx, reviewThreadsQuery, map[string]interface{}{
+			"owner":  c.owner,
+			"repo":   c.repo,
+			"number": c.prNumber,
+			"cursor": cursor,
+		}, &data)
+		if err != nil {
+			return nil, err
+		}
+
+		page := data.Repository.PullRequest.ReviewThreads
+		for _, node := range page.Nodes {
+			if len(node.Comments.Nodes) == 0 {
+				continue
+			}
+			first := node.Comments.Nodes[0]
+			last := node.LastReply.Nodes
+			threads[first.DatabaseID] = &reviewThread{
+				id:          node.ID,
+				isResolved:  node.IsResolved,
+				isMinimized: first.IsMinimized,
+				fixedReply:  len(last) > 0 && isFixedComment(last[0].Body),
+			}
+		}
+
+		if !page.PageInfo.HasNextPage {
+			break
+		}
+		endCursor := page.PageInfo.EndCursor
+		cursor = &endCursor
+	}
+	return threads, nil
+}
+
+func (c *connector) resolveReviewThread(threadId string) error {
+
+	return c.graphql.query(context.Background(), resolveReviewThreadMutation, map[string]interface{}{
+		"threadId": threadId,
+	}, nil)
+}
+
+func (c *connector) minimizeComment(nodeId string) error {
+
+	return c.graphql.query(context.Background(), minimizeCommentMutation, map[string]interface{}{
+		"subjectId": nodeId,
+	}, nil)
+}
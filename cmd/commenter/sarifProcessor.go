@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name  string      `json:"name"`
+			Rules []sarifRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+	FullDescription  sarifMessage `json:"fullDescription"`
+	HelpURI          string       `json:"helpUri"`
+	DefaultConfig    struct {
+		Level string `json:"level"`
+	} `json:"defaultConfiguration"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex *int            `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region struct {
+			StartLine int `json:"startLine"`
+			EndLine   int `json:"endLine"`
+		} `json:"region"`
+	} `json:"physicalLocation"`
+}
+
+type sarifAdapter struct{}
+
+func (sarifAdapter) format() string {
+	return "sarif"
+}
+
+// detect reports whether the content looks like a SARIF log rather than tfsec's own JSON
+func (sarifAdapter) detect(content []byte) bool {
+	probe := struct {
+		Version string            `json:"version"`
+		Runs    []json.RawMessage `json:"runs"`
+	}{}
+	if err := json.Unmarshal(content, &probe); err != nil {
+		return false
+	}
+	return probe.Version != "" && probe.Runs != nil
+}
+
+// parse maps each SARIF 2.1.0 result with a physical location onto a result
+func (sarifAdapter) parse(content []byte) ([]result, error) {
+	var log sarifLog
+	if err := json.Unmarshal(content, &log); err != nil {
+		return nil, err
+	}
+	if log.Version != "2.1.0" {
+		return nil, fmt.Errorf("unsupported SARIF version %s, expected 2.1.0", log.Version)
+	}
+
+	var results []result
+	for _, run := range log.Runs {
+		rules := make(map[string]sarifRule)
+		for _, rule := range run.Tool.Driver.Rules {
+			rules[rule.ID] = rule
+		}
+
+		for _, sr := range run.Results {
+			rule, ok := rules[sr.RuleID]
+			if !ok && sr.RuleIndex != nil && *sr.RuleIndex < len(run.Tool.Driver.Rules) {
+				rule = run.Tool.Driver.Rules[*sr.RuleIndex]
+			}
+			ruleID := sr.RuleID
+			if ruleID == "" {
+				ruleID = rule.ID
+			}
+
+			for _, location := range sr.Locations {
+				region := location.PhysicalLocation.Region
+				if location.PhysicalLocation.ArtifactLocation.URI == "" || region.StartLine == 0 {
+					continue
+				}
+				endLine := region.EndLine
+				if endLine < region.StartLine {
+					endLine = region.StartLine
+				}
+
+				var links []string
+				if rule.HelpURI != "" {
+					links = append(links, rule.HelpURI)
+				}
+
+				results = append(results, result{
+					RuleID:          ruleID,
+					RuleDescription: rule.ShortDescription.Text,
+					RuleProvider:    run.Tool.Driver.Name,
+					Links:           links,
+					Range: &checkRange{
+						Filename:  sarifFilename(location.PhysicalLocation.ArtifactLocation.URI),
+						StartLine: region.StartLine,
+						EndLine:   endLine,
+					},
+					Description: sr.Message.Text,
+					Severity:    sarifSeverity(sr.Level, rule),
+					Scanner:     run.Tool.Driver.Name,
+				})
+			}
+		}
+	}
+	return results, nil
+}
+
+// sarifFilename turns an artifact uri into a file path, removing any file:// scheme
+func sarifFilename(uri string) string {
+	if strings.HasPrefix(uri, "file://") {
+		if parsed, err := url.Parse(uri); err == nil {
+			return parsed.Path
+		}
+	}
+	if unescaped, err := url.PathUnescape(uri); err == nil {
+		return unescaped
+	}
+	return uri
+}
+
+// sarifSeverity uses the security-severity score from the rule where there is one, as GitHub code scanning
+// does, otherwise it maps the result level
+func sarifSeverity(level string, rule sarifRule) string {
+	if score, ok := rule.Properties["security-severity"]; ok {
+		if value, err := strconv.ParseFloat(fmt.Sprintf("%v", score), 64); err == nil {
+			switch {
+			case value >= 9:
+				return "CRITICAL"
+			case value >= 7:
+				return "HIGH"
+			case value >= 4:
+				return "MEDIUM"
+			default:
+				return "LOW"
+			}
+		}
+	}
+
+	if level == "" {
+		level = rule.DefaultConfig.Level
+	}
+	switch level {
+	case "error":
+		return "HIGH"
+	case "note", "none":
+		return "LOW"
+	default:
+		// warning is the SARIF default level
+		return "MEDIUM"
+	}
+}
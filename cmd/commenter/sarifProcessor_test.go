@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// parsedResult is the part of a result the adapters work out for themselves
+type parsedResult struct {
+	ruleID     string
+	filename   string
+	start, end int
+	severity   string
+	scanner    string
+}
+
+// parseResultsFixture detects the format of a results file in testdata/results and parses it
+func parseResultsFixture(t *testing.T, name, format string) []result {
+	t.Helper()
+	content := []byte(readFixture(t, "results/"+name))
+	adapter, err := selectResultsAdapter(content, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adapter.format() != format {
+		t.Fatalf("detected %s, want %s", adapter.format(), format)
+	}
+	results, err := parseResults(content, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func checkParsedResults(t *testing.T, results []result, want []parsedResult) {
+	t.Helper()
+	if len(results) != len(want) {
+		t.Fatalf("parsed %d results, want %d", len(results), len(want))
+	}
+	for i, r := range results {
+		got := parsedResult{
+			ruleID:   r.RuleID,
+			filename: r.Range.Filename,
+			start:    r.Range.StartLine,
+			end:      r.Range.EndLine,
+			severity: r.Severity,
+			scanner:  r.Scanner,
+		}
+		if got != want[i] {
+			t.Errorf("result %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestSarifParse(t *testing.T) {
+	results := parseResultsFixture(t, "sarif.json", "sarif")
+	checkParsedResults(t, results, []parsedResult{
+		// file uris lose their scheme, the security-severity score wins over the level
+		{ruleID: "aws-s3-enable-bucket-encryption", filename: "/github/workspace/infra/main.tf", start: 2, end: 4, severity: "HIGH", scanner: "tfsec"},
+		// the rule is found from its index, a region without an end line covers its start line, as does one ending
+		// before it starts, and the uri is unescaped
+		{ruleID: "aws-vpc-no-public-egress-sgr", filename: "infra/network rules/vpc.tf", start: 12, end: 12, severity: "CRITICAL", scanner: "tfsec"},
+		{ruleID: "aws-vpc-no-public-egress-sgr", filename: "infra/network rules/vpc.tf", start: 30, end: 30, severity: "CRITICAL", scanner: "tfsec"},
+		// locations without a start line or uri are skipped, as are results without a location
+		{ruleID: "CKV_AWS_21", filename: "modules/storage/bucket.tf", start: 1, end: 10, severity: "MEDIUM", scanner: "checkov"},
+	})
+
+	first := results[0]
+	if first.RuleDescription != "Unencrypted S3 bucket." || first.Description != "Bucket does not have encryption enabled" || first.RuleProvider != "tfsec" {
+		t.Errorf("result = %+v", first)
+	}
+	if len(first.Links) != 1 || !strings.HasSuffix(first.Links[0], "/aws/s3/enable-bucket-encryption/") || len(results[1].Links) != 0 {
+		t.Errorf("links = %v and %v, want only the help uri", first.Links, results[1].Links)
+	}
+}
+
+func TestSarifSeverity(t *testing.T) {
+	tests := []struct {
+		level    string
+		rule     sarifRule
+		severity string
+	}{
+		{level: "error", severity: "HIGH"},
+		{level: "warning", severity: "MEDIUM"},
+		{level: "note", severity: "LOW"},
+		{level: "none", severity: "LOW"},
+		{level: "", severity: "MEDIUM"},
+		{level: "", rule: sarifRule{DefaultConfig: struct {
+			Level string `json:"level"`
+		}{Level: "error"}}, severity: "HIGH"},
+		{level: "note", rule: sarifRule{Properties: map[string]interface{}{"security-severity": "9.0"}}, severity: "CRITICAL"},
+		{level: "error", rule: sarifRule{Properties: map[string]interface{}{"security-severity": 6.9}}, severity: "MEDIUM"},
+		{level: "error", rule: sarifRule{Properties: map[string]interface{}{"security-severity": "3.9"}}, severity: "LOW"},
+		{level: "error", rule: sarifRule{Properties: map[string]interface{}{"security-severity": "high"}}, severity: "HIGH"},
+	}
+	for _, tt := range tests {
+		if got := sarifSeverity(tt.level, tt.rule); got != tt.severity {
+			t.Errorf("sarifSeverity(%q, %v) = %s, want %s", tt.level, tt.rule.Properties, got, tt.severity)
+		}
+	}
+}
+
+func TestSarifFilename(t *testing.T) {
+	tests := []struct {
+		uri      string
+		filename string
+	}{
+		{uri: "infra/main.tf", filename: "infra/main.tf"},
+		{uri: "file:///github/workspace/infra/main.tf", filename: "/github/workspace/infra/main.tf"},
+		{uri: "file:///github/workspace/my%20module/main.tf", filename: "/github/workspace/my module/main.tf"},
+		{uri: "modules/caf%C3%A9/main.tf", filename: "modules/café/main.tf"},
+		{uri: "modules/100%/main.tf", filename: "modules/100%/main.tf"},
+	}
+	for _, tt := range tests {
+		if got := sarifFilename(tt.uri); got != tt.filename {
+			t.Errorf("sarifFilename(%q) = %q, want %q", tt.uri, got, tt.filename)
+		}
+	}
+}
+
+func TestSarifUnsupportedVersion(t *testing.T) {
+	content := []byte(`{"version": "2.0.0", "runs": []}`)
+	if !(sarifAdapter{}).detect(content) {
+		t.Fatalf("an older SARIF log should still be detected")
+	}
+	if _, err := parseResults(content, ""); err == nil {
+		t.Errorf("SARIF 2.0.0 should be rejected")
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// summaryMarker is hidden in the summary comment so it can be found and updated on later runs
+const summaryMarker = "<!-- tfsec-pr-commenter:summary -->"
+
+const (
+	// summaryMaxLength keeps the summary within the 65536 characters GitHub allows in a comment or check run
+	// summary, with room to spare for the platforms that count differently
+	summaryMaxLength = 60000
+	// summaryMaxStateKeys is the most findings tracked between runs, which keeps the state to about 19KB
+	summaryMaxStateKeys = 1000
+	// summaryStateKeyLength is how much of the fingerprint is kept in the state, enough to tell findings apart
+	summaryStateKeyLength = 16
+)
+
+var (
+	summaryStateRegex = regexp.MustCompile(`<!-- tfsec-pr-commenter:findings (.*?) -->`)
+	severityOrder     = []string{"CRITICAL", "HIGH", "MEDIUM", "LOW"}
+)
+
+type summaryFinding struct {
+	Fingerprint string
+	RuleID      string
+	Scanner     string
+	Severity    string
+	Filename    string
+	StartLine   int
+	EndLine     int
+	InDiff      bool
+	Changed     bool
+	Sources     []string
+}
+
+func newSummaryFinding(result result, inDiff bool) summaryFinding {
+	return summaryFinding{
+		Fingerprint: result.Fingerprint,
+		RuleID:      result.RuleID,
+		Scanner:     result.Scanner,
+		Severity:    strings.ToUpper(result.Severity),
+		Filename:    result.Range.Filename,
+		StartLine:   result.Range.StartLine,
+		EndLine:     result.Range.EndLine,
+		InDiff:      inDiff,
+		Sources:     result.Sources,
+	}
+}
+
+// key identifies the finding between runs so the summary can report what has changed
+func (f summaryFinding) key() string {
+	return shortStateKey(f.Fingerprint)
+}
+
+// shortStateKey shortens a fingerprint to the length kept in the summary state, which states written before it
+// was shortened are also read with
+func shortStateKey(key string) string {
+	if len(key) > summaryStateKeyLength {
+		return key[:summaryStateKeyLength]
+	}
+	return key
+}
+
+func (f summaryFinding) lines() string {
+	if f.StartLine == f.EndLine {
+		return fmt.Sprintf("%d", f.StartLine)
+	}
+	return fmt.Sprintf("%d-%d", f.StartLine, f.EndLine)
+}
+
+// generateSummary writes the summary comment. The findings table is cut short when the comment would be too long
+// for the platform, the counts and the state are always complete. New findings that fail the policy are left out
+// of the state, see holdsBack
+func generateSummary(findings []summaryFinding, previousSummary string, policy *failurePolicy) string {
+	var head, tail strings.Builder
+
+	head.WriteString(summaryMarker + "\n")
+	head.WriteString("## tfsec summary\n\n")
+
+	if len(findings) == 0 {
+		head.WriteString(":white_check_mark: tfsec found no issues.\n")
+	} else {
+		head.WriteString(fmt.Sprintf(":warning: tfsec found **%d** issues.\n\n", len(findings)))
+		head.WriteString(formatSeverityCounts(findings))
+		head.WriteString("\n")
+	}
+
+	tail.WriteString("\n")
+	tail.WriteString(formatChanges(findings, previousSummary))
+	tail.WriteString("\n")
+	previous := parseSummaryState(previousSummary)
+	var kept []summaryFinding
+	for _, finding := range findings {
+		if !policy.holdsBack(finding, previous) {
+			kept = append(kept, finding)
+		}
+	}
+	tail.WriteString(formatSummaryState(kept))
+
+	table := ""
+	if len(findings) > 0 {
+		table = formatFindingsTable(findings, summaryMaxLength-head.Len()-tail.Len())
+	}
+	return head.String() + table + tail.String()
+}
+
+func formatSeverityCounts(findings []summaryFinding) string {
+	counts := make(map[string]int)
+	for _, finding := range findings {
+		counts[finding.Severity]++
+	}
+
+	var sb strings.Builder
+	sb.WriteString("| Severity | Count |\n| --- | --- |\n")
+	for _, severity := range severityOrder {
+		if counts[severity] > 0 {
+			sb.WriteString(fmt.Sprintf("| %s | %d |\n", severity, counts[severity]))
+		}
+		delete(counts, severity)
+	}
+	var others []string
+	for severity := range counts {
+		others = append(others, severity)
+	}
+	sort.Strings(others)
+	for _, severity := range others {
+		sb.WriteString(fmt.Sprintf("| %s | %d |\n", severity, counts[severity]))
+	}
+	return sb.String()
+}
+
+// formatFindingsTable lists the findings within maxLength characters, ending with a row saying how many were left
+// out when they don't all fit
+func formatFindingsTable(findings []summaryFinding, maxLength int) string {
+	// only show where each finding came from when the results were merged from several files
+	sources := make(map[string]bool)
+	for _, finding := range findings {
+		for _, source := range finding.Sources {
+			sources[source] = true
+		}
+	}
+	showSources := len(sources) > 1
+
+	var sb strings.Builder
+	sb.WriteString("<details>\n<summary>All findings</summary>\n\n")
+	sb.WriteString("| Rule | Scanner | Severity | File | Lines | In diff |")
+	if showSources {
+		sb.WriteString(" Results file |")
+	}
+	sb.WriteString("\n| --- | --- | --- | --- | --- | --- |")
+	if showSources {
+		sb.WriteString(" --- |")
+	}
+	sb.WriteString("\n")
+
+	const footer = "\n</details>\n"
+	// room for the row saying how many findings were left out
+	const moreRowLength = 64
+	for i, finding := range findings {
+		inDiff := "no"
+		if finding.InDiff {
+			inDiff = "yes"
+		}
+		row := fmt.Sprintf("| `%s` | %s | %s | `%s` | %s | %s |",
+			finding.RuleID, finding.Scanner, finding.Severity, escapeTableCell(finding.Filename), finding.lines(), inDiff)
+		if showSources {
+			row += fmt.Sprintf(" %s |", escapeTableCell(strings.Join(finding.Sources, ", ")))
+		}
+		if sb.Len()+len(row)+1+moreRowLength+len(footer) > maxLength {
+			sb.WriteString(fmt.Sprintf("| _and %d more_ | | | | | |", len(findings)-i))
+			if showSources {
+				sb.WriteString(" |")
+			}
+			sb.WriteString("\n")
+			break
+		}
+		sb.WriteString(row + "\n")
+	}
+	sb.WriteString(footer)
+	return sb.String()
+}
+
+func formatChanges(findings []summaryFinding, previousSummary string) string {
+	if previousSummary == "" {
+		return "_This is the first tfsec run on this PR._\n"
+	}
+
+	previous := parseSummaryState(previousSummary)
+
+	var added int
+	current := make(map[string]bool)
+	for _, finding := range findings {
+		current[finding.key()] = true
+		if previous.isNew(finding.key()) {
+			added++
+		}
+	}
+	var fixed int
+	for key := range previous.keys {
+		if !current[key] {
+			fixed++
+		}
+	}
+
+	if added == 0 && fixed == 0 {
+		return "_No changes since the last run._\n"
+	}
+	return fmt.Sprintf("_Since the last run: %d new, %d fixed._\n", added, fixed)
+}
+
+// summaryStateJSON is the state hidden in the summary comment. States written before it could be truncated are a
+// plain list of keys
+type summaryStateJSON struct {
+	Keys      []string `json:"keys"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// summaryState is the findings reported on the previous run
+type summaryState struct {
+	keys map[string]bool
+	// truncated is set when the previous run had more findings than the state can hold
+	truncated bool
+}
+
+// isNew reports whether the finding wasn't reported on the previous run. When the previous state was truncated
+// the findings that were left out can't be told apart from new ones, so none are counted as new
+func (s summaryState) isNew(key string) bool {
+	return !s.truncated && !s.keys[key]
+}
+
+func formatSummaryState(findings []summaryFinding) string {
+	state := summaryStateJSON{Keys: make([]string, 0, len(findings))}
+	for _, finding := range findings {
+		if len(state.Keys) == summaryMaxStateKeys {
+			state.Truncated = true
+			break
+		}
+		state.Keys = append(state.Keys, finding.key())
+	}
+	// json.Marshal escapes <, > and & so the keys can't terminate the HTML comment
+	content, _ := json.Marshal(state)
+	return fmt.Sprintf("<!-- tfsec-pr-commenter:findings %s -->\n", content)
+}
+
+func parseSummaryState(summary string) summaryState {
+	state := summaryState{keys: make(map[string]bool)}
+	groups := summaryStateRegex.FindStringSubmatch(summary)
+	if len(groups) < 2 {
+		return state
+	}
+
+	var content summaryStateJSON
+	if err := json.Unmarshal([]byte(groups[1]), &content); err != nil {
+		if err := json.Unmarshal([]byte(groups[1]), &content.Keys); err != nil {
+			return state
+		}
+	}
+	for _, key := range content.Keys {
+		state.keys[shortStateKey(key)] = true
+	}
+	state.truncated = content.Truncated
+	return state
+}
+
+func escapeTableCell(value string) string {
+	return strings.ReplaceAll(value, "|", "\\|")
+}
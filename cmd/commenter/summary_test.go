@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testSummaryFindings makes count HIGH findings in the diff, each with its own fingerprint
+func testSummaryFindings(count int) []summaryFinding {
+	var findings []summaryFinding
+	for i := 0; i < count; i++ {
+		findings = append(findings, summaryFinding{
+			Fingerprint: fmt.Sprintf("%016x%016x", i, i),
+			RuleID:      "aws-s3-enable-bucket-encryption",
+			Scanner:     "tfsec",
+			Severity:    "HIGH",
+			Filename:    fmt.Sprintf("infra/modules/storage/bucket_%d.tf", i),
+			StartLine:   i + 1,
+			EndLine:     i + 3,
+			InDiff:      true,
+		})
+	}
+	return findings
+}
+
+func TestSummaryRoundTripsFindings(t *testing.T) {
+	findings := testSummaryFindings(3)
+	first := generateSummary(findings, "", nil)
+	if !strings.HasPrefix(first, summaryMarker+"\n") || !strings.Contains(first, "_This is the first tfsec run on this PR._") {
+		t.Errorf("first summary = %q", first)
+	}
+
+	state := parseSummaryState(first)
+	if len(state.keys) != 3 || state.truncated {
+		t.Fatalf("state = %+v, want the 3 findings", state)
+	}
+	for _, finding := range findings {
+		if !state.keys[finding.key()] || len(finding.key()) != summaryStateKeyLength {
+			t.Errorf("the state should have the short key of %s", finding.Fingerprint)
+		}
+	}
+
+	// the first finding is fixed and a new one is found
+	next := append(findings[1:], testSummaryFindings(4)[3])
+	if second := generateSummary(next, first, nil); !strings.Contains(second, "_Since the last run: 1 new, 1 fixed._") {
+		t.Errorf("second summary = %q", second)
+	}
+	if third := generateSummary(next, generateSummary(next, first, nil), nil); !strings.Contains(third, "_No changes since the last run._") {
+		t.Errorf("third summary = %q", third)
+	}
+}
+
+func TestParseSummaryState(t *testing.T) {
+	tests := []struct {
+		name      string
+		summary   string
+		keys      []string
+		truncated bool
+	}{
+		{name: "no state", summary: summaryMarker + "\n## tfsec summary\n"},
+		{name: "state", summary: `<!-- tfsec-pr-commenter:findings {"keys":["aaaa","bbbb"]} -->`, keys: []string{"aaaa", "bbbb"}},
+		{name: "truncated state", summary: `<!-- tfsec-pr-commenter:findings {"keys":["aaaa"],"truncated":true} -->`, keys: []string{"aaaa"}, truncated: true},
+		// states written before they could be truncated are a list of the full fingerprints
+		{name: "list of full fingerprints", summary: `<!-- tfsec-pr-commenter:findings ["0123456789abcdef0123456789abcdef"] -->`, keys: []string{"0123456789abcdef"}},
+		{name: "invalid state", summary: `<!-- tfsec-pr-commenter:findings {"keys": -->`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := parseSummaryState(tt.summary)
+			if len(state.keys) != len(tt.keys) || state.truncated != tt.truncated {
+				t.Fatalf("state = %+v, want %v truncated %t", state, tt.keys, tt.truncated)
+			}
+			for _, key := range tt.keys {
+				if !state.keys[key] {
+					t.Errorf("state should have %s", key)
+				}
+			}
+		})
+	}
+}
+
+func TestSummaryStateIsCapped(t *testing.T) {
+	findings := testSummaryFindings(summaryMaxStateKeys + 1)
+	state := parseSummaryState(generateSummary(findings, "", nil))
+	if len(state.keys) != summaryMaxStateKeys || !state.truncated {
+		t.Fatalf("state has %d keys, truncated %t, want %d and truncated", len(state.keys), state.truncated, summaryMaxStateKeys)
+	}
+	// the finding left out can't be told apart from a new one, so nothing counts as new
+	if state.isNew(findings[summaryMaxStateKeys].key()) || state.isNew("ffffffffffffffff") {
+		t.Errorf("no finding should be new after a truncated state")
+	}
+
+	if state := parseSummaryState(generateSummary(findings[:summaryMaxStateKeys], "", nil)); state.truncated {
+		t.Errorf("a state with exactly %d findings should not be truncated", summaryMaxStateKeys)
+	}
+}
+
+func TestSummaryTableIsCapped(t *testing.T) {
+	findings := testSummaryFindings(2000)
+	summary := generateSummary(findings, "", nil)
+	if len(summary) > summaryMaxLength {
+		t.Fatalf("summary is %d characters, want at most %d", len(summary), summaryMaxLength)
+	}
+
+	rows := strings.Count(summary, "| `aws-s3-enable-bucket-encryption` |")
+	if rows == 0 || rows == len(findings) {
+		t.Fatalf("table has %d rows, want it cut short", rows)
+	}
+	if !strings.Contains(summary, fmt.Sprintf("| _and %d more_ |", len(findings)-rows)) {
+		t.Errorf("the table should end with the number of findings left out")
+	}
+	// the counts, the table footer and the state are kept in full
+	if !strings.Contains(summary, "| HIGH | 2000 |") || !strings.Contains(summary, "\n</details>\n") {
+		t.Errorf("the counts and the end of the table should be complete")
+	}
+	if state := parseSummaryState(summary); len(state.keys) != summaryMaxStateKeys {
+		t.Errorf("state has %d keys", len(state.keys))
+	}
+
+	if summary := generateSummary(testSummaryFindings(10), "", nil); strings.Contains(summary, "more_ |") {
+		t.Errorf("a short table should not be cut")
+	}
+}
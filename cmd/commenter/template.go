@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+)
+
+// defaultCommentTemplate renders the same message the commenter has always written for tfsec results, so
+// existing comments are still recognised
+const defaultCommentTemplate = ":warning: {{ .Result.Scanner }} found a **{{ .Result.Severity }}** severity issue from rule `{{ .Result.RuleID }}`:\n" +
+	"> {{ .Result.Description }}\n" +
+	"\n" +
+	"More information available {{ formatUrls .Result.Links }}"
+
+// commentContext describes where the comment is being written, for use in templates
+type commentContext struct {
+	Owner       string
+	Repo        string
+	Repository  string
+	PullRequest int
+	ServerURL   string
+	SHA         string
+}
+
+// commentData is passed to the comment template for each result
+type commentData struct {
+	Result result
+	commentContext
+}
+
+type commentRenderer struct {
+	template *template.Template
+	context  commentContext
+}
+
+// newCommentRenderer parses the template file, or the default template if no file is given
+func newCommentRenderer(templateFile string, context commentContext) (*commentRenderer, error) {
+	text := defaultCommentTemplate
+	if templateFile != "" {
+		content, err := ioutil.ReadFile(templateFile)
+		if err != nil {
+			return nil, fmt.Errorf("read comment template: %w", err)
+		}
+		text = string(content)
+	}
+
+	tmpl, err := template.New("comment").Funcs(template.FuncMap{
+		"formatUrls": formatUrls,
+		"join":       strings.Join,
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+		"trim":       strings.TrimSpace,
+	}).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse comment template: %w", err)
+	}
+
+	return &commentRenderer{
+		template: tmpl,
+		context:  context,
+	}, nil
+}
+
+func (r *commentRenderer) render(result result) (string, error) {
+	var sb strings.Builder
+	if err := r.template.Execute(&sb, commentData{Result: result, commentContext: r.context}); err != nil {
+		return "", fmt.Errorf("render comment for rule %s: %w", result.RuleID, err)
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+type tflintReport struct {
+	Issues []tflintIssue `json:"issues"`
+}
+
+type tflintIssue struct {
+	Rule struct {
+		Name     string `json:"name"`
+		Severity string `json:"severity"`
+		Link     string `json:"link"`
+	} `json:"rule"`
+	Message string `json:"message"`
+	Range   struct {
+		Filename string `json:"filename"`
+		Start    struct {
+			Line int `json:"line"`
+		} `json:"start"`
+		End struct {
+			Line int `json:"line"`
+		} `json:"end"`
+	} `json:"range"`
+}
+
+type tflintAdapter struct{}
+
+func (tflintAdapter) format() string {
+	return "tflint"
+}
+
+// detect reports whether the content is the output of tflint --format json
+func (tflintAdapter) detect(content []byte) bool {
+	probe := struct {
+		Issues *[]json.RawMessage `json:"issues"`
+		Errors *[]json.RawMessage `json:"errors"`
+	}{}
+	return json.Unmarshal(content, &probe) == nil && probe.Issues != nil && probe.Errors != nil
+}
+
+// parse maps each issue onto a result
+func (tflintAdapter) parse(content []byte) ([]result, error) {
+	var report tflintReport
+	if err := json.Unmarshal(content, &report); err != nil {
+		return nil, err
+	}
+
+	var results []result
+	for _, issue := range report.Issues {
+		endLine := issue.Range.End.Line
+		if endLine < issue.Range.Start.Line {
+			endLine = issue.Range.Start.Line
+		}
+
+		var links []string
+		if issue.Rule.Link != "" {
+			links = append(links, issue.Rule.Link)
+		}
+
+		results = append(results, result{
+			RuleID:          issue.Rule.Name,
+			RuleDescription: issue.Message,
+			Links:           links,
+			Range: &checkRange{
+				Filename:  issue.Range.Filename,
+				StartLine: issue.Range.Start.Line,
+				EndLine:   endLine,
+			},
+			Description: issue.Message,
+			Severity:    tflintSeverity(issue.Rule.Severity),
+		})
+	}
+	return results, nil
+}
+
+func tflintSeverity(severity string) string {
+	switch strings.ToLower(severity) {
+	case "error":
+		return "HIGH"
+	case "warning":
+		return "MEDIUM"
+	default:
+		return "LOW"
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestTflintParse(t *testing.T) {
+	results := parseResultsFixture(t, "tflint.json", "tflint")
+	checkParsedResults(t, results, []parsedResult{
+		{ruleID: "terraform_deprecated_interpolation", filename: "infra/main.tf", start: 3, end: 3, severity: "MEDIUM", scanner: "tflint"},
+		// the range covers every line it spans
+		{ruleID: "aws_instance_invalid_type", filename: "infra/compute.tf", start: 8, end: 10, severity: "HIGH", scanner: "tflint"},
+		// a range without an end covers its start line
+		{ruleID: "terraform_unused_declarations", filename: "infra/variables.tf", start: 14, end: 14, severity: "LOW", scanner: "tflint"},
+	})
+
+	if results[0].Description != "Interpolation-only expressions are deprecated in Terraform v0.12.14" || len(results[0].Links) != 1 {
+		t.Errorf("result = %+v", results[0])
+	}
+	if len(results[1].Links) != 0 {
+		t.Errorf("links = %v, want none for a rule without a link", results[1].Links)
+	}
+}
+
+func TestTflintDetect(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		detect  bool
+	}{
+		{name: "issues", content: `{"issues": [{"rule": {"name": "terraform_typed_variables"}}], "errors": []}`, detect: true},
+		{name: "no issues", content: `{"issues": [], "errors": []}`, detect: true},
+		{name: "issues only", content: `{"issues": []}`, detect: false},
+		{name: "tfsec", content: `{"results": []}`, detect: false},
+		{name: "not json", content: `tflint`, detect: false},
+	}
+	for _, tt := range tests {
+		if got := (tflintAdapter{}).detect([]byte(tt.content)); got != tt.detect {
+			t.Errorf("%s: detect = %v, want %v", tt.name, got, tt.detect)
+		}
+	}
+}
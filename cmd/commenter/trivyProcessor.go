@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+type trivyReport struct {
+	SchemaVersion int           `json:"SchemaVersion"`
+	Results       []trivyResult `json:"Results"`
+}
+
+type trivyResult struct {
+	Target            string                  `json:"Target"`
+	Misconfigurations []trivyMisconfiguration `json:"Misconfigurations"`
+}
+
+type trivyMisconfiguration struct {
+	ID            string   `json:"ID"`
+	AVDID         string   `json:"AVDID"`
+	Title         string   `json:"Title"`
+	Description   string   `json:"Description"`
+	Message       string   `json:"Message"`
+	Resolution    string   `json:"Resolution"`
+	Severity      string   `json:"Severity"`
+	PrimaryURL    string   `json:"PrimaryURL"`
+	References    []string `json:"References"`
+	Status        string   `json:"Status"`
+	CauseMetadata struct {
+		Resource  string `json:"Resource"`
+		Provider  string `json:"Provider"`
+		Service   string `json:"Service"`
+		StartLine int    `json:"StartLine"`
+		EndLine   int    `json:"EndLine"`
+	} `json:"CauseMetadata"`
+}
+
+type trivyAdapter struct{}
+
+func (trivyAdapter) format() string {
+	return "trivy"
+}
+
+// detect reports whether the content looks like the JSON report from trivy config. It must be checked before
+// tfsec as both have a results key and JSON keys are matched case insensitively
+func (trivyAdapter) detect(content []byte) bool {
+	probe := struct {
+		SchemaVersion *int `json:"SchemaVersion"`
+	}{}
+	if err := json.Unmarshal(content, &probe); err != nil {
+		return false
+	}
+	return probe.SchemaVersion != nil
+}
+
+// parse maps each failed misconfiguration in a trivy report onto a result
+func (trivyAdapter) parse(content []byte) ([]result, error) {
+	var report trivyReport
+	if err := json.Unmarshal(content, &report); err != nil {
+		return nil, err
+	}
+
+	var results []result
+	for _, target := range report.Results {
+		for _, misconfig := range target.Misconfigurations {
+			// trivy can include passed checks when run with --include-non-failures
+			if misconfig.Status != "" && misconfig.Status != "FAIL" {
+				continue
+			}
+
+			cause := misconfig.CauseMetadata
+			endLine := cause.EndLine
+			if endLine < cause.StartLine {
+				endLine = cause.StartLine
+			}
+
+			results = append(results, result{
+				RuleID:          misconfig.ID,
+				RuleShortID:     misconfig.AVDID,
+				RuleDescription: misconfig.Title,
+				RuleProvider:    strings.ToLower(cause.Provider),
+				RuleService:     cause.Service,
+				Impact:          misconfig.Description,
+				Resolution:      misconfig.Resolution,
+				Links:           trivyLinks(misconfig),
+				Range: &checkRange{
+					Filename:  target.Target,
+					StartLine: cause.StartLine,
+					EndLine:   endLine,
+				},
+				Description: misconfig.Message,
+				Severity:    misconfig.Severity,
+				Resource:    cause.Resource,
+			})
+		}
+	}
+	return results, nil
+}
+
+// trivyLinks puts the primary url first followed by any other references
+func trivyLinks(misconfig trivyMisconfiguration) []string {
+	var links []string
+	if misconfig.PrimaryURL != "" {
+		links = append(links, misconfig.PrimaryURL)
+	}
+	for _, reference := range misconfig.References {
+		if reference != misconfig.PrimaryURL {
+			links = append(links, reference)
+		}
+	}
+	return links
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestTrivyParse(t *testing.T) {
+	results := parseResultsFixture(t, "trivy.json", "trivy")
+	checkParsedResults(t, results, []parsedResult{
+		// the lines come from CauseMetadata, passed checks are skipped
+		{ruleID: "AVD-AWS-0088", filename: "infra/main.tf", start: 2, end: 6, severity: "HIGH", scanner: "trivy"},
+		// a cause without an end line covers its start line
+		{ruleID: "AVD-AWS-0086", filename: "infra/main.tf", start: 4, end: 4, severity: "HIGH", scanner: "trivy"},
+		// a misconfiguration without a status is a failure
+		{ruleID: "AVD-AWS-0104", filename: "infra/vpc.tf", start: 12, end: 12, severity: "CRITICAL", scanner: "trivy"},
+	})
+
+	first := results[0]
+	if first.RuleShortID != "AVD-AWS-0088" || first.RuleDescription != "Unencrypted S3 bucket." || first.Description != "Bucket does not have encryption enabled" {
+		t.Errorf("result = %+v", first)
+	}
+	if first.RuleProvider != "aws" || first.RuleService != "s3" || first.Resource != "aws_s3_bucket.logs" || first.Resolution != "Configure bucket encryption" {
+		t.Errorf("cause = %+v", first)
+	}
+	// the primary url comes first and isn't repeated from the references
+	if len(first.Links) != 2 || first.Links[0] != "https://avd.aquasec.com/misconfig/avd-aws-0088" || first.Links[1] != "https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucket-encryption.html" {
+		t.Errorf("links = %v", first.Links)
+	}
+}
+
+func TestTrivyDetect(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		detect  bool
+	}{
+		{name: "report", content: `{"SchemaVersion": 2, "Results": []}`, detect: true},
+		// trivy leaves Results out when it finds nothing to scan
+		{name: "nothing scanned", content: `{"SchemaVersion": 2, "ArtifactName": "."}`, detect: true},
+		{name: "tfsec", content: `{"results": [{"rule_id": "AWS001"}]}`, detect: false},
+		{name: "not json", content: `trivy`, detect: false},
+	}
+	for _, tt := range tests {
+		if got := (trivyAdapter{}).detect([]byte(tt.content)); got != tt.detect {
+			t.Errorf("%s: detect = %v, want %v", tt.name, got, tt.detect)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Values for INPUT_WORKFLOW_ANNOTATIONS
+const (
+	workflowAnnotationsAuto   = "auto"
+	workflowAnnotationsAlways = "always"
+	workflowAnnotationsNever  = "never"
+)
+
+func extractWorkflowAnnotations() (string, error) {
+	mode := strings.ToLower(os.Getenv("INPUT_WORKFLOW_ANNOTATIONS"))
+	switch mode {
+	case "":
+		return workflowAnnotationsAuto, nil
+	case workflowAnnotationsAuto, workflowAnnotationsAlways, workflowAnnotationsNever:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unexpected value for INPUT_WORKFLOW_ANNOTATIONS. Expected auto, always or never, found %s", mode)
+}
+
+// isPermissionDenied reports whether a write failed because the token is read-only
+func isPermissionDenied(err error) bool {
+	var permissionErr PermissionDeniedError
+	return errors.As(err, &permissionErr) || errors.Is(err, errGraphqlForbidden)
+}
+
+// writeWorkflowAnnotations prints a workflow command for each result, which the runner turns into an annotation
+// on the line. Unlike comments these need no write access to the PR
+func writeWorkflowAnnotations(w io.Writer, results []result) error {
+	for _, result := range results {
+		properties := []string{
+			fmt.Sprintf("file=%s", escapeWorkflowProperty(result.Range.Filename)),
+			fmt.Sprintf("line=%d", result.Range.StartLine),
+			fmt.Sprintf("endLine=%d", result.Range.EndLine),
+			fmt.Sprintf("title=%s", escapeWorkflowProperty(fmt.Sprintf("%s: %s", result.Severity, result.RuleID))),
+		}
+		message := result.Description
+		if len(result.Links) > 0 {
+			message = fmt.Sprintf("%s\n%s", message, strings.Join(result.Links, "\n"))
+		}
+		if _, err := fmt.Fprintf(w, "::%s %s::%s\n", workflowCommandLevel(result.Severity),
+			strings.Join(properties, ","), escapeWorkflowData(message)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// workflowCommandLevel uses the same mapping from severity as check run annotations
+func workflowCommandLevel(severity string) string {
+	switch annotationLevel(severity) {
+	case "failure":
+		return "error"
+	case "warning":
+		return "warning"
+	default:
+		return "notice"
+	}
+}
+
+func escapeWorkflowData(value string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(value)
+}
+
+func escapeWorkflowProperty(value string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(value)
+}
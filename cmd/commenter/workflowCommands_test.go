@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEscapeWorkflowData(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "Bucket does not have encryption enabled", want: "Bucket does not have encryption enabled"},
+		{value: "100% of buckets", want: "100%25 of buckets"},
+		// an escape in the message is kept as it was written rather than decoded by the runner
+		{value: "already %0A escaped", want: "already %250A escaped"},
+		{value: "first line\nsecond line\r\nthird line", want: "first line%0Asecond line%0D%0Athird line"},
+		// only properties need colons and commas escaped
+		{value: "aws: a, b", want: "aws: a, b"},
+	}
+	for _, tt := range tests {
+		if got := escapeWorkflowData(tt.value); got != tt.want {
+			t.Errorf("escapeWorkflowData(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeWorkflowProperty(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "infra/main.tf", want: "infra/main.tf"},
+		{value: "infra/a,b.tf", want: "infra/a%2Cb.tf"},
+		{value: "C:/infra/main.tf", want: "C%3A/infra/main.tf"},
+		{value: "HIGH: aws-s3-enable-bucket-encryption", want: "HIGH%3A aws-s3-enable-bucket-encryption"},
+		{value: "infra/100%.tf", want: "infra/100%25.tf"},
+		{value: "infra/%2C.tf", want: "infra/%252C.tf"},
+		{value: "infra/main.tf\nline=1", want: "infra/main.tf%0Aline=1"},
+		{value: "infra/main.tf\r", want: "infra/main.tf%0D"},
+	}
+	for _, tt := range tests {
+		if got := escapeWorkflowProperty(tt.value); got != tt.want {
+			t.Errorf("escapeWorkflowProperty(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestWriteWorkflowAnnotations(t *testing.T) {
+	results := []result{
+		{
+			RuleID:      "aws-s3-enable-bucket-encryption",
+			Range:       &checkRange{Filename: "infra/a,b: c.tf", StartLine: 2, EndLine: 4},
+			Description: "100% unencrypted\nsee below",
+			Severity:    "HIGH",
+			Links:       []string{"https://aquasecurity.github.io/tfsec/latest/checks/aws/s3/enable-bucket-encryption/"},
+		},
+		{
+			RuleID:      "aws-s3-enable-versioning",
+			Range:       &checkRange{Filename: "infra/main.tf", StartLine: 7, EndLine: 7},
+			Description: "Bucket does not have versioning enabled",
+			Severity:    "MEDIUM",
+		},
+		{
+			RuleID:      "aws-s3-enable-bucket-logging",
+			Range:       &checkRange{Filename: "infra/main.tf", StartLine: 9, EndLine: 9},
+			Description: "Bucket does not have logging enabled",
+			Severity:    "LOW",
+		},
+	}
+
+	var out bytes.Buffer
+	if err := writeWorkflowAnnotations(&out, results); err != nil {
+		t.Fatal(err)
+	}
+	want := "::error file=infra/a%2Cb%3A c.tf,line=2,endLine=4,title=HIGH%3A aws-s3-enable-bucket-encryption::" +
+		"100%25 unencrypted%0Asee below%0Ahttps://aquasecurity.github.io/tfsec/latest/checks/aws/s3/enable-bucket-encryption/\n" +
+		"::warning file=infra/main.tf,line=7,endLine=7,title=MEDIUM%3A aws-s3-enable-versioning::Bucket does not have versioning enabled\n" +
+		"::notice file=infra/main.tf,line=9,endLine=9,title=LOW%3A aws-s3-enable-bucket-logging::Bucket does not have logging enabled\n"
+	if out.String() != want {
+		t.Errorf("annotations = %q, want %q", out.String(), want)
+	}
+}
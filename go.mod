@@ -2,4 +2,9 @@ module github.com/aquasecurity/tfsec-github-commenter-action
 
 go 1.15
 
-require github.com/owenrumney/go-github-pr-commenter v0.1.2
+require (
+	github.com/google/go-github/v32 v32.1.0
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974 // indirect
+	golang.org/x/oauth2 v0.0.0-20180821212333-d2e6202438be
+	gopkg.in/yaml.v3 v3.0.1
+)